@@ -0,0 +1,200 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// AccessControl restricts the clients and requests of a server. Clients of
+// a TcpServer must belong to an allowed network if any is added, their
+// connections are closed otherwise. Requests are denied if their function
+// is blocked, or if they write and the network of the client is read-only,
+// or the unit or range written is protected. Networks do not apply to the
+// requests of a SerialServer.
+type AccessControl struct {
+	// Exception answering denied requests, illegal function if zero
+	Exception byte
+	// Drop closes the connection of denied requests instead of answering,
+	// serial requests are not answered
+	Drop bool
+	// Audit logs denied connections and requests if set
+	Audit *log.Logger
+
+	mu        sync.RWMutex
+	networks  []accessNetwork
+	units     []byte
+	ranges    []accessRange
+	functions []byte
+}
+
+type accessNetwork struct {
+	network *net.IPNet
+	write   bool
+}
+
+type accessRange struct {
+	unitID byte
+	table  Table
+	AddressRange
+}
+
+// NewAccessControl allocates an access control allowing everything.
+func NewAccessControl() *AccessControl {
+	return &AccessControl{}
+}
+
+// AllowNetwork allows the clients of a network in CIDR notation, e.g.
+// "10.0.0.0/24", to read and to write if write is set. Clients matching
+// several networks have the access of the first one added.
+func (ac *AccessControl) AllowNetwork(cidr string, write bool) error {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("modbus: invalid network '%v': %v", cidr, err)
+	}
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.networks = append(ac.networks, accessNetwork{network, write})
+	return nil
+}
+
+// ProtectUnit denies the writes to unitID.
+func (ac *AccessControl) ProtectUnit(unitID byte) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.units = append(ac.units, unitID)
+}
+
+// ProtectRange denies the writes to a range of table, coils or holding
+// registers, of unitID, or of all units if zero.
+func (ac *AccessControl) ProtectRange(unitID byte, table Table, r AddressRange) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.ranges = append(ac.ranges, accessRange{unitID, table, r})
+}
+
+// BlockFunction denies the requests of a function code.
+func (ac *AccessControl) BlockFunction(functionCode byte) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.functions = append(ac.functions, functionCode)
+}
+
+// allowConn returns true if a client can connect, ac can be nil.
+func (ac *AccessControl) allowConn(remote net.Addr) bool {
+	if ac == nil {
+		return true
+	}
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	if _, ok := ac.network(remote); ok {
+		return true
+	}
+	ac.auditf("modbus: denied connection from %v: network not allowed", remote)
+	return false
+}
+
+// network returns the access of a client address, if it is allowed.
+// Caller must hold the mutex.
+func (ac *AccessControl) network(remote net.Addr) (write bool, ok bool) {
+	if len(ac.networks) == 0 {
+		return true, true
+	}
+	ip := addrIP(remote)
+	if ip == nil {
+		// Serial clients have no network
+		return true, true
+	}
+	for _, n := range ac.networks {
+		if n.network.Contains(ip) {
+			return n.write, true
+		}
+	}
+	return false, false
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// deny returns the response to a denied request or nil if it is allowed,
+// and whether the connection must be closed. ac can be nil.
+func (ac *AccessControl) deny(pdu *PDUwithSlaveid, remote net.Addr, name string) (response *PDUwithSlaveid, drop bool) {
+	if ac == nil {
+		return nil, false
+	}
+	reason := ac.reason(pdu, remote)
+	if reason == "" {
+		return nil, false
+	}
+	ac.auditf("modbus: denied request of %v unit id '%v' function '%v': %v", name, pdu.SlaveID, pdu.FunctionCode, reason)
+	if ac.Drop {
+		return nil, true
+	}
+	exception := ac.Exception
+	if exception == 0 {
+		exception = ExceptionCodeIllegalFunction
+	}
+	return encodeMbError(pdu.SlaveID, pdu.FunctionCode, exception), false
+}
+
+// reason returns why a request is denied, empty if it is allowed.
+func (ac *AccessControl) reason(pdu *PDUwithSlaveid, remote net.Addr) string {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	write, ok := ac.network(remote)
+	if !ok {
+		return "network not allowed"
+	}
+	for _, fc := range ac.functions {
+		if fc == pdu.FunctionCode {
+			return "function blocked"
+		}
+	}
+	switch pdu.FunctionCode {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteMultipleCoils, FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleRegisters, FuncCodeMaskWriteRegister, FuncCodeReadWriteMultipleRegisters:
+	default:
+		return ""
+	}
+	if !write {
+		return "network is read-only"
+	}
+	for _, unit := range ac.units {
+		if unit == pdu.SlaveID {
+			return "unit is write protected"
+		}
+	}
+	r, ok := writeRange(pdu)
+	if !ok {
+		return ""
+	}
+	last := r.address + r.quantity - 1
+	if r.quantity == 0 {
+		last = r.address
+	}
+	for _, p := range ac.ranges {
+		if (p.unitID == 0 || p.unitID == pdu.SlaveID) && p.table == r.table &&
+			r.address <= int(p.End) && int(p.Start) <= last {
+			return fmt.Sprintf("%v %v-%v are write protected", p.table, p.Start, p.End)
+		}
+	}
+	return ""
+}
+
+func (ac *AccessControl) auditf(format string, v ...interface{}) {
+	if ac.Audit != nil {
+		ac.Audit.Printf(format, v...)
+	}
+}
@@ -0,0 +1,115 @@
+package modbus
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessControlDeny(t *testing.T) {
+	ac := NewAccessControl()
+	if err := ac.AllowNetwork("10.0.1.0/24", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.AllowNetwork("10.0.0.0/16", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.AllowNetwork("10.0.0.0", false); err == nil {
+		t.Fatal("invalid network: error expected")
+	}
+	ac.ProtectUnit(9)
+	ac.ProtectRange(0, TableHoldingRegisters, AddressRange{100, 199})
+	ac.ProtectRange(2, TableCoils, AddressRange{0, 7})
+	ac.BlockFunction(FuncCodeReadFIFOQueue)
+	ac.Exception = ExceptionCodeIllegalDataAddress
+	var audit bytes.Buffer
+	ac.Audit = log.New(&audit, "", 0)
+
+	engineering := &net.TCPAddr{IP: net.ParseIP("10.0.1.5"), Port: 1000}
+	operator := &net.TCPAddr{IP: net.ParseIP("10.0.2.5"), Port: 1000}
+	outside := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1000}
+	request := func(unit, fc byte, data ...byte) *PDUwithSlaveid {
+		return &PDUwithSlaveid{unit, ProtocolDataUnit{FunctionCode: fc, Data: data}}
+	}
+	for i, c := range []struct {
+		pdu    *PDUwithSlaveid
+		remote net.Addr
+		denied bool
+	}{
+		{request(1, FuncCodeReadHoldingRegisters, 0, 100, 0, 1), operator, false},
+		{request(1, FuncCodeWriteSingleRegister, 0, 0, 0, 1), operator, true},
+		{request(1, FuncCodeWriteSingleRegister, 0, 0, 0, 1), engineering, false},
+		{request(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 1), outside, true},
+		{request(9, FuncCodeWriteSingleCoil, 0, 0, 0xFF, 0), engineering, true},
+		{request(1, FuncCodeWriteMultipleRegisters, 0, 98, 0, 2, 4, 0, 0, 0, 0), engineering, false},
+		{request(1, FuncCodeWriteMultipleRegisters, 0, 99, 0, 2, 4, 0, 0, 0, 0), engineering, true},
+		{request(3, FuncCodeReadWriteMultipleRegisters, 0, 100, 0, 1, 0, 10, 0, 1, 2, 0, 0), engineering, false},
+		{request(3, FuncCodeReadWriteMultipleRegisters, 0, 0, 0, 1, 0, 150, 0, 1, 2, 0, 0), engineering, true},
+		{request(1, FuncCodeWriteSingleCoil, 0, 7, 0xFF, 0), engineering, false},
+		{request(2, FuncCodeWriteSingleCoil, 0, 7, 0xFF, 0), engineering, true},
+		{request(1, FuncCodeReadFIFOQueue, 0, 0), engineering, true},
+		// Serial requests have no network
+		{request(1, FuncCodeWriteSingleRegister, 0, 0, 0, 1), nil, false},
+	} {
+		response, drop := ac.deny(c.pdu, c.remote, "client")
+		if drop || (response != nil) != c.denied {
+			t.Errorf("%v: denied expected %v, actual %+v, %v", i, c.denied, response, drop)
+		}
+		if response != nil && (response.FunctionCode != c.pdu.FunctionCode|0x80 || response.Data[0] != ExceptionCodeIllegalDataAddress) {
+			t.Errorf("%v: unexpected response %+v", i, response)
+		}
+	}
+	if !strings.Contains(audit.String(), "unit id '1' function '16': holding_registers 100-199 are write protected") {
+		t.Fatalf("unexpected audit log:\n%v", audit.String())
+	}
+	var nilAccess *AccessControl
+	if response, drop := nilAccess.deny(request(1, FuncCodeWriteSingleCoil, 0, 0, 0, 0), outside, "client"); response != nil || drop {
+		t.Fatal("nil access control denied a request")
+	}
+}
+
+func TestTcpServerAccess(t *testing.T) {
+	server, err := NewTcpServerAddress("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.Access = NewAccessControl()
+	server.Access.AllowNetwork("127.0.0.0/8", false)
+	go server.ServeModbus(NewDataModel(0, 0, 10, 0))
+	client := clientOf(server.Addr().String())
+	if _, err = client.ReadHoldingRegisters(1, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.WriteSingleRegister(1, 0, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalFunction {
+		t.Fatalf("illegal function expected, actual %v", err)
+	}
+	// Dropped requests close the connection
+	server.Access.Drop = true
+	if _, err = client.WriteSingleRegister(1, 0, 1); err == nil {
+		t.Fatal("error expected")
+	}
+
+	// Clients outside the allowed networks are disconnected
+	denied, err := NewTcpServerAddress("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer denied.Close()
+	denied.Access = NewAccessControl()
+	denied.Access.AllowNetwork("10.0.0.0/8", true)
+	go denied.ServeModbus(NewDataModel(0, 0, 10, 0))
+	conn, err := net.Dial("tcp", denied.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection not closed")
+	}
+}
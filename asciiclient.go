@@ -9,6 +9,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"time"
+
+	"github.com/tarm/serial"
 )
 
 const (
@@ -30,8 +32,13 @@ type ASCIIClientHandler struct {
 func NewASCIIClientHandler(address string) *ASCIIClientHandler {
 	handler := &ASCIIClientHandler{}
 	handler.Name = address
+	handler.transport = transportASCII
 	handler.ReadTimeout = serialTimeout
 	handler.IdleTimeout = serialIdleTimeout
+	handler.Baud = 19200
+	handler.Size = 7
+	handler.Parity = serial.ParityEven
+	handler.StopBits = 1
 	return handler
 }
 
@@ -149,7 +156,7 @@ func (mb *asciiPackager) Decode(adu []byte) (pdu *PDUwithSlaveid, err error) {
 	lrc.reset()
 	lrc.pushByte(address).pushByte(pdu.FunctionCode).pushBytes(pdu.Data)
 	if lrcVal != lrc.value() {
-		err = fmt.Errorf("modbus: response lrc '%v' does not match expected '%v'", lrcVal, lrc.value())
+		err = &ChecksumError{Framing: FramingASCII, Checksum: uint16(lrcVal), Expected: uint16(lrc.value())}
 		return
 	}
 	return
@@ -174,6 +181,7 @@ func (mb *asciiSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, e
 
 	// Send the request
 	mb.serialPort.logf("modbus: sending %q\n", aduRequest)
+	mb.serialPort.slogFrame("modbus: sending", aduRequest, 0)
 	if _, err = mb.port.Write(aduRequest); err != nil {
 		return
 	}
@@ -198,6 +206,7 @@ func (mb *asciiSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, e
 	}
 	aduResponse = data[:length]
 	mb.serialPort.logf("modbus: received %q\n", aduResponse)
+	mb.serialPort.slogFrame("modbus: received", aduResponse, time.Since(mb.serialPort.lastActivity))
 	return
 }
 
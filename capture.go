@@ -0,0 +1,341 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// pcapng block types and options.
+// See https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html
+const (
+	pcapngSectionHeaderBlock  = 0x0A0D0D0A
+	pcapngInterfaceBlock      = 0x00000001
+	pcapngEnhancedPacketBlock = 0x00000006
+	pcapngByteOrderMagic      = 0x1A2B3C4D
+	pcapngOptionEndOfOptions  = 0
+	pcapngOptionEPBFlags      = 2
+	pcapngOptionIfTsresol     = 9
+	pcapngFlagInbound         = 1
+	pcapngFlagOutbound        = 2
+	pcapngSnapLength          = 65535
+)
+
+const (
+	linkTypeEthernet uint16 = 1
+	// Serial frames are stored as raw bytes in the user link types, map
+	// DLT_USER0 to "mbrtu" in Wireshark to decode RTU frames.
+	linkTypeUser0 uint16 = 147
+	linkTypeUser1 uint16 = 148
+
+	// Default endpoints of synthesized TCP streams.
+	captureClientPort = 49152
+	captureServerPort = 502
+)
+
+var (
+	captureClientMAC = []byte{0x02, 0, 0, 0, 0, 0x01}
+	captureServerMAC = []byte{0x02, 0, 0, 0, 0, 0x02}
+)
+
+// CaptureWriter writes modbus traffic to a pcapng stream which can be
+// opened in Wireshark. TCP traffic is written as synthesized
+// Ethernet/IP/TCP frames, RTU frames use DLT_USER0 and ASCII frames DLT_USER1.
+// It is safe for concurrent use.
+type CaptureWriter struct {
+	mu         sync.Mutex
+	w          io.Writer
+	interfaces map[uint16]uint32
+	flows      map[string]*captureFlow
+}
+
+// captureFlow keeps sequence numbers of a synthesized TCP connection.
+type captureFlow struct {
+	clientSeq uint32
+	serverSeq uint32
+}
+
+// NewCaptureWriter writes the pcapng section header to w and returns
+// a writer for captured frames.
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	cw := &CaptureWriter{
+		w:          w,
+		interfaces: make(map[uint16]uint32),
+		flows:      make(map[string]*captureFlow),
+	}
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body, pcapngByteOrderMagic)
+	// Version 1.0
+	binary.LittleEndian.PutUint16(body[4:], 1)
+	binary.LittleEndian.PutUint16(body[6:], 0)
+	// Section length is not specified
+	binary.LittleEndian.PutUint64(body[8:], 0xFFFFFFFFFFFFFFFF)
+	if err := cw.writeBlock(pcapngSectionHeaderBlock, body); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// WriteTCP writes a modbus TCP adu sent from client to server when
+// fromClient is true, otherwise from server to client. CloseTCP must be
+// called when the connection is closed.
+// Addresses which are not *net.TCPAddr are replaced by loopback addresses.
+func (cw *CaptureWriter) WriteTCP(client, server net.Addr, fromClient bool, adu []byte, timestamp time.Time) error {
+	clientAddr := captureTCPAddr(client, captureClientPort)
+	serverAddr := captureTCPAddr(server, captureServerPort)
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	key := captureFlowKey(clientAddr, serverAddr)
+	flow := cw.flows[key]
+	if flow == nil {
+		flow = &captureFlow{clientSeq: 1, serverSeq: 1}
+		cw.flows[key] = flow
+	}
+	var frame []byte
+	if fromClient {
+		frame = tcpFrame(captureClientMAC, captureServerMAC, clientAddr, serverAddr, flow.clientSeq, flow.serverSeq, adu)
+		flow.clientSeq += uint32(len(adu))
+	} else {
+		frame = tcpFrame(captureServerMAC, captureClientMAC, serverAddr, clientAddr, flow.serverSeq, flow.clientSeq, adu)
+		flow.serverSeq += uint32(len(adu))
+	}
+	// Direction is given by the addresses
+	return cw.writePacket(linkTypeEthernet, frame, 0, timestamp)
+}
+
+// CloseTCP forgets the sequence numbers of a connection once it is closed.
+func (cw *CaptureWriter) CloseTCP(client, server net.Addr) {
+	key := captureFlowKey(captureTCPAddr(client, captureClientPort), captureTCPAddr(server, captureServerPort))
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	delete(cw.flows, key)
+}
+
+func captureFlowKey(client, server *net.TCPAddr) string {
+	return client.String() + ">" + server.String()
+}
+
+// WriteSerial writes an RTU or ASCII adu. Outbound is true for frames sent
+// by the capturing side, it is stored in the packet direction flags.
+func (cw *CaptureWriter) WriteSerial(framing Framing, outbound bool, adu []byte, timestamp time.Time) error {
+	linkType := linkTypeUser0
+	if framing == FramingASCII {
+		linkType = linkTypeUser1
+	}
+	flags := uint32(pcapngFlagInbound)
+	if outbound {
+		flags = pcapngFlagOutbound
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.writePacket(linkType, adu, flags, timestamp)
+}
+
+// writePacket writes an enhanced packet block, adding the interface
+// description block of the link type if needed. Direction flags are
+// omitted if zero. Caller must hold the mutex.
+func (cw *CaptureWriter) writePacket(linkType uint16, data []byte, flags uint32, timestamp time.Time) error {
+	id, ok := cw.interfaces[linkType]
+	if !ok {
+		id = uint32(len(cw.interfaces))
+		// Link type, reserved, snap length and if_tsresol = 10^-9
+		body := make([]byte, 8, 20)
+		binary.LittleEndian.PutUint16(body, linkType)
+		binary.LittleEndian.PutUint32(body[4:], pcapngSnapLength)
+		body = appendOption(body, pcapngOptionIfTsresol, []byte{9})
+		body = appendOption(body, pcapngOptionEndOfOptions, nil)
+		if err := cw.writeBlock(pcapngInterfaceBlock, body); err != nil {
+			return err
+		}
+		cw.interfaces[linkType] = id
+	}
+	ts := uint64(timestamp.UnixNano())
+	body := make([]byte, 20, 20+len(data)+3+16)
+	binary.LittleEndian.PutUint32(body, id)
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(data)))
+	body = append(body, data...)
+	body = appendPadding(body)
+	if flags != 0 {
+		var flagsValue [4]byte
+		binary.LittleEndian.PutUint32(flagsValue[:], flags)
+		body = appendOption(body, pcapngOptionEPBFlags, flagsValue[:])
+	}
+	body = appendOption(body, pcapngOptionEndOfOptions, nil)
+	return cw.writeBlock(pcapngEnhancedPacketBlock, body)
+}
+
+// writeBlock writes a block with its type and total length around body.
+func (cw *CaptureWriter) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+	block := make([]byte, length)
+	binary.LittleEndian.PutUint32(block, blockType)
+	binary.LittleEndian.PutUint32(block[4:], length)
+	copy(block[8:], body)
+	binary.LittleEndian.PutUint32(block[length-4:], length)
+	_, err := cw.w.Write(block)
+	return err
+}
+
+// appendOption appends an option with its value padded to 32 bits.
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	var header [4]byte
+	binary.LittleEndian.PutUint16(header[:], code)
+	binary.LittleEndian.PutUint16(header[2:], uint16(len(value)))
+	b = append(b, header[:]...)
+	b = append(b, value...)
+	return appendPadding(b)
+}
+
+func appendPadding(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func captureTCPAddr(addr net.Addr, port int) *net.TCPAddr {
+	if a, ok := addr.(*net.TCPAddr); ok && a.IP != nil {
+		return a
+	}
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
+
+// tcpFrame synthesizes an Ethernet frame carrying payload in a TCP segment
+// with PSH and ACK flags.
+func tcpFrame(srcMAC, dstMAC []byte, src, dst *net.TCPAddr, seq, ack uint32, payload []byte) []byte {
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	ipv4 := srcIP != nil && dstIP != nil
+	if !ipv4 {
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+	}
+	segment := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(segment, uint16(src.Port))
+	binary.BigEndian.PutUint16(segment[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	binary.BigEndian.PutUint32(segment[8:], ack)
+	// Data offset 5 words, flags PSH|ACK
+	segment[12] = 5 << 4
+	segment[13] = 0x18
+	binary.BigEndian.PutUint16(segment[14:], 0xFFFF)
+	copy(segment[20:], payload)
+
+	// Pseudo header for TCP checksum
+	pseudo := make([]byte, 0, 40)
+	pseudo = append(pseudo, srcIP...)
+	pseudo = append(pseudo, dstIP...)
+	var proto [8]byte
+	if ipv4 {
+		binary.BigEndian.PutUint16(proto[:], 6)
+		binary.BigEndian.PutUint16(proto[2:], uint16(len(segment)))
+		pseudo = append(pseudo, proto[:4]...)
+	} else {
+		binary.BigEndian.PutUint32(proto[:], uint32(len(segment)))
+		proto[7] = 6
+		pseudo = append(pseudo, proto[:]...)
+	}
+	binary.BigEndian.PutUint16(segment[16:], internetChecksum(pseudo, segment))
+
+	frame := make([]byte, 0, 14+40+len(segment))
+	frame = append(frame, dstMAC...)
+	frame = append(frame, srcMAC...)
+	if ipv4 {
+		frame = append(frame, 0x08, 0x00)
+		header := make([]byte, 20)
+		header[0] = 0x45
+		binary.BigEndian.PutUint16(header[2:], uint16(20+len(segment)))
+		// Don't fragment
+		header[6] = 0x40
+		header[8] = 64
+		header[9] = 6
+		copy(header[12:], srcIP)
+		copy(header[16:], dstIP)
+		binary.BigEndian.PutUint16(header[10:], internetChecksum(header))
+		frame = append(frame, header...)
+	} else {
+		frame = append(frame, 0x86, 0xDD)
+		header := make([]byte, 40)
+		header[0] = 0x60
+		binary.BigEndian.PutUint16(header[4:], uint16(len(segment)))
+		header[6] = 6
+		header[7] = 64
+		copy(header[8:], srcIP)
+		copy(header[24:], dstIP)
+		frame = append(frame, header...)
+	}
+	return append(frame, segment...)
+}
+
+// internetChecksum computes the one's complement checksum of RFC 1071.
+func internetChecksum(data ...[]byte) uint16 {
+	var sum uint32
+	var odd bool
+	var last byte
+	for _, b := range data {
+		for _, v := range b {
+			if odd {
+				sum += uint32(last)<<8 | uint32(v)
+			} else {
+				last = v
+			}
+			odd = !odd
+		}
+	}
+	if odd {
+		sum += uint32(last) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// CaptureTransporter writes every request and response of the underlying
+// transporter to a capture.
+type CaptureTransporter struct {
+	Transporter Transporter
+	Capture     *CaptureWriter
+	Framing     Framing
+	// Client and Server are the endpoints of synthesized TCP frames,
+	// loopback addresses and port 502 are used if not set.
+	Client net.Addr
+	Server net.Addr
+}
+
+// NewCaptureTransporter allocates a CaptureTransporter writing to capture.
+func NewCaptureTransporter(transporter Transporter, capture *CaptureWriter, framing Framing) *CaptureTransporter {
+	return &CaptureTransporter{
+		Transporter: transporter,
+		Capture:     capture,
+		Framing:     framing,
+	}
+}
+
+// Send captures the request, sends it and captures the response if any.
+// Capture errors do not fail the transaction.
+func (mb *CaptureTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	mb.write(true, aduRequest)
+	aduResponse, err = mb.Transporter.Send(aduRequest)
+	if len(aduResponse) > 0 {
+		mb.write(false, aduResponse)
+	}
+	return
+}
+
+func (mb *CaptureTransporter) write(outbound bool, adu []byte) {
+	if mb.Framing == FramingTCP {
+		mb.Capture.WriteTCP(mb.Client, mb.Server, outbound, adu, time.Now())
+	} else {
+		mb.Capture.WriteSerial(mb.Framing, outbound, adu, time.Now())
+	}
+}
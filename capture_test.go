@@ -0,0 +1,139 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+type pcapngBlock struct {
+	blockType uint32
+	body      []byte
+}
+
+func readPcapngBlocks(t *testing.T, data []byte) (blocks []pcapngBlock) {
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block: %x", data)
+		}
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) || binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatalf("invalid block length %v", length)
+		}
+		blocks = append(blocks, pcapngBlock{binary.LittleEndian.Uint32(data), data[8 : length-4]})
+		data = data[length:]
+	}
+	return
+}
+
+func TestCaptureTransporter(t *testing.T) {
+	var buf bytes.Buffer
+	capture, err := NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := []byte{0, 1, 0, 0, 0, 5, 1, 3, 2, 0, 7}
+	transporter := NewCaptureTransporter(funcTransporter(func(aduRequest []byte) ([]byte, error) {
+		return response, nil
+	}), capture, FramingTCP)
+	transporter.Server = &net.TCPAddr{IP: net.IPv4(192, 168, 0, 10), Port: 502}
+	request := []byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1}
+	if _, err = transporter.Send(request); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := readPcapngBlocks(t, buf.Bytes())
+	if len(blocks) != 4 {
+		t.Fatalf("expected 4 blocks, actual %v", len(blocks))
+	}
+	if blocks[0].blockType != pcapngSectionHeaderBlock || binary.LittleEndian.Uint32(blocks[0].body) != pcapngByteOrderMagic {
+		t.Fatalf("invalid section header: %x", blocks[0].body)
+	}
+	if blocks[1].blockType != pcapngInterfaceBlock || binary.LittleEndian.Uint16(blocks[1].body) != linkTypeEthernet {
+		t.Fatalf("invalid interface block: %x", blocks[1].body)
+	}
+	for i, adu := range [][]byte{request, response} {
+		block := blocks[2+i]
+		if block.blockType != pcapngEnhancedPacketBlock {
+			t.Fatalf("invalid packet block type: %x", block.blockType)
+		}
+		length := binary.LittleEndian.Uint32(block.body[12:])
+		frame := block.body[20 : 20+length]
+		// Ethernet + IPv4 + TCP headers
+		if len(frame) != 14+20+20+len(adu) {
+			t.Fatalf("unexpected frame length %v", len(frame))
+		}
+		if internetChecksum(frame[14:34]) != 0 {
+			t.Fatalf("invalid ip checksum: %x", frame[14:34])
+		}
+		if !bytes.Equal(adu, frame[54:]) {
+			t.Fatalf("payload expected %x, actual %x", adu, frame[54:])
+		}
+	}
+	// Request is sent to the server port, response is sent back
+	if binary.BigEndian.Uint16(blocks[2].body[20+14+20+2:]) != 502 || binary.BigEndian.Uint16(blocks[3].body[20+14+20:]) != 502 {
+		t.Fatal("invalid tcp ports")
+	}
+}
+
+func TestCaptureWriterSerial(t *testing.T) {
+	var buf bytes.Buffer
+	capture, err := NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adu := []byte{0x01, 0x03, 0x50, 0x00, 0x00, 0x18, 0x54, 0xC0}
+	ts := time.Unix(1500000000, 123456789)
+	if err = capture.WriteSerial(FramingRTU, true, adu, ts); err != nil {
+		t.Fatal(err)
+	}
+	blocks := readPcapngBlocks(t, buf.Bytes())
+	if len(blocks) != 3 || binary.LittleEndian.Uint16(blocks[1].body) != linkTypeUser0 {
+		t.Fatalf("unexpected blocks: %+v", blocks)
+	}
+	body := blocks[2].body
+	nanos := uint64(binary.LittleEndian.Uint32(body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:]))
+	if nanos != uint64(ts.UnixNano()) {
+		t.Fatalf("timestamp expected %v, actual %v", ts.UnixNano(), nanos)
+	}
+	if !bytes.Equal(adu, body[20:28]) {
+		t.Fatalf("payload expected %x, actual %x", adu, body[20:28])
+	}
+	// epb_flags option follows the padded packet data
+	if binary.LittleEndian.Uint16(body[28:]) != pcapngOptionEPBFlags || binary.LittleEndian.Uint32(body[32:]) != pcapngFlagOutbound {
+		t.Fatalf("invalid direction flags: %x", body[28:])
+	}
+}
+
+func TestCaptureWriterCloseTCP(t *testing.T) {
+	capture, err := NewCaptureWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewTcpServerAddress("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.Capture = capture
+	go server.ServeModbus(NewDataModel(0, 0, 10, 0))
+	for i := 0; i < 3; i++ {
+		handler := NewTCPClientHandler(server.Addr().String())
+		if _, err = NewClient(handler).ReadHoldingRegisters(1, 0, 1); err != nil {
+			t.Fatal(err)
+		}
+		handler.Close()
+	}
+	for start := time.Now(); server.Connections() > 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("connections not closed")
+		}
+	}
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+	if len(capture.flows) != 0 {
+		t.Fatalf("flows of closed connections: %v", len(capture.flows))
+	}
+}
@@ -18,16 +18,40 @@ type ClientHandler interface {
 type client struct {
 	packager    Packager
 	transporter Transporter
+	observer    Observer
+	retries     int
+}
+
+// ClientOption sets an optional parameter of a client.
+type ClientOption func(*client)
+
+// WithObserver reports every request of the client to observer.
+func WithObserver(observer Observer) ClientOption {
+	return func(mb *client) {
+		mb.observer = observer
+	}
+}
+
+// WithRetries sends a request up to n more times when the transmission
+// fails or the response is invalid. Exception responses are not retried.
+func WithRetries(n int) ClientOption {
+	return func(mb *client) {
+		mb.retries = n
+	}
 }
 
 // NewClient creates a new modbus client with given backend handler.
-func NewClient(handler ClientHandler) Client {
-	return &client{packager: handler, transporter: handler}
+func NewClient(handler ClientHandler, options ...ClientOption) Client {
+	return NewClient2(handler, handler, options...)
 }
 
 // NewClient2 creates a new modbus client with given backend packager and transporter.
-func NewClient2(packager Packager, transporter Transporter) Client {
-	return &client{packager: packager, transporter: transporter}
+func NewClient2(packager Packager, transporter Transporter, options ...ClientOption) Client {
+	mb := &client{packager: packager, transporter: transporter}
+	for _, option := range options {
+		option(mb)
+	}
+	return mb
 }
 
 // Request:
@@ -412,7 +436,6 @@ func (mb *client) ReadWriteMultipleRegisters(slaveid byte, readAddress, readQuan
 // Response:
 //  Function code         : 1 byte (0x18)
 //  Byte count            : 2 bytes
-//  FIFO count            : 2 bytes
 //  FIFO count            : 2 bytes (<=31)
 //  FIFO value register   : Nx2 bytes
 func (mb *client) ReadFIFOQueue(slaveid byte, address uint16) (results []byte, err error) {
@@ -430,8 +453,8 @@ func (mb *client) ReadFIFOQueue(slaveid byte, address uint16) (results []byte, e
 		return
 	}
 	count := int(binary.BigEndian.Uint16(response.Data))
-	if count != (len(response.Data) - 1) {
-		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(response.Data)-1, count)
+	if count != (len(response.Data) - 2) {
+		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(response.Data)-2, count)
 		return
 	}
 	count = int(binary.BigEndian.Uint16(response.Data[2:]))
@@ -451,7 +474,30 @@ func (mb *client) send(request *PDUwithSlaveid) (response *PDUwithSlaveid, err e
 	if err != nil {
 		return
 	}
-	aduResponse, err := mb.transporter.Send(aduRequest)
+	for retry := 0; ; retry++ {
+		response, err = mb.transact(request, aduRequest, retry)
+		if err == nil || retry >= mb.retries {
+			return
+		}
+		if _, ok := err.(*ModbusError); ok {
+			return
+		}
+	}
+}
+
+// transact makes one attempt to send the request and reports it to the observer.
+func (mb *client) transact(request *PDUwithSlaveid, aduRequest []byte, retry int) (response *PDUwithSlaveid, err error) {
+	var aduResponse []byte
+	if mb.observer != nil {
+		o := newObservation(request, aduRequest)
+		o.Retry = retry
+		mb.observer.RequestStart(o)
+		defer func() {
+			o.end(aduResponse, err)
+			mb.observer.RequestEnd(o)
+		}()
+	}
+	aduResponse, err = mb.transporter.Send(aduRequest)
 	if err != nil {
 		return
 	}
@@ -497,7 +543,7 @@ func dataBlockSuffix(suffix []byte, value ...uint16) []byte {
 }
 
 func responseError(response *PDUwithSlaveid) error {
-	mbError := &ModbusError{FunctionCode: response.FunctionCode}
+	mbError := &ModbusError{SlaveID: response.SlaveID, FunctionCode: response.FunctionCode}
 	if response.Data != nil && len(response.Data) > 0 {
 		mbError.ExceptionCode = response.Data[0]
 	}
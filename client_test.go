@@ -0,0 +1,24 @@
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestClientReadFIFOQueue(t *testing.T) {
+	packager := &rtuPackager{}
+	client := NewClient2(packager, funcTransporter(func(aduRequest []byte) ([]byte, error) {
+		// Byte count 6, FIFO count 2
+		return packager.Encode(&PDUwithSlaveid{1, ProtocolDataUnit{
+			FunctionCode: FuncCodeReadFIFOQueue,
+			Data:         []byte{0, 6, 0, 2, 0x12, 0x34, 0x56, 0x78},
+		}})
+	}))
+	results, err := client.ReadFIFOQueue(1, 0x04DE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x12, 0x34, 0x56, 0x78}, results) {
+		t.Fatalf("unexpected results % x", results)
+	}
+}
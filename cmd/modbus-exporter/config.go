@@ -0,0 +1,77 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// config is the register map of the exporter:
+//
+//	{
+//	  "interval": "10s",
+//	  "devices": [{
+//	    "name": "boiler",
+//	    "url": "tcp://10.0.0.5:502",
+//	    "unit": 1,
+//	    "points": [
+//	      {"name": "temperature", "table": "holding_registers", "address": 100,
+//	       "type": "int16", "scale": 0.1},
+//	      {"name": "pump_running", "table": "coils", "address": 3}
+//	    ]
+//	  }]
+//	}
+type config struct {
+	// Interval between polls, 10s if empty
+	Interval string         `json:"interval"`
+	Devices  []deviceConfig `json:"devices"`
+}
+
+type deviceConfig struct {
+	Name string `json:"name"`
+	// URL as accepted by modbus.NewURLClientHandler
+	URL    string        `json:"url"`
+	Unit   byte          `json:"unit"`
+	Points []pointConfig `json:"points"`
+}
+
+type pointConfig struct {
+	Name    string `json:"name"`
+	Table   string `json:"table"`
+	Address uint16 `json:"address"`
+	// Value type and byte order of registers, uint16 and abcd if empty
+	Type  string `json:"type"`
+	Order string `json:"order"`
+	// Scale multiplies the value if not zero
+	Scale float64 `json:"scale"`
+}
+
+const defaultInterval = 10 * time.Second
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid configuration %v: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *config) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return defaultInterval, nil
+	}
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", c.Interval)
+	}
+	return interval, nil
+}
@@ -0,0 +1,397 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mythay/modbus"
+)
+
+// Upper bounds of the request latency histogram, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// exporter polls devices and writes their points in the Prometheus text
+// format.
+type exporter struct {
+	interval time.Duration
+	devices  []*device
+	logger   *log.Logger
+}
+
+// device is a polled device, it observes the requests of its client.
+type device struct {
+	name    string
+	unit    byte
+	serial  bool
+	handler modbus.URLHandler
+	client  modbus.Client
+	points  []*point
+
+	mu    sync.Mutex
+	up    bool
+	stats requestStats
+}
+
+type point struct {
+	name    string
+	table   modbus.Table
+	address uint16
+	typ     modbus.ValueType
+	order   modbus.ValueOrder
+	scale   float64
+	// value is valid if the last poll succeeded
+	value float64
+	valid bool
+}
+
+// requestStats are the self metrics of a device.
+type requestStats struct {
+	buckets    []uint64
+	sum        float64
+	count      uint64
+	timeouts   uint64
+	crcErrors  uint64
+	errors     uint64
+	exceptions map[byte]uint64
+}
+
+// newExporter opens the devices of c.
+func newExporter(c *config, logger *log.Logger) (_ *exporter, err error) {
+	e := &exporter{logger: logger}
+	if e.interval, err = c.interval(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			e.close()
+		}
+	}()
+	names := make(map[string]bool)
+	for i := range c.Devices {
+		dc := &c.Devices[i]
+		if dc.Name == "" || names[dc.Name] {
+			return nil, fmt.Errorf("missing or duplicate device name %q", dc.Name)
+		}
+		names[dc.Name] = true
+		d := &device{name: dc.Name, unit: dc.Unit}
+		if d.handler, err = modbus.NewURLClientHandler(dc.URL); err != nil {
+			return nil, fmt.Errorf("device %v: %v", dc.Name, err)
+		}
+		e.devices = append(e.devices, d)
+		if u, err := url.Parse(dc.URL); err == nil {
+			d.serial = u.Scheme != "tcp"
+		}
+		d.client = modbus.NewClient(d.handler, modbus.WithObserver(d))
+		d.stats.buckets = make([]uint64, len(latencyBuckets))
+		d.stats.exceptions = make(map[byte]uint64)
+		for j := range dc.Points {
+			p, err := newPoint(&dc.Points[j])
+			if err != nil {
+				return nil, fmt.Errorf("device %v: %v", dc.Name, err)
+			}
+			d.points = append(d.points, p)
+		}
+	}
+	return e, nil
+}
+
+func newPoint(pc *pointConfig) (p *point, err error) {
+	if pc.Name == "" {
+		return nil, fmt.Errorf("point at address %v has no name", pc.Address)
+	}
+	p = &point{name: pc.Name, address: pc.Address, typ: modbus.TypeUint16, scale: pc.Scale}
+	if p.table, err = modbus.ParseTable(pc.Table); err != nil {
+		return nil, fmt.Errorf("point %v: %v", pc.Name, err)
+	}
+	if pc.Type != "" {
+		if p.typ, err = modbus.ParseValueType(pc.Type); err != nil {
+			return nil, fmt.Errorf("point %v: %v", pc.Name, err)
+		}
+	}
+	if p.order, err = modbus.ParseValueOrder(pc.Order); err != nil {
+		return nil, fmt.Errorf("point %v: %v", pc.Name, err)
+	}
+	return p, nil
+}
+
+func (e *exporter) close() {
+	for _, d := range e.devices {
+		d.handler.Close()
+	}
+}
+
+// run polls the devices every interval until stop is closed.
+func (e *exporter) run(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, d := range e.devices {
+		wg.Add(1)
+		go func(d *device) {
+			defer wg.Done()
+			ticker := time.NewTicker(e.interval)
+			defer ticker.Stop()
+			for {
+				d.poll(e.logger)
+				select {
+				case <-ticker.C:
+				case <-stop:
+					return
+				}
+			}
+		}(d)
+	}
+	wg.Wait()
+}
+
+// poll reads all points of the device.
+func (d *device) poll(logger *log.Logger) {
+	up := false
+	for _, p := range d.points {
+		value, err := d.read(p)
+		d.mu.Lock()
+		p.value, p.valid = value, err == nil
+		d.mu.Unlock()
+		if err != nil {
+			if logger != nil {
+				logger.Printf("%v %v: %v", d.name, p.name, err)
+			}
+			continue
+		}
+		up = true
+	}
+	d.mu.Lock()
+	d.up = up
+	d.mu.Unlock()
+}
+
+// read returns the scaled value of a point.
+func (d *device) read(p *point) (value float64, err error) {
+	var results []byte
+	switch p.table {
+	case modbus.TableCoils, modbus.TableDiscreteInputs:
+		if p.table == modbus.TableCoils {
+			results, err = d.client.ReadCoils(d.unit, p.address, 1)
+		} else {
+			results, err = d.client.ReadDiscreteInputs(d.unit, p.address, 1)
+		}
+		if err == nil {
+			value = float64(results[0] & 1)
+		}
+		return
+	case modbus.TableHoldingRegisters:
+		results, err = d.client.ReadHoldingRegisters(d.unit, p.address, uint16(p.typ.Registers()))
+	case modbus.TableInputRegisters:
+		results, err = d.client.ReadInputRegisters(d.unit, p.address, uint16(p.typ.Registers()))
+	}
+	if err != nil {
+		return
+	}
+	values, err := modbus.DecodeValues(results, p.typ, p.order)
+	if err != nil {
+		return
+	}
+	value = toFloat(values[0])
+	if p.scale != 0 {
+		value *= p.scale
+	}
+	return
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case uint16:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// RequestStart implements modbus.Observer.
+func (d *device) RequestStart(o *modbus.Observation) {
+}
+
+// RequestEnd implements modbus.Observer.
+func (d *device) RequestEnd(o *modbus.Observation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := &d.stats
+	seconds := o.Duration.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += seconds
+	s.count++
+	if o.Err == nil {
+		return
+	}
+	var mberr *modbus.ModbusError
+	var cserr *modbus.ChecksumError
+	switch {
+	case errors.As(o.Err, &mberr):
+		s.exceptions[mberr.ExceptionCode]++
+	case errors.As(o.Err, &cserr):
+		s.crcErrors++
+	case d.isTimeout(o.Err):
+		s.timeouts++
+	default:
+		s.errors++
+	}
+}
+
+// isTimeout returns true if err is a response timeout. Serial ports
+// return io.EOF when their read timeout expires.
+func (d *device) isTimeout(err error) bool {
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	return d.serial && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrNoProgress))
+}
+
+// ServeHTTP writes the metrics.
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.writeMetrics(w)
+}
+
+// writeMetrics writes the metrics in the Prometheus text format.
+func (e *exporter) writeMetrics(w io.Writer) {
+	// Copy the metrics so that slow scrapes do not block the polls
+	devices := make([]deviceMetrics, len(e.devices))
+	for i, d := range e.devices {
+		devices[i] = d.metrics()
+	}
+	header(w, "modbus_value", "gauge", "Value of a polled point.")
+	for _, d := range devices {
+		unit := strconv.Itoa(int(d.unit))
+		for _, p := range d.points {
+			sample(w, "modbus_value", p.value, "device", d.name, "unit", unit, "point", p.name)
+		}
+	}
+	header(w, "modbus_up", "gauge", "Whether a point of the device was read by the last poll.")
+	for _, d := range devices {
+		up := 0.0
+		if d.up {
+			up = 1
+		}
+		sample(w, "modbus_up", up, "device", d.name)
+	}
+	header(w, "modbus_request_duration_seconds", "histogram", "Latency of modbus requests.")
+	for _, d := range devices {
+		for i, bound := range latencyBuckets {
+			sample(w, "modbus_request_duration_seconds_bucket", float64(d.stats.buckets[i]),
+				"device", d.name, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		sample(w, "modbus_request_duration_seconds_bucket", float64(d.stats.count), "device", d.name, "le", "+Inf")
+		sample(w, "modbus_request_duration_seconds_sum", d.stats.sum, "device", d.name)
+		sample(w, "modbus_request_duration_seconds_count", float64(d.stats.count), "device", d.name)
+	}
+	header(w, "modbus_timeouts_total", "counter", "Requests without response.")
+	for _, d := range devices {
+		sample(w, "modbus_timeouts_total", float64(d.stats.timeouts), "device", d.name)
+	}
+	header(w, "modbus_crc_errors_total", "counter", "Responses with an invalid CRC or LRC.")
+	for _, d := range devices {
+		sample(w, "modbus_crc_errors_total", float64(d.stats.crcErrors), "device", d.name)
+	}
+	header(w, "modbus_exceptions_total", "counter", "Exception responses by exception code.")
+	for _, d := range devices {
+		codes := make([]int, 0, len(d.stats.exceptions))
+		for code := range d.stats.exceptions {
+			codes = append(codes, int(code))
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			sample(w, "modbus_exceptions_total", float64(d.stats.exceptions[byte(code)]),
+				"device", d.name, "code", strconv.Itoa(code))
+		}
+	}
+	header(w, "modbus_errors_total", "counter", "Other failed requests.")
+	for _, d := range devices {
+		sample(w, "modbus_errors_total", float64(d.stats.errors), "device", d.name)
+	}
+}
+
+// deviceMetrics are the metrics of a device at a point in time.
+type deviceMetrics struct {
+	name   string
+	unit   byte
+	points []pointValue
+	up     bool
+	stats  requestStats
+}
+
+type pointValue struct {
+	name  string
+	value float64
+}
+
+// metrics copies the metrics of d, the points without value are omitted.
+func (d *device) metrics() deviceMetrics {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := deviceMetrics{name: d.name, unit: d.unit, up: d.up, stats: d.stats}
+	for _, p := range d.points {
+		if p.valid {
+			m.points = append(m.points, pointValue{p.name, p.value})
+		}
+	}
+	m.stats.buckets = append([]uint64(nil), d.stats.buckets...)
+	m.stats.exceptions = make(map[byte]uint64, len(d.stats.exceptions))
+	for code, n := range d.stats.exceptions {
+		m.stats.exceptions[code] = n
+	}
+	return m
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+}
+
+// sample writes a sample with labels given as name and value pairs.
+func sample(w io.Writer, name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%v=\"%v\"", labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	if len(labels) > 0 {
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(w, "%v %v\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,52 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+// Command modbus-exporter polls the registers of a register map and serves
+// them on /metrics in the Prometheus text format, with self metrics on
+// request latency, timeouts, CRC errors and exceptions.
+//
+//	modbus-exporter -config registers.json -listen :9502
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	configPath := flag.String("config", "", "JSON register map")
+	listen := flag.String("listen", ":9502", "HTTP listen address")
+	verbose := flag.Bool("verbose", false, "log failed reads")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -config FILE [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *configPath == "" || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var logger *log.Logger
+	if *verbose {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	e, err := newExporter(c, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer e.close()
+	go e.run(nil)
+
+	http.Handle("/metrics", e)
+	log.Printf("serving metrics of %v devices on %v/metrics", len(e.devices), *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mythay/modbus"
+)
+
+func startServer(t *testing.T) string {
+	model := modbus.NewDataModel(8, 0, 16, 16)
+	model.WriteMultipleRegisters(1, 0, []uint16{0xFF38, 0x3FC0, 0x0000})
+	model.WriteSingleCoil(1, 3, true)
+	server, err := modbus.NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeModbus(model)
+	t.Cleanup(func() { server.Close() })
+	return "tcp://" + server.Addr().String()
+}
+
+func TestExporter(t *testing.T) {
+	c := &config{Devices: []deviceConfig{{
+		Name: `boiler "1"`,
+		URL:  startServer(t),
+		Unit: 1,
+		Points: []pointConfig{
+			{Name: "temperature", Table: "holding_registers", Address: 0, Type: "int16", Scale: 0.1},
+			{Name: "flow", Table: "holding_registers", Address: 1, Type: "float32"},
+			{Name: "pump", Table: "coils", Address: 3},
+			{Name: "missing", Table: "input_registers", Address: 100},
+		},
+	}}}
+	e, err := newExporter(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.close()
+	e.devices[0].poll(nil)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE modbus_value gauge\n",
+		`modbus_value{device="boiler \"1\"",unit="1",point="temperature"} -20` + "\n",
+		`modbus_value{device="boiler \"1\"",unit="1",point="flow"} 1.5` + "\n",
+		`modbus_value{device="boiler \"1\"",unit="1",point="pump"} 1` + "\n",
+		`modbus_up{device="boiler \"1\""} 1` + "\n",
+		`modbus_request_duration_seconds_count{device="boiler \"1\""} 4` + "\n",
+		`modbus_request_duration_seconds_bucket{device="boiler \"1\"",le="+Inf"} 4` + "\n",
+		`modbus_exceptions_total{device="boiler \"1\"",code="2"} 1` + "\n",
+		`modbus_timeouts_total{device="boiler \"1\""} 0` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in\n%v", line, out)
+		}
+	}
+	if strings.Contains(out, `point="missing"`) {
+		t.Errorf("value of a failed read exported:\n%v", out)
+	}
+}
+
+func TestExporterErrors(t *testing.T) {
+	d := &device{name: "rtu", serial: true}
+	d.stats.buckets = make([]uint64, len(latencyBuckets))
+	d.stats.exceptions = make(map[byte]uint64)
+	for _, err := range []error{
+		&modbus.ChecksumError{Framing: modbus.FramingRTU},
+		io.EOF,
+		&modbus.ModbusError{ExceptionCode: modbus.ExceptionCodeServerDeviceBusy},
+		errors.New("modbus: response slave id '2' does not match request '1'"),
+	} {
+		d.RequestEnd(&modbus.Observation{Duration: 30 * time.Millisecond, Err: err})
+	}
+	if d.stats.crcErrors != 1 || d.stats.timeouts != 1 || d.stats.exceptions[6] != 1 || d.stats.errors != 1 {
+		t.Fatalf("unexpected stats %+v", d.stats)
+	}
+	e := &exporter{devices: []*device{d}}
+	var b bytes.Buffer
+	e.writeMetrics(&b)
+	for _, line := range []string{
+		`modbus_request_duration_seconds_bucket{device="rtu",le="0.025"} 0`,
+		`modbus_request_duration_seconds_bucket{device="rtu",le="0.05"} 4`,
+		`modbus_crc_errors_total{device="rtu"} 1`,
+		`modbus_up{device="rtu"} 0`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %q in\n%v", line, b.String())
+		}
+	}
+	// Polls are not blocked by slow scrapes
+	e.writeMetrics(writerFunc(func(p []byte) (int, error) {
+		if !d.mu.TryLock() {
+			t.Fatal("device locked while writing metrics")
+		}
+		d.mu.Unlock()
+		return len(p), nil
+	}))
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestConfig(t *testing.T) {
+	cases := []*config{
+		{Interval: "soon"},
+		{Devices: []deviceConfig{{Name: "a", URL: "tcp://localhost"}, {Name: "a", URL: "tcp://localhost"}}},
+		{Devices: []deviceConfig{{Name: "a", URL: "udp://localhost"}}},
+		{Devices: []deviceConfig{{Name: "a", URL: "tcp://localhost", Points: []pointConfig{{Name: "p", Table: "hr"}}}}},
+	}
+	for _, c := range cases {
+		if _, err := newExporter(c, nil); err == nil {
+			t.Errorf("%+v: error expected", c)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+// Command modbus-sim serves simulated devices described by a JSON
+// configuration, see modbus.SimulatorConfig.
+//
+//	modbus-sim -config plant.json -port 5020
+//	modbus-sim -config plant.json -transport rtu
+//
+// With rtu or ascii transport, requests are served on a pseudo terminal
+// whose name is printed, e.g. rtu:///dev/pts/3 for the modbus command.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mythay/modbus"
+	"github.com/mythay/modbus/internal/pty"
+)
+
+func main() {
+	config := flag.String("config", "", "JSON configuration of the simulated units")
+	transport := flag.String("transport", "tcp", "transport: tcp, rtu or ascii")
+	port := flag.Int("port", 502, "TCP port")
+	address := flag.String("address", "", "TCP listen address, e.g. 127.0.0.1:502, instead of -port")
+	verbose := flag.Bool("verbose", false, "log frames")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -config FILE [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *config == "" || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetFlags(log.LstdFlags)
+
+	c, err := modbus.LoadSimulatorConfig(*config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sim, err := modbus.NewSimulator(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var logger *log.Logger
+	if *verbose {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	sim.Start()
+	defer sim.Stop()
+
+	switch *transport {
+	case "tcp":
+		var server *modbus.TcpServer
+		if *address != "" {
+			server, err = modbus.NewTcpServerAddress(*address)
+		} else {
+			server, err = modbus.NewTcpServer(*port)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.Logger = logger
+		go closeOnInterrupt(server.Close)
+		log.Printf("serving %v units on tcp://%v", len(c.Units), server.Addr())
+		sim.ServeTCP(server)
+	case "rtu", "ascii":
+		framing := modbus.FramingRTU
+		if *transport == "ascii" {
+			framing = modbus.FramingASCII
+		}
+		master, name, err := pty.Open()
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Reads of the master fail once the last client closes the slave
+		// unless it is kept open
+		slave, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer slave.Close()
+		server := modbus.NewSerialServer(master, framing)
+		server.Logger = logger
+		go closeOnInterrupt(server.Close)
+		log.Printf("serving %v units on %v://%v", len(c.Units), framing, name)
+		if err = sim.ServeSerial(server); err != nil && !errors.Is(err, os.ErrClosed) {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown transport %q", *transport)
+	}
+}
+
+func closeOnInterrupt(close func() error) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+	close()
+}
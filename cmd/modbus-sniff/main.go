@@ -0,0 +1,93 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+// Command modbus-sniff prints the modbus transactions seen on a serial line
+// without writing to it.
+//
+//	modbus-sniff -framing rtu -baud 9600 -parity N /dev/ttyUSB0
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/mythay/modbus"
+	"github.com/tarm/serial"
+)
+
+func main() {
+	framing := flag.String("framing", "rtu", "serial framing, rtu or ascii")
+	baud := flag.Int("baud", 19200, "baud rate")
+	dataBits := flag.Int("databits", 0, "data bits, 8 for rtu and 7 for ascii if zero")
+	parity := flag.String("parity", "E", "parity, N, E or O")
+	stopBits := flag.Int("stopbits", 1, "stop bits, 1 or 2")
+	timeout := flag.Duration("timeout", time.Second, "time after which a request without response is reported")
+	hex := flag.Bool("hex", false, "print frames in hexadecimal")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] device\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetFlags(0)
+
+	p := strings.ToUpper(*parity)
+	if p != "N" && p != "E" && p != "O" {
+		log.Fatalf("unknown parity %q", *parity)
+	}
+	f, size := modbus.FramingRTU, 8
+	switch *framing {
+	case "rtu":
+	case "ascii":
+		f, size = modbus.FramingASCII, 7
+	default:
+		log.Fatalf("unknown framing %q", *framing)
+	}
+	if *dataBits != 0 {
+		if *dataBits < 5 || *dataBits > 8 {
+			log.Fatalf("invalid data bits %v", *dataBits)
+		}
+		size = *dataBits
+	}
+	config := &serial.Config{
+		Name:     flag.Arg(0),
+		Baud:     *baud,
+		Size:     byte(size),
+		Parity:   serial.Parity(p[0]),
+		StopBits: serial.StopBits(*stopBits),
+	}
+	sniffer, err := modbus.OpenSniffer(config, f, func(t *modbus.SniffedTransaction) {
+		fmt.Println(t)
+		if *hex {
+			if t.Request != nil && t.Err != modbus.ErrInvalidFrame {
+				fmt.Printf("  request  % x\n", t.Request)
+			}
+			if t.Response != nil {
+				fmt.Printf("  response % x\n", t.Response)
+			}
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	sniffer.Timeout = *timeout
+
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		<-c
+		sniffer.Close()
+	}()
+	if err = sniffer.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/mythay/modbus"
+)
+
+// Largest quantities of the requests
+const (
+	maxReadBits           = 2000
+	maxReadRegisters      = 125
+	maxWriteBits          = 1968
+	maxWriteRegisters     = 123
+	maxReadWriteRegisters = 121
+)
+
+// read reads coils, discrete inputs, registers or a FIFO queue.
+func read(client modbus.Client, args []string, opts *options, out *output) error {
+	if len(args) < 2 || len(args) > 3 {
+		return usagef("read expects a table, an address and an optional count")
+	}
+	address, err := parseUint16(args[1], "address")
+	if err != nil {
+		return err
+	}
+	count := uint16(1)
+	if len(args) == 3 {
+		if count, err = parseUint16(args[2], "count"); err != nil {
+			return err
+		}
+	}
+	slave := byte(opts.slave)
+	var results []byte
+	switch args[0] {
+	case "coil", "coils", "co":
+		if _, err = checkQuantity(address, int(count), maxReadBits, "coils"); err != nil {
+			return err
+		}
+		if results, err = client.ReadCoils(slave, address, count); err != nil {
+			return err
+		}
+		return out.bits(modbus.FuncCodeReadCoils, address, count, results)
+	case "di", "discrete":
+		if _, err = checkQuantity(address, int(count), maxReadBits, "discrete inputs"); err != nil {
+			return err
+		}
+		if results, err = client.ReadDiscreteInputs(slave, address, count); err != nil {
+			return err
+		}
+		return out.bits(modbus.FuncCodeReadDiscreteInputs, address, count, results)
+	case "hr", "holding":
+		quantity, err := checkQuantity(address, int(count)*opts.typ.Registers(), maxReadRegisters, "registers")
+		if err != nil {
+			return err
+		}
+		if results, err = client.ReadHoldingRegisters(slave, address, quantity); err != nil {
+			return err
+		}
+		return out.registers(modbus.FuncCodeReadHoldingRegisters, address, results, opts)
+	case "ir", "input":
+		quantity, err := checkQuantity(address, int(count)*opts.typ.Registers(), maxReadRegisters, "registers")
+		if err != nil {
+			return err
+		}
+		if results, err = client.ReadInputRegisters(slave, address, quantity); err != nil {
+			return err
+		}
+		return out.registers(modbus.FuncCodeReadInputRegisters, address, results, opts)
+	case "fifo":
+		if len(args) != 2 {
+			return usagef("read fifo expects an address only")
+		}
+		if results, err = client.ReadFIFOQueue(slave, address); err != nil {
+			return err
+		}
+		return out.registers(modbus.FuncCodeReadFIFOQueue, address, results, opts)
+	}
+	return usagef("unknown table %q, expected coil, di, hr, ir or fifo", args[0])
+}
+
+// write writes coils or holding registers.
+func write(client modbus.Client, args []string, opts *options, out *output) error {
+	if len(args) < 3 {
+		return usagef("write expects a table, an address and values")
+	}
+	address, err := parseUint16(args[1], "address")
+	if err != nil {
+		return err
+	}
+	slave := byte(opts.slave)
+	var results []byte
+	switch args[0] {
+	case "coil", "coils", "co":
+		states := make([]bool, len(args)-2)
+		for i, arg := range args[2:] {
+			if states[i], err = parseState(arg); err != nil {
+				return err
+			}
+		}
+		if len(states) == 1 && !opts.multiple {
+			value := uint16(0x0000)
+			if states[0] {
+				value = 0xFF00
+			}
+			if results, err = client.WriteSingleCoil(slave, address, value); err != nil {
+				return err
+			}
+			return out.written(modbus.FuncCodeWriteSingleCoil, address, 1, results)
+		}
+		quantity, err := checkQuantity(address, len(states), maxWriteBits, "coils")
+		if err != nil {
+			return err
+		}
+		if results, err = client.WriteMultipleCoils(slave, address, quantity, packStates(states)); err != nil {
+			return err
+		}
+		return out.written(modbus.FuncCodeWriteMultipleCoils, address, quantity, results)
+	case "hr", "holding":
+		data, err := encodeValues(args[2:], opts)
+		if err != nil {
+			return err
+		}
+		if len(data) == 2 && !opts.multiple {
+			if results, err = client.WriteSingleRegister(slave, address, binary.BigEndian.Uint16(data)); err != nil {
+				return err
+			}
+			return out.written(modbus.FuncCodeWriteSingleRegister, address, 1, results)
+		}
+		quantity, err := checkQuantity(address, len(data)/2, maxWriteRegisters, "registers")
+		if err != nil {
+			return err
+		}
+		if results, err = client.WriteMultipleRegisters(slave, address, quantity, data); err != nil {
+			return err
+		}
+		return out.written(modbus.FuncCodeWriteMultipleRegisters, address, quantity, results)
+	}
+	return usagef("unknown table %q, expected coil or hr", args[0])
+}
+
+// mask modifies a holding register with AND and OR masks.
+func mask(client modbus.Client, args []string, opts *options, out *output) error {
+	if len(args) != 3 {
+		return usagef("mask expects an address, an AND mask and an OR mask")
+	}
+	address, err := parseUint16(args[0], "address")
+	if err != nil {
+		return err
+	}
+	andMask, err := parseUint16(args[1], "AND mask")
+	if err != nil {
+		return err
+	}
+	orMask, err := parseUint16(args[2], "OR mask")
+	if err != nil {
+		return err
+	}
+	results, err := client.MaskWriteRegister(byte(opts.slave), address, andMask, orMask)
+	if err != nil {
+		return err
+	}
+	return out.written(modbus.FuncCodeMaskWriteRegister, address, 1, results)
+}
+
+// readWrite writes holding registers then reads holding registers.
+func readWrite(client modbus.Client, args []string, opts *options, out *output) error {
+	if len(args) < 4 {
+		return usagef("readwrite expects a read address, a count, a write address and values")
+	}
+	address, err := parseUint16(args[0], "read address")
+	if err != nil {
+		return err
+	}
+	count, err := parseUint16(args[1], "count")
+	if err != nil {
+		return err
+	}
+	writeAddress, err := parseUint16(args[2], "write address")
+	if err != nil {
+		return err
+	}
+	data, err := encodeValues(args[3:], opts)
+	if err != nil {
+		return err
+	}
+	quantity, err := checkQuantity(address, int(count)*opts.typ.Registers(), maxReadRegisters, "registers")
+	if err != nil {
+		return err
+	}
+	writeQuantity, err := checkQuantity(writeAddress, len(data)/2, maxReadWriteRegisters, "registers")
+	if err != nil {
+		return err
+	}
+	results, err := client.ReadWriteMultipleRegisters(byte(opts.slave), address, quantity, writeAddress, writeQuantity, data)
+	if err != nil {
+		return err
+	}
+	return out.registers(modbus.FuncCodeReadWriteMultipleRegisters, address, results, opts)
+}
+
+// checkQuantity returns n, the quantity of coils or registers accessed from
+// address, if it is at most max and within the address space.
+func checkQuantity(address uint16, n, max int, name string) (uint16, error) {
+	if n > max || int(address)+n > 0x10000 {
+		return 0, usagef("%v %v from address %v out of range, at most %v", n, name, address, max)
+	}
+	return uint16(n), nil
+}
+
+func parseUint16(s, name string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, usagef("invalid %v %q", name, s)
+	}
+	return uint16(v), nil
+}
+
+func parseState(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "on", "1", "true":
+		return true, nil
+	case "off", "0", "false":
+		return false, nil
+	}
+	return false, usagef("invalid coil state %q, expected on or off", s)
+}
+
+// encodeValues returns the registers of values of the type of opts.
+func encodeValues(values []string, opts *options) ([]byte, error) {
+	var data []byte
+	for _, s := range values {
+		b, err := modbus.EncodeValue(s, opts.typ, opts.order)
+		if err != nil {
+			return nil, usagef("%v", err)
+		}
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+func packStates(states []bool) []byte {
+	data := make([]byte, (len(states)+7)/8)
+	for i, v := range states {
+		if v {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
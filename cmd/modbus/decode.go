@@ -0,0 +1,98 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mythay/modbus"
+)
+
+// decode describes the frames of args, or of the log lines read from stdin.
+func decode(args []string, opts *options, stdin io.Reader, stdout io.Writer) error {
+	var framing modbus.Framing
+	switch opts.framing {
+	case "auto":
+		framing = -1
+	case "tcp":
+		framing = modbus.FramingTCP
+	case "rtu":
+		framing = modbus.FramingRTU
+	case "ascii":
+		framing = modbus.FramingASCII
+	default:
+		return usagef("unknown framing %q", opts.framing)
+	}
+	describe := func(adu []byte, kind modbus.FrameKind) {
+		f := framing
+		if f < 0 {
+			f = modbus.GuessFraming(adu)
+		}
+		fmt.Fprintln(stdout, modbus.DescribeFrame(adu, f, kind))
+	}
+	if len(args) > 0 {
+		for _, arg := range args {
+			adu, ok := parseFrame(arg)
+			if !ok {
+				return usagef("invalid frame %q", arg)
+			}
+			describe(adu, modbus.AnyFrames)
+		}
+		return nil
+	}
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		adu, kind, ok := parseLogLine(scanner.Text(), opts.server)
+		if ok {
+			describe(adu, kind)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseLogLine extracts the frame of a line such as
+// "modbus: sending 00 01 00 00 00 06 01 03 00 6b 00 03", frames sent by a
+// client are requests, those sent by a server are responses.
+func parseLogLine(line string, server bool) (adu []byte, kind modbus.FrameKind, ok bool) {
+	kind = modbus.AnyFrames
+	for _, word := range []string{"sending", "received"} {
+		if i := strings.Index(line, word); i >= 0 {
+			line = line[i+len(word):]
+			if (word == "sending") != server {
+				kind = modbus.RequestFrames
+			} else {
+				kind = modbus.ResponseFrames
+			}
+			break
+		}
+	}
+	adu, ok = parseFrame(line)
+	return
+}
+
+// parseFrame parses hex bytes, separated by spaces or not, or an ASCII
+// frame which may be quoted.
+func parseFrame(s string) ([]byte, bool) {
+	s = strings.TrimSpace(s)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	if strings.HasPrefix(s, ":") {
+		if !strings.HasSuffix(s, "\r\n") {
+			s += "\r\n"
+		}
+		return []byte(s), true
+	}
+	adu, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil || len(adu) == 0 {
+		return nil, false
+	}
+	return adu, true
+}
@@ -0,0 +1,265 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+// Command modbus reads and writes modbus devices.
+//
+//	modbus read hr 100 10 --slave 3 --type float32 --order cdab tcp://10.0.0.5:502
+//	modbus write coil 12 on rtu:///dev/ttyUSB0?baud=9600
+//
+// Run modbus without arguments for the list of commands. The exit status is
+// 0 on success, 1 on communication errors, 2 on usage errors and 10 plus
+// the exception code when the device answers with an exception, e.g. 12 for
+// illegal data address.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mythay/modbus"
+)
+
+const usage = `usage: modbus <command> [arguments] [flags] URL
+
+commands:
+  read coil|di|hr|ir ADDRESS [COUNT]  read COUNT values (default 1)
+  read fifo ADDRESS                   read a FIFO queue
+  write coil ADDRESS on|off...        write coils
+  write hr ADDRESS VALUE...           write holding registers
+  mask ADDRESS AND OR                 mask write a holding register
+  readwrite ADDRESS COUNT WADDRESS VALUE...
+                                      write then read holding registers
+  scan                                find slaves and readable ranges,
+                                      print a JSON device map
+  decode [FRAME...]                   describe hex or ASCII frames given
+                                      as arguments or log lines on stdin,
+                                      no URL is needed
+
+URL is tcp://host[:port], rtu://device or ascii://device with optional
+query parameters timeout, baud, parity, databits and stopbits, e.g.
+rtu:///dev/ttyUSB0?baud=9600&parity=N.
+
+flags:
+`
+
+// Exit status
+const (
+	exitError     = 1
+	exitUsage     = 2
+	exitException = 10
+)
+
+// usageError is reported with the usage and exit status 2.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, v ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, v...)}
+}
+
+// options are the flags common to all commands.
+type options struct {
+	slave    int
+	typ      modbus.ValueType
+	order    modbus.ValueOrder
+	format   string
+	multiple bool
+	verbose  bool
+	timeout  time.Duration
+	// scan options
+	slaves string
+	step   int
+	max    uint
+	// decode options
+	framing string
+	server  bool
+}
+
+func main() {
+	log.SetFlags(0)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command of args and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("modbus", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var opts options
+	var typ, order string
+	fs.IntVar(&opts.slave, "slave", 1, "slave id")
+	fs.StringVar(&typ, "type", "uint16", "register value type: uint16, int16, uint32, int32, float32, uint64, int64 or float64")
+	fs.StringVar(&order, "order", "abcd", "byte order of 32-bit values: abcd, cdab, badc or dcba")
+	fs.StringVar(&opts.format, "format", "table", "output format: table, hex or json")
+	fs.BoolVar(&opts.multiple, "multiple", false, "write a single value with function 15 or 16")
+	fs.BoolVar(&opts.verbose, "verbose", false, "log frames on stderr")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "response timeout, overrides the URL")
+	fs.StringVar(&opts.slaves, "slaves", "1-247", "slaves to scan, e.g. 1,3,10-20")
+	fs.IntVar(&opts.step, "step", 16, "step between addresses probed by scan")
+	fs.UintVar(&opts.max, "max", 0xFFFF, "last address scanned")
+	fs.StringVar(&opts.framing, "framing", "auto", "framing of decoded frames: auto, tcp, rtu or ascii")
+	fs.BoolVar(&opts.server, "server", false, "decoded log lines were written by a server")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseArgs(fs, args)
+	if err == nil {
+		if opts.typ, err = modbus.ParseValueType(typ); err != nil {
+			err = usagef("%v", err)
+		} else if opts.order, err = modbus.ParseValueOrder(order); err != nil {
+			err = usagef("%v", err)
+		} else if opts.slave < 0 || opts.slave > 255 {
+			err = usagef("invalid slave id %v", opts.slave)
+		} else if opts.format != "table" && opts.format != "hex" && opts.format != "json" {
+			err = usagef("unknown format %q", opts.format)
+		} else if len(positional) > 0 && positional[0] == "decode" {
+			err = decode(positional[1:], &opts, stdin, stdout)
+			return exitStatus(err, fs, stderr)
+		} else if len(positional) < 2 {
+			err = usagef("missing command or URL")
+		}
+	}
+	if err == nil {
+		err = execute(positional[0], positional[1:len(positional)-1], positional[len(positional)-1], &opts, stdout, stderr)
+	}
+	return exitStatus(err, fs, stderr)
+}
+
+// exitStatus reports err and returns the matching exit status.
+func exitStatus(err error, fs *flag.FlagSet, stderr io.Writer) int {
+	if err == nil {
+		return 0
+	}
+	if err == flag.ErrHelp {
+		return exitUsage
+	}
+	fmt.Fprintln(stderr, "modbus:", strings.TrimPrefix(err.Error(), "modbus: "))
+	var uerr *usageError
+	if errors.As(err, &uerr) {
+		fs.Usage()
+		return exitUsage
+	}
+	var mberr *modbus.ModbusError
+	if errors.As(err, &mberr) {
+		return exitException + int(mberr.ExceptionCode)
+	}
+	return exitError
+}
+
+// parseArgs parses the flags found anywhere in args and returns the other
+// arguments. Negative numbers are not flags.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var flags, positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' || isNumber(arg) {
+			positional = append(positional, arg)
+			continue
+		}
+		flags = append(flags, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		if f := fs.Lookup(name); f != nil && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+	if err := fs.Parse(flags); err != nil {
+		return nil, err
+	}
+	return positional, nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// execute connects to the device of rawurl and runs the command.
+func execute(command string, args []string, rawurl string, opts *options, stdout, stderr io.Writer) error {
+	var cmd func(client modbus.Client, args []string, opts *options, out *output) error
+	switch command {
+	case "read":
+		cmd = read
+	case "write":
+		cmd = write
+	case "mask":
+		cmd = mask
+	case "readwrite":
+		cmd = readWrite
+	case "scan":
+		cmd = scan
+		// Most probes of absent slaves time out
+		if opts.timeout == 0 && !hasTimeout(rawurl) {
+			opts.timeout = scanTimeout
+		}
+	default:
+		return usagef("unknown command %q", command)
+	}
+	handler, err := modbus.NewURLClientHandler(rawurl)
+	if err != nil {
+		return usagef("%v", err)
+	}
+	defer handler.Close()
+	if opts.verbose {
+		setLogger(handler, log.New(stderr, "", log.LstdFlags))
+	}
+	if opts.timeout > 0 {
+		setTimeout(handler, opts.timeout)
+	}
+	out := &output{w: stdout, format: opts.format, slave: byte(opts.slave), log: stderr}
+	return cmd(modbus.NewClient(handler), args, opts, out)
+}
+
+func setLogger(handler modbus.URLHandler, logger *log.Logger) {
+	switch h := handler.(type) {
+	case *modbus.TCPClientHandler:
+		h.Logger = logger
+	case *modbus.RTUClientHandler:
+		h.Logger = logger
+	case *modbus.ASCIIClientHandler:
+		h.Logger = logger
+	}
+}
+
+func setTimeout(handler modbus.URLHandler, timeout time.Duration) {
+	switch h := handler.(type) {
+	case *modbus.TCPClientHandler:
+		h.Timeout = timeout
+	case *modbus.RTUClientHandler:
+		h.ReadTimeout = timeout
+	case *modbus.ASCIIClientHandler:
+		h.ReadTimeout = timeout
+	}
+}
+
+func hasTimeout(rawurl string) bool {
+	u, err := url.Parse(rawurl)
+	return err == nil && u.Query().Get("timeout") != ""
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mythay/modbus"
+)
+
+func startServer(t *testing.T) string {
+	model := modbus.NewDataModel(16, 16, 16, 16)
+	model.WriteMultipleRegisters(1, 0, []uint16{0x0000, 0x3FC0, 0xFFFF})
+	server, err := modbus.NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeModbus(model)
+	t.Cleanup(func() { server.Close() })
+	return "tcp://" + server.Addr().String()
+}
+
+func runCommand(t *testing.T, args ...string) (int, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(args, nil, &stdout, &stderr)
+	t.Log(stderr.String())
+	return status, stdout.String()
+}
+
+func TestRead(t *testing.T) {
+	url := startServer(t)
+	status, out := runCommand(t, "read", "hr", "0", "1", "--type", "float32", "--order", "cdab", url)
+	if status != 0 || !strings.Contains(out, "1.5") {
+		t.Fatalf("unexpected status %v output %q", status, out)
+	}
+	status, out = runCommand(t, "read", "hr", "2", "--type=int16", "--format", "json", url)
+	if status != 0 || !strings.Contains(out, `"value": -1`) {
+		t.Fatalf("unexpected status %v output %q", status, out)
+	}
+	status, out = runCommand(t, "read", "hr", "0", "2", "--format", "hex", url)
+	if status != 0 || out != "00 00 3f c0\n" {
+		t.Fatalf("unexpected status %v output %q", status, out)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	url := startServer(t)
+	if status, _ := runCommand(t, "write", "coil", "12", "on", url); status != 0 {
+		t.Fatalf("unexpected status %v", status)
+	}
+	if status, _ := runCommand(t, "write", "hr", "4", "-5", "7", "--type", "int16", url); status != 0 {
+		t.Fatalf("unexpected status %v", status)
+	}
+	if status, _ := runCommand(t, "mask", "4", "0x00F2", "0x0025", url); status != 0 {
+		t.Fatalf("unexpected status %v", status)
+	}
+	status, out := runCommand(t, "read", "coil", "11", "2", url)
+	if status != 0 || !strings.Contains(out, "12       on") {
+		t.Fatalf("unexpected status %v output %q", status, out)
+	}
+	status, out = runCommand(t, "readwrite", "5", "1", "6", "9", url)
+	if status != 0 || !strings.Contains(out, "5        7") {
+		t.Fatalf("unexpected status %v output %q", status, out)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	url := startServer(t)
+	status, _ := runCommand(t, "read", "hr", "100", url)
+	if status != exitException+modbus.ExceptionCodeIllegalDataAddress {
+		t.Fatalf("status expected %v, actual %v", exitException+modbus.ExceptionCodeIllegalDataAddress, status)
+	}
+	if status, _ = runCommand(t, "read", "xx", "1", url); status != exitUsage {
+		t.Fatalf("status expected %v, actual %v", exitUsage, status)
+	}
+	// 16385 float64 values are 4 registers in uint16
+	for _, args := range [][]string{
+		{"read", "hr", "0", "16385", "--type", "float64", url},
+		{"read", "ir", "65535", "2", url},
+		{"readwrite", "0", "16385", "0", "1", "--type", "float64", url},
+	} {
+		if status, _ = runCommand(t, args...); status != exitUsage {
+			t.Fatalf("%v: status expected %v, actual %v", args, exitUsage, status)
+		}
+	}
+	if status, _ = runCommand(t, "read", "hr", "1", "tcp://127.0.0.1:1?timeout=1s"); status != exitError {
+		t.Fatalf("status expected %v, actual %v", exitError, status)
+	}
+}
+
+func TestScan(t *testing.T) {
+	url := startServer(t)
+	status, out := runCommand(t, "scan", "--slaves", "1", "--max", "31", url)
+	expected := `"holding_registers": [
+        {
+          "start": 0,
+          "end": 15
+        }
+      ]`
+	if status != 0 || !strings.Contains(out, expected) {
+		t.Fatalf("unexpected status %v output %q", status, out)
+	}
+	if status, _ = runCommand(t, "scan", "--slaves", "9-3", url); status != exitUsage {
+		t.Fatalf("status expected %v, actual %v", exitUsage, status)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader(`tcp: 2026/01/02 15:04:05 modbus: sending 00 01 00 00 00 06 01 02 00 0f 00 02
+tcp: 2026/01/02 15:04:05 modbus: received 00 01 00 00 00 04 01 02 01 01
+ascii: 2026/01/02 15:04:05 modbus: sending ":0102000F0002EC\r\n"
+unrelated line
+`)
+	if status := run([]string{"decode"}, stdin, &stdout, &stderr); status != 0 {
+		t.Fatalf("unexpected status %v: %v", status, stderr.String())
+	}
+	out := stdout.String()
+	for _, expected := range []string{"tcp request\n", "tcp response\n", "values:         [1 0 0 0 0 0 0 0]", "ascii request\n", "lrc:      0xEC (valid)"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("%q expected in output:\n%v", expected, out)
+		}
+	}
+	stdout.Reset()
+	if status := run([]string{"decode", "01830 2C0F1"}, nil, &stdout, &stderr); status != 0 {
+		t.Fatalf("unexpected status %v: %v", status, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "rtu response\n") {
+		t.Fatalf("rtu response expected:\n%v", stdout.String())
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+
+	"github.com/mythay/modbus"
+)
+
+// output prints results as a table, hex bytes or JSON.
+type output struct {
+	w      io.Writer
+	format string
+	slave  byte
+	// log receives progress messages
+	log io.Writer
+}
+
+// item is one value read.
+type item struct {
+	Address uint16      `json:"address"`
+	Value   interface{} `json:"value"`
+	raw     []byte
+}
+
+// result is the JSON output of a command.
+type result struct {
+	Slave    byte   `json:"slave"`
+	Function string `json:"function"`
+	Address  uint16 `json:"address"`
+	Quantity uint16 `json:"quantity,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Values   []item `json:"values,omitempty"`
+}
+
+// bits prints quantity coils or discrete inputs.
+func (o *output) bits(functionCode byte, address, quantity uint16, data []byte) error {
+	items := make([]item, 0, quantity)
+	for i := 0; i < int(quantity) && i/8 < len(data); i++ {
+		items = append(items, item{
+			Address: address + uint16(i),
+			Value:   data[i/8]&(1<<uint(i%8)) != 0,
+		})
+	}
+	return o.values(functionCode, address, "", items, data)
+}
+
+// registers prints register values of the type of opts. Values of a FIFO
+// queue are numbered from zero.
+func (o *output) registers(functionCode byte, address uint16, data []byte, opts *options) error {
+	values, err := modbus.DecodeValues(data, opts.typ, opts.order)
+	if err != nil {
+		return err
+	}
+	size := 2 * opts.typ.Registers()
+	items := make([]item, len(values))
+	for i, v := range values {
+		items[i] = item{
+			Address: address + uint16(i*opts.typ.Registers()),
+			Value:   v,
+			raw:     data[i*size : (i+1)*size],
+		}
+		if functionCode == modbus.FuncCodeReadFIFOQueue {
+			items[i].Address = uint16(i)
+		}
+	}
+	return o.values(functionCode, address, opts.typ.String(), items, data)
+}
+
+func (o *output) values(functionCode byte, address uint16, typ string, items []item, data []byte) error {
+	switch o.format {
+	case "hex":
+		_, err := fmt.Fprintf(o.w, "% x\n", data)
+		return err
+	case "json":
+		for i := range items {
+			items[i].Value = jsonValue(items[i].Value)
+		}
+		return o.json(&result{
+			Slave:    o.slave,
+			Function: modbus.FunctionName(functionCode),
+			Address:  address,
+			Quantity: uint16(len(items)),
+			Type:     typ,
+			Values:   items,
+		})
+	}
+	w := tabwriter.NewWriter(o.w, 0, 8, 2, ' ', 0)
+	if typ == "" {
+		fmt.Fprintln(w, "ADDRESS\tVALUE")
+		for _, it := range items {
+			state := "off"
+			if it.Value == true {
+				state = "on"
+			}
+			fmt.Fprintf(w, "%v\t%v\n", it.Address, state)
+		}
+	} else {
+		fmt.Fprintln(w, "ADDRESS\tVALUE\tHEX")
+		for _, it := range items {
+			fmt.Fprintf(w, "%v\t%v\t% x\n", it.Address, it.Value, it.raw)
+		}
+	}
+	return w.Flush()
+}
+
+// written prints the result of a write.
+func (o *output) written(functionCode byte, address, quantity uint16, data []byte) error {
+	switch o.format {
+	case "hex":
+		_, err := fmt.Fprintf(o.w, "% x\n", data)
+		return err
+	case "json":
+		return o.json(&result{
+			Slave:    o.slave,
+			Function: modbus.FunctionName(functionCode),
+			Address:  address,
+			Quantity: quantity,
+		})
+	}
+	_, err := fmt.Fprintf(o.w, "%v: address %v quantity %v\n", modbus.FunctionName(functionCode), address, quantity)
+	return err
+}
+
+func (o *output) json(r *result) error {
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// jsonValue returns v or its string form if JSON cannot represent it.
+func jsonValue(v interface{}) interface{} {
+	var f float64
+	switch n := v.(type) {
+	case float32:
+		f = float64(n)
+	case float64:
+		f = n
+	default:
+		return v
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprint(v)
+	}
+	return v
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mythay/modbus"
+)
+
+const scanTimeout = 200 * time.Millisecond
+
+// scan prints the device map of the slaves answering.
+func scan(client modbus.Client, args []string, opts *options, out *output) error {
+	if len(args) != 0 {
+		return usagef("scan expects no arguments")
+	}
+	slaves, err := parseSlaves(opts.slaves)
+	if err != nil {
+		return err
+	}
+	if opts.max > 0xFFFF {
+		return usagef("invalid max address %v", opts.max)
+	}
+	scanner := modbus.NewScanner(client)
+	scanner.Slaves = slaves
+	scanner.Step = opts.step
+	scanner.MaxAddress = uint16(opts.max)
+	if opts.verbose {
+		scanner.Logger = log.New(out.log, "", log.LstdFlags)
+	}
+	enc := json.NewEncoder(out.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(scanner.Scan())
+}
+
+// parseSlaves parses a list of slave ids and ranges, e.g. 1,3,10-20.
+func parseSlaves(s string) ([]byte, error) {
+	var slaves []byte
+	for _, part := range strings.Split(s, ",") {
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		from, err1 := strconv.ParseUint(strings.TrimSpace(first), 0, 8)
+		to, err2 := strconv.ParseUint(strings.TrimSpace(last), 0, 8)
+		if err1 != nil || err2 != nil || from > to {
+			return nil, usagef("invalid slaves %q", s)
+		}
+		for id := from; id <= to; id++ {
+			slaves = append(slaves, byte(id))
+		}
+	}
+	return slaves, nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"sync"
+)
+
+// DataModel is an in-memory modbus data model with the four primary tables
+// and FIFO queues. It serves requests of every slave id and can be passed
+// to TcpServer.ServeModbus or SerialServer.ServeModbus.
+// It is safe for concurrent use.
+type DataModel struct {
+	mu               sync.RWMutex
+	coils            []bool
+	discreteInputs   []bool
+	holdingRegisters []uint16
+	inputRegisters   []uint16
+	fifoQueues       map[uint16][]uint16
+	// Hooks of the writes of clients
+	beforeWrite []func(e *WriteEvent) error
+	onWrite     []func(e *WriteEvent)
+}
+
+// NewDataModel allocates a data model with the given table sizes.
+func NewDataModel(coils, discreteInputs, holdingRegisters, inputRegisters int) *DataModel {
+	return &DataModel{
+		coils:            make([]bool, coils),
+		discreteInputs:   make([]bool, discreteInputs),
+		holdingRegisters: make([]uint16, holdingRegisters),
+		inputRegisters:   make([]uint16, inputRegisters),
+		fifoQueues:       make(map[uint16][]uint16),
+	}
+}
+
+// errIllegalDataAddress is returned when a range is out of a table.
+var errIllegalDataAddress = &ModbusError{ExceptionCode: ExceptionCodeIllegalDataAddress}
+
+// inRange checks that address and quantity are within a table of size length.
+func inRange(length int, address, quantity uint16) bool {
+	return int(address)+int(quantity) <= length
+}
+
+// ReadCoils returns quantity coils from address.
+func (m *DataModel) ReadCoils(slaveid byte, address, quantity uint16) ([]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return readBits(m.coils, address, quantity)
+}
+
+// ReadDiscreteInputs returns quantity discrete inputs from address.
+func (m *DataModel) ReadDiscreteInputs(slaveid byte, address, quantity uint16) ([]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return readBits(m.discreteInputs, address, quantity)
+}
+
+// ReadHoldingRegisters returns quantity holding registers from address.
+func (m *DataModel) ReadHoldingRegisters(slaveid byte, address, quantity uint16) ([]uint16, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return readRegisters(m.holdingRegisters, address, quantity)
+}
+
+// ReadInputRegisters returns quantity input registers from address.
+func (m *DataModel) ReadInputRegisters(slaveid byte, address, quantity uint16) ([]uint16, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return readRegisters(m.inputRegisters, address, quantity)
+}
+
+// WriteSingleCoil sets the coil at address.
+func (m *DataModel) WriteSingleCoil(slaveid byte, address uint16, value bool) error {
+	return m.WriteMultipleCoils(slaveid, address, []bool{value})
+}
+
+// WriteMultipleCoils sets the coils from address.
+func (m *DataModel) WriteMultipleCoils(slaveid byte, address uint16, values []bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return writeBits(m.coils, address, values)
+}
+
+// WriteSingleRegister sets the holding register at address.
+func (m *DataModel) WriteSingleRegister(slaveid byte, address, value uint16) error {
+	return m.WriteMultipleRegisters(slaveid, address, []uint16{value})
+}
+
+// WriteMultipleRegisters sets the holding registers from address.
+func (m *DataModel) WriteMultipleRegisters(slaveid byte, address uint16, values []uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return writeRegisters(m.holdingRegisters, address, values)
+}
+
+// MaskWriteRegister modifies the holding register at address with
+// (current AND andMask) OR (orMask AND (NOT andMask)).
+func (m *DataModel) MaskWriteRegister(slaveid byte, address, andMask, orMask uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !inRange(len(m.holdingRegisters), address, 1) {
+		return errIllegalDataAddress
+	}
+	current := m.holdingRegisters[address]
+	m.holdingRegisters[address] = (current & andMask) | (orMask &^ andMask)
+	return nil
+}
+
+// ReadWriteMultipleRegisters writes the holding registers from writeAddress
+// then returns readQuantity holding registers from readAddress.
+func (m *DataModel) ReadWriteMultipleRegisters(slaveid byte, readAddress, readQuantity, writeAddress uint16, values []uint16) ([]uint16, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !inRange(len(m.holdingRegisters), readAddress, readQuantity) {
+		return nil, errIllegalDataAddress
+	}
+	if err := writeRegisters(m.holdingRegisters, writeAddress, values); err != nil {
+		return nil, err
+	}
+	return readRegisters(m.holdingRegisters, readAddress, readQuantity)
+}
+
+// ReadFIFOQueue returns the content of the FIFO queue at address.
+func (m *DataModel) ReadFIFOQueue(slaveid byte, address uint16) ([]uint16, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	queue, ok := m.fifoQueues[address]
+	if !ok {
+		return nil, errIllegalDataAddress
+	}
+	return append([]uint16(nil), queue...), nil
+}
+
+// WriteDiscreteInputs sets the discrete inputs from address.
+// Discrete inputs are read-only for modbus clients.
+func (m *DataModel) WriteDiscreteInputs(address uint16, values []bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return writeBits(m.discreteInputs, address, values)
+}
+
+// WriteInputRegisters sets the input registers from address.
+// Input registers are read-only for modbus clients.
+func (m *DataModel) WriteInputRegisters(address uint16, values []uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return writeRegisters(m.inputRegisters, address, values)
+}
+
+// SetFIFOQueue sets the content of the FIFO queue at address.
+func (m *DataModel) SetFIFOQueue(address uint16, values []uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fifoQueues[address] = append([]uint16(nil), values...)
+}
+
+// size returns the number of items of a table.
+func (m *DataModel) size(table Table) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	switch table {
+	case TableCoils:
+		return len(m.coils)
+	case TableDiscreteInputs:
+		return len(m.discreteInputs)
+	case TableHoldingRegisters:
+		return len(m.holdingRegisters)
+	case TableInputRegisters:
+		return len(m.inputRegisters)
+	}
+	return 0
+}
+
+func readBits(table []bool, address, quantity uint16) ([]bool, error) {
+	if !inRange(len(table), address, quantity) {
+		return nil, errIllegalDataAddress
+	}
+	return append([]bool(nil), table[address:address+quantity]...), nil
+}
+
+func writeBits(table []bool, address uint16, values []bool) error {
+	if !inRange(len(table), address, uint16(len(values))) {
+		return errIllegalDataAddress
+	}
+	copy(table[address:], values)
+	return nil
+}
+
+func readRegisters(table []uint16, address, quantity uint16) ([]uint16, error) {
+	if !inRange(len(table), address, quantity) {
+		return nil, errIllegalDataAddress
+	}
+	return append([]uint16(nil), table[address:address+quantity]...), nil
+}
+
+func writeRegisters(table []uint16, address uint16, values []uint16) error {
+	if !inRange(len(table), address, uint16(len(values))) {
+		return errIllegalDataAddress
+	}
+	copy(table[address:], values)
+	return nil
+}
+
+// packBits packs bit states in bytes, the first state is the least
+// significant bit of the first byte.
+func packBits(values []bool) []byte {
+	data := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
+
+// unpackBits returns quantity bit states from packed bytes.
+func unpackBits(data []byte, quantity int) []bool {
+	values := make([]bool, quantity)
+	for i := range values {
+		values[i] = data[i/8]&(1<<uint(i%8)) != 0
+	}
+	return values
+}
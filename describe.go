@@ -0,0 +1,286 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Describe returns a human readable decode of a frame: MBAP header or
+// slave id, function, address, quantity, values, exception and the
+// validity of the CRC or LRC. Whether adu is a request or a response is
+// guessed from its length.
+func Describe(adu []byte, framing Framing) string {
+	return DescribeFrame(adu, framing, AnyFrames)
+}
+
+// DescribeFrame is the same as Describe for a frame known to be a request
+// or a response.
+func DescribeFrame(adu []byte, framing Framing, kind FrameKind) string {
+	d := &description{}
+	var pdu []byte
+	switch framing {
+	case FramingTCP:
+		pdu = d.tcp(adu)
+	case FramingRTU:
+		pdu = d.rtu(adu)
+	case FramingASCII:
+		pdu = d.ascii(adu)
+	default:
+		d.add("error", "unknown framing %v", framing)
+	}
+	title := framing.String()
+	if pdu != nil {
+		title += " " + d.pdu(pdu, kind)
+	}
+	return d.format(title)
+}
+
+// description is a list of named fields.
+type description struct {
+	names  []string
+	values []string
+}
+
+func (d *description) add(name, format string, v ...interface{}) {
+	d.names = append(d.names, name)
+	d.values = append(d.values, fmt.Sprintf(format, v...))
+}
+
+// format returns the title followed by one aligned field per line.
+func (d *description) format(title string) string {
+	width := 0
+	for _, name := range d.names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	var b strings.Builder
+	b.WriteString(title)
+	for i, name := range d.names {
+		fmt.Fprintf(&b, "\n  %-*s %s", width+1, name+":", d.values[i])
+	}
+	return b.String()
+}
+
+// tcp describes the MBAP header and returns the PDU.
+func (d *description) tcp(adu []byte) []byte {
+	if len(adu) < tcpHeaderSize+1 {
+		d.add("error", "frame too short (%v bytes)", len(adu))
+		return nil
+	}
+	d.add("transaction id", "%v", binary.BigEndian.Uint16(adu))
+	d.add("protocol id", "%v", binary.BigEndian.Uint16(adu[2:]))
+	length := int(binary.BigEndian.Uint16(adu[4:]))
+	if length == len(adu)-6 {
+		d.add("length", "%v", length)
+	} else {
+		d.add("length", "%v (invalid, %v bytes follow)", length, len(adu)-6)
+	}
+	d.add("unit id", "%v", adu[6])
+	return adu[tcpHeaderSize:]
+}
+
+// rtu describes the slave id and CRC and returns the PDU.
+func (d *description) rtu(adu []byte) []byte {
+	length := len(adu)
+	if length < rtuMinSize {
+		d.add("error", "frame too short (%v bytes)", length)
+		return nil
+	}
+	d.add("slave id", "%v", adu[0])
+	var crc crc
+	crc.reset().pushBytes(adu[:length-2])
+	checksum := uint16(adu[length-1])<<8 | uint16(adu[length-2])
+	if checksum == crc.value() {
+		d.add("crc", "0x%04X (valid)", checksum)
+	} else {
+		d.add("crc", "0x%04X (invalid, expected 0x%04X)", checksum, crc.value())
+	}
+	return adu[1 : length-2]
+}
+
+// ascii describes the slave id and LRC and returns the PDU.
+func (d *description) ascii(adu []byte) []byte {
+	frame := bytes.TrimSuffix(adu, []byte(asciiEnd))
+	if !bytes.HasPrefix(frame, []byte(asciiStart)) {
+		d.add("error", "frame does not start with '%v'", asciiStart)
+		return nil
+	}
+	data := make([]byte, hex.DecodedLen(len(frame)-1))
+	if _, err := hex.Decode(data, frame[1:]); err != nil {
+		d.add("error", "invalid hexadecimal characters: %v", err)
+		return nil
+	}
+	if len(data) < 3 {
+		d.add("error", "frame too short (%v bytes)", len(data))
+		return nil
+	}
+	d.add("slave id", "%v", data[0])
+	var lrc lrc
+	lrc.reset().pushBytes(data[:len(data)-1])
+	checksum := data[len(data)-1]
+	if checksum == lrc.value() {
+		d.add("lrc", "0x%02X (valid)", checksum)
+	} else {
+		d.add("lrc", "0x%02X (invalid, expected 0x%02X)", checksum, lrc.value())
+	}
+	return data[1 : len(data)-1]
+}
+
+// pdu describes the function and its data, it returns whether the pdu is a
+// request or a response.
+func (d *description) pdu(pdu []byte, kind FrameKind) string {
+	functionCode := pdu[0]
+	data := pdu[1:]
+	d.add("function", "%v (%v)", functionCode&^0x80, FunctionName(functionCode))
+	if functionCode&0x80 != 0 {
+		if len(data) != 1 {
+			d.add("error", "invalid exception length %v", len(data))
+			return "response"
+		}
+		d.add("exception", "%v (%v)", data[0], ExceptionName(data[0]))
+		return "response"
+	}
+	request, response := pduForms(functionCode, data)
+	switch kind {
+	case RequestFrames:
+		response = false
+	case ResponseFrames:
+		request = false
+	}
+	switch {
+	case request && response && isEcho(functionCode):
+		d.request(functionCode, data)
+		return "request or response"
+	case request:
+		d.request(functionCode, data)
+		return "request"
+	case response:
+		d.response(functionCode, data)
+		return "response"
+	}
+	if !knownFunction(functionCode) {
+		d.add("data", "% x", data)
+		return "frame"
+	}
+	d.add("error", "invalid data length %v", len(data))
+	d.add("data", "% x", data)
+	return "frame"
+}
+
+// isEcho returns true if responses of the function repeat the request.
+func isEcho(functionCode byte) bool {
+	return functionCode == FuncCodeWriteSingleCoil ||
+		functionCode == FuncCodeWriteSingleRegister ||
+		functionCode == FuncCodeMaskWriteRegister
+}
+
+// pduForms tells whether data has the length of a request and of a
+// response of the function.
+func pduForms(functionCode byte, data []byte) (request, response bool) {
+	length := len(data)
+	byteCount := length > 0 && int(data[0]) == length-1
+	switch functionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		return length == 4, byteCount
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		return length == 4, byteCount && length%2 == 1
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister:
+		return length == 4, length == 4
+	case FuncCodeMaskWriteRegister:
+		return length == 6, length == 6
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		return length > 5 && int(data[4]) == length-5, length == 4
+	case FuncCodeReadWriteMultipleRegisters:
+		return length > 9 && int(data[8]) == length-9, byteCount && length%2 == 1
+	case FuncCodeReadFIFOQueue:
+		return length == 2, length >= 4 && int(binary.BigEndian.Uint16(data)) == length-2
+	}
+	return false, false
+}
+
+func (d *description) request(functionCode byte, data []byte) {
+	switch functionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		d.add("address", "%v", binary.BigEndian.Uint16(data))
+		d.add("quantity", "%v", binary.BigEndian.Uint16(data[2:]))
+	case FuncCodeWriteSingleCoil:
+		d.add("address", "%v", binary.BigEndian.Uint16(data))
+		switch value := binary.BigEndian.Uint16(data[2:]); value {
+		case 0xFF00:
+			d.add("value", "on")
+		case 0x0000:
+			d.add("value", "off")
+		default:
+			d.add("value", "0x%04X (invalid)", value)
+		}
+	case FuncCodeWriteSingleRegister:
+		d.add("address", "%v", binary.BigEndian.Uint16(data))
+		d.add("value", "%v", binary.BigEndian.Uint16(data[2:]))
+	case FuncCodeMaskWriteRegister:
+		d.add("address", "%v", binary.BigEndian.Uint16(data))
+		d.add("and mask", "0x%04X", binary.BigEndian.Uint16(data[2:]))
+		d.add("or mask", "0x%04X", binary.BigEndian.Uint16(data[4:]))
+	case FuncCodeWriteMultipleCoils:
+		quantity := binary.BigEndian.Uint16(data[2:])
+		d.add("address", "%v", binary.BigEndian.Uint16(data))
+		d.add("quantity", "%v", quantity)
+		d.add("byte count", "%v", data[4])
+		d.add("values", "%v", bitValues(data[5:], int(quantity)))
+	case FuncCodeWriteMultipleRegisters:
+		d.add("address", "%v", binary.BigEndian.Uint16(data))
+		d.add("quantity", "%v", binary.BigEndian.Uint16(data[2:]))
+		d.add("byte count", "%v", data[4])
+		d.add("values", "%v", registerValues(data[5:], len(data[5:])/2))
+	case FuncCodeReadWriteMultipleRegisters:
+		d.add("read address", "%v", binary.BigEndian.Uint16(data))
+		d.add("read quantity", "%v", binary.BigEndian.Uint16(data[2:]))
+		d.add("write address", "%v", binary.BigEndian.Uint16(data[4:]))
+		d.add("write quantity", "%v", binary.BigEndian.Uint16(data[6:]))
+		d.add("byte count", "%v", data[8])
+		d.add("values", "%v", registerValues(data[9:], len(data[9:])/2))
+	case FuncCodeReadFIFOQueue:
+		d.add("address", "%v", binary.BigEndian.Uint16(data))
+	}
+}
+
+func (d *description) response(functionCode byte, data []byte) {
+	switch functionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		d.add("byte count", "%v", data[0])
+		d.add("values", "%v", bitValues(data[1:], 8*len(data[1:])))
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeReadWriteMultipleRegisters:
+		d.add("byte count", "%v", data[0])
+		d.add("values", "%v", registerValues(data[1:], len(data[1:])/2))
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		d.add("address", "%v", binary.BigEndian.Uint16(data))
+		d.add("quantity", "%v", binary.BigEndian.Uint16(data[2:]))
+	case FuncCodeReadFIFOQueue:
+		d.add("byte count", "%v", binary.BigEndian.Uint16(data))
+		d.add("fifo count", "%v", binary.BigEndian.Uint16(data[2:]))
+		d.add("values", "%v", registerValues(data[4:], len(data[4:])/2))
+	default:
+		d.request(functionCode, data)
+	}
+}
+
+// GuessFraming returns the framing of a frame: ASCII if it starts with a
+// colon, RTU if it ends with a valid CRC and TCP otherwise.
+func GuessFraming(adu []byte) Framing {
+	if bytes.HasPrefix(adu, []byte(asciiStart)) {
+		return FramingASCII
+	}
+	if rtuValid(adu) {
+		return FramingRTU
+	}
+	return FramingTCP
+}
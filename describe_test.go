@@ -0,0 +1,68 @@
+package modbus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		adu      string
+		framing  Framing
+		expected string
+	}{
+		{"\x00\x01\x00\x00\x00\x06\x01\x03\x00\x6B\x00\x03", FramingTCP, `tcp request
+  transaction id: 1
+  protocol id:    0
+  length:         6
+  unit id:        1
+  function:       3 (read holding registers)
+  address:        107
+  quantity:       3`},
+		{"\x01\x03\x06\x02\x2B\x00\x00\x00\x64\x05\x7A", FramingRTU, `rtu response
+  slave id:   1
+  crc:        0x7A05 (valid)
+  function:   3 (read holding registers)
+  byte count: 6
+  values:     [555 0 100]`},
+		{"\x01\x83\x02\xC0\xF0", FramingRTU, `rtu response
+  slave id:  1
+  crc:       0xF0C0 (invalid, expected 0xF1C0)
+  function:  3 (read holding registers)
+  exception: 2 (illegal data address)`},
+		{":0105000AFF00F1\r\n", FramingASCII, `ascii request or response
+  slave id: 1
+  lrc:      0xF1 (valid)
+  function: 5 (write single coil)
+  address:  10
+  value:    on`},
+		{"\x00\x02\x00\x00\x00\x06\x01\x0F\x00\x13\x00\x0A\x02\xCD\x01", FramingTCP, `tcp request
+  transaction id: 2
+  protocol id:    0
+  length:         6 (invalid, 9 bytes follow)
+  unit id:        1
+  function:       15 (write multiple coils)
+  address:        19
+  quantity:       10
+  byte count:     2
+  values:         [1 0 1 1 0 0 1 1 1 0]`},
+	}
+	for _, test := range tests {
+		actual := Describe([]byte(test.adu), test.framing)
+		if test.expected != actual {
+			t.Fatalf("description expected:\n%v\nactual:\n%v", test.expected, actual)
+		}
+	}
+}
+
+func TestDescribeFrameKind(t *testing.T) {
+	// Byte count 3 followed by 3 bytes of coils, or a request
+	adu := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x01, 0x03, 0xCD, 0x6B, 0x05}
+	if !strings.HasPrefix(Describe(adu, FramingTCP), "tcp request\n") {
+		t.Fatalf("request expected: %v", Describe(adu, FramingTCP))
+	}
+	actual := DescribeFrame(adu, FramingTCP, ResponseFrames)
+	if !strings.HasPrefix(actual, "tcp response\n") || !strings.Contains(actual, "byte count:     3") {
+		t.Fatalf("response expected: %v", actual)
+	}
+}
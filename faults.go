@@ -0,0 +1,200 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const faultSplitDelay = 20 * time.Millisecond
+
+// Fault is a misbehaviour of a server answering a request, used to test
+// the resilience of clients. Faults are applied in the order of the fields.
+type Fault struct {
+	// Delay before the response is sent, e.g. beyond the client timeout
+	Delay time.Duration
+	// Drop sends no response
+	Drop bool
+	// Exception answers with this exception code instead of the response
+	Exception byte
+	// WrongUnitID answers with another unit (slave) id
+	WrongUnitID bool
+	// WrongTransactionID answers TCP requests with another transaction id
+	WrongTransactionID bool
+	// CorruptChecksum inverts the CRC of RTU or the LRC of ASCII responses
+	CorruptChecksum bool
+	// TrailingBytes appends bytes after the response
+	TrailingBytes []byte
+	// Split sends the response in Split parts separated by SplitDelay,
+	// i.e. in separate TCP segments
+	Split      int
+	SplitDelay time.Duration
+	// CloseMidFrame sends half of the response then closes the TCP
+	// connection, serial servers only send half of the response
+	CloseMidFrame bool
+}
+
+// FaultRule applies a fault to matching requests.
+type FaultRule struct {
+	// Slave ids and function codes of matching requests, any if empty
+	SlaveIDs      []byte
+	FunctionCodes []byte
+	// Match further selects requests if not nil
+	Match func(request *PDUwithSlaveid) bool
+	// Probability of applying the fault to a matching request, always
+	// if zero
+	Probability float64
+	Fault       Fault
+}
+
+// FaultInjector selects the fault of a request from a list of rules, the
+// first matching rule applies. It is set as the Faults of a TcpServer or
+// a SerialServer and is safe for concurrent use.
+type FaultInjector struct {
+	mu    sync.Mutex
+	rules []FaultRule
+	rand  *rand.Rand
+}
+
+// NewFaultInjector allocates a fault injector whose probabilities are drawn
+// from seed, so that runs can be repeated.
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// AddRule appends a rule.
+func (f *FaultInjector) AddRule(rule FaultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule)
+}
+
+// fault returns the fault of a request, nil if none applies.
+func (f *FaultInjector) fault(request *PDUwithSlaveid) *Fault {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if !containsByte(rule.SlaveIDs, request.SlaveID) ||
+			!containsByte(rule.FunctionCodes, request.FunctionCode) ||
+			(rule.Match != nil && !rule.Match(request)) {
+			continue
+		}
+		if rule.Probability > 0 && f.rand.Float64() >= rule.Probability {
+			continue
+		}
+		fault := rule.Fault
+		return &fault
+	}
+	return nil
+}
+
+// containsByte returns true if values is empty or contains v.
+func containsByte(values []byte, v byte) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, b := range values {
+		if b == v {
+			return true
+		}
+	}
+	return false
+}
+
+// response applies the faults changing the response PDU, it returns nil if
+// the response is dropped.
+func (fault *Fault) response(request, response *PDUwithSlaveid) *PDUwithSlaveid {
+	if fault.Delay > 0 {
+		time.Sleep(fault.Delay)
+	}
+	if fault.Drop || response == nil {
+		return nil
+	}
+	if fault.Exception != 0 {
+		response = encodeMbError(request.SlaveID, request.FunctionCode, fault.Exception)
+	}
+	if fault.WrongUnitID {
+		r := *response
+		r.SlaveID++
+		response = &r
+	}
+	return response
+}
+
+// frame applies the faults changing the encoded response.
+func (fault *Fault) frame(adu []byte, framing Framing) []byte {
+	adu = append([]byte(nil), adu...)
+	switch {
+	case framing == FramingTCP && fault.WrongTransactionID && len(adu) > 1:
+		adu[1]++
+		if adu[1] == 0 {
+			adu[0]++
+		}
+	case framing == FramingRTU && fault.CorruptChecksum && len(adu) > 2:
+		adu[len(adu)-2] ^= 0xFF
+		adu[len(adu)-1] ^= 0xFF
+	case framing == FramingASCII && fault.CorruptChecksum && len(adu) > 5:
+		// The LRC precedes CR LF, invert its last hexadecimal digit
+		i := len(adu) - len(asciiEnd) - 1
+		adu[i] = "FEDCBA9876543210"[hexDigit(adu[i])]
+	}
+	return append(adu, fault.TrailingBytes...)
+}
+
+func hexDigit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	}
+	return 0
+}
+
+// write sends the frame in parts or truncated, closed is true if the
+// connection must be closed.
+func (fault *Fault) write(w io.Writer, adu []byte) (closed bool, err error) {
+	if fault.CloseMidFrame {
+		_, err = w.Write(adu[:len(adu)/2])
+		return true, err
+	}
+	parts := fault.Split
+	if parts < 2 {
+		_, err = w.Write(adu)
+		return
+	}
+	if parts > len(adu) {
+		parts = len(adu)
+	}
+	delay := fault.SplitDelay
+	if delay <= 0 {
+		delay = faultSplitDelay
+	}
+	size := (len(adu) + parts - 1) / parts
+	for i := 0; i < len(adu); i += size {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		end := i + size
+		if end > len(adu) {
+			end = len(adu)
+		}
+		if _, err = w.Write(adu[i:end]); err != nil {
+			return
+		}
+	}
+	return
+}
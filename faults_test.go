@@ -0,0 +1,137 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startFaultyTCPServer(t *testing.T, faults *FaultInjector) string {
+	server, err := NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := NewDataModel(0, 0, 10, 0)
+	model.WriteSingleRegister(1, 0, 0x1234)
+	server.Faults = faults
+	go server.ServeModbus(model)
+	t.Cleanup(func() { server.Close() })
+	return server.Addr().String()
+}
+
+func TestTCPServerFaults(t *testing.T) {
+	faults := NewFaultInjector(1)
+	faults.AddRule(FaultRule{SlaveIDs: []byte{2}, Fault: Fault{Exception: ExceptionCodeServerDeviceBusy}})
+	faults.AddRule(FaultRule{SlaveIDs: []byte{3}, Fault: Fault{WrongTransactionID: true}})
+	faults.AddRule(FaultRule{SlaveIDs: []byte{4}, Fault: Fault{Split: 3, SplitDelay: 5 * time.Millisecond}})
+	faults.AddRule(FaultRule{SlaveIDs: []byte{5}, Fault: Fault{CloseMidFrame: true}})
+	faults.AddRule(FaultRule{SlaveIDs: []byte{6}, Fault: Fault{Drop: true}})
+	faults.AddRule(FaultRule{SlaveIDs: []byte{7}, Fault: Fault{WrongUnitID: true}})
+	address := startFaultyTCPServer(t, faults)
+
+	read := func(slave byte) ([]byte, error) {
+		handler := NewTCPClientHandler(address)
+		handler.Timeout = 200 * time.Millisecond
+		defer handler.Close()
+		return NewClient(handler).ReadHoldingRegisters(slave, 0, 1)
+	}
+	if results, err := read(1); err != nil || !bytes.Equal([]byte{0x12, 0x34}, results) {
+		t.Fatalf("no fault: % x, %v", results, err)
+	}
+	_, err := read(2)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeServerDeviceBusy {
+		t.Fatalf("exception: unexpected error %v", err)
+	}
+	for _, slave := range []byte{3, 5, 6, 7} {
+		if _, err = read(slave); err == nil {
+			t.Fatalf("slave %v: error expected", slave)
+		}
+	}
+	if results, err := read(4); err != nil || !bytes.Equal([]byte{0x12, 0x34}, results) {
+		t.Fatalf("split: % x, %v", results, err)
+	}
+}
+
+func TestTCPServerSplitSegments(t *testing.T) {
+	faults := NewFaultInjector(1)
+	faults.AddRule(FaultRule{Fault: Fault{Split: 2, SplitDelay: 50 * time.Millisecond}})
+	conn, err := net.Dial("tcp", startFaultyTCPServer(t, faults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var b [32]byte
+	n, err := conn.Read(b[:])
+	if err != nil || n != 6 {
+		t.Fatalf("first segment: %v bytes, %v", n, err)
+	}
+	if _, err = io.ReadFull(conn, b[n:11]); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 1, 0, 0, 0, 5, 1, 3, 2, 0x12, 0x34}; !bytes.Equal(expected, b[:11]) {
+		t.Fatalf("response expected % x, actual % x", expected, b[:11])
+	}
+}
+
+func serveFaulty(t *testing.T, framing Framing, request []byte, fault Fault) []byte {
+	model := NewDataModel(0, 0, 10, 0)
+	model.WriteSingleRegister(1, 0, 0x1234)
+	port := &loopPort{Reader: bytes.NewReader(request)}
+	server := NewSerialServer(port, framing)
+	server.Faults = NewFaultInjector(1)
+	server.Faults.AddRule(FaultRule{FunctionCodes: []byte{FuncCodeReadHoldingRegisters}, Fault: fault})
+	if err := server.ServeModbus(model); err != io.EOF {
+		t.Fatalf("error expected %v, actual %v", io.EOF, err)
+	}
+	return port.Bytes()
+}
+
+func TestSerialServerFaults(t *testing.T) {
+	request := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}
+	cases := []struct {
+		fault    Fault
+		expected []byte
+	}{
+		{Fault{}, []byte{0x01, 0x03, 0x02, 0x12, 0x34, 0xB5, 0x33}},
+		{Fault{CorruptChecksum: true}, []byte{0x01, 0x03, 0x02, 0x12, 0x34, 0x4A, 0xCC}},
+		{Fault{TrailingBytes: []byte{0, 0}}, []byte{0x01, 0x03, 0x02, 0x12, 0x34, 0xB5, 0x33, 0, 0}},
+		{Fault{CloseMidFrame: true}, []byte{0x01, 0x03, 0x02}},
+		{Fault{Drop: true}, nil},
+	}
+	for _, c := range cases {
+		if actual := serveFaulty(t, FramingRTU, request, c.fault); !bytes.Equal(c.expected, actual) {
+			t.Errorf("%+v: expected % x, actual % x", c.fault, c.expected, actual)
+		}
+	}
+	actual := serveFaulty(t, FramingRTU, request, Fault{WrongUnitID: true})
+	if len(actual) != 7 || actual[0] != 2 || !rtuValid(actual) {
+		t.Errorf("wrong unit id: % x", actual)
+	}
+	actual = serveFaulty(t, FramingASCII, []byte(":010300000001FB\r\n"), Fault{CorruptChecksum: true})
+	if expected := ":0103021234BB\r\n"; string(actual) != expected {
+		t.Errorf("ascii: expected %q, actual %q", expected, actual)
+	}
+}
+
+func TestFaultProbability(t *testing.T) {
+	faults := NewFaultInjector(1)
+	faults.AddRule(FaultRule{Probability: 0.25, Fault: Fault{Drop: true}})
+	request := &PDUwithSlaveid{1, ProtocolDataUnit{FunctionCode: FuncCodeReadCoils}}
+	n := 0
+	for i := 0; i < 1000; i++ {
+		if faults.fault(request) != nil {
+			n++
+		}
+	}
+	if n < 200 || n > 300 {
+		t.Fatalf("%v faults out of 1000 with probability 0.25", n)
+	}
+	var none *FaultInjector
+	if none.fault(request) != nil {
+		t.Fatal("nil injector: no fault expected")
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GatewayBus is a link of a Gateway to slaves: a serial bus through a RTU
+// or ASCII client handler, or a Modbus/TCP device through a
+// TCPClientHandler. Requests sent on a bus are serialized.
+type GatewayBus struct {
+	handler ClientHandler
+	// busy holds a value while a request is sent
+	busy chan struct{}
+}
+
+// NewGatewayBus allocates a bus sending requests with handler.
+func NewGatewayBus(handler ClientHandler) *GatewayBus {
+	return &GatewayBus{handler: handler, busy: make(chan struct{}, 1)}
+}
+
+// GatewayRoute is the bus and slave id of a unit id.
+type GatewayRoute struct {
+	Bus *GatewayBus
+	// SlaveID on the bus, the unit id if zero
+	SlaveID byte
+	// Timeout of responses, the timeout of the gateway if zero
+	Timeout time.Duration
+}
+
+// Gateway forwards requests received by a TcpServer or a SerialServer to
+// buses according to their unit id, e.g. from Modbus/TCP to RTU slaves or
+// from a RTU master to Modbus/TCP devices. Unit ids without route are
+// answered with a gateway path unavailable exception by a TcpServer and not
+// answered by a SerialServer, where they may be other slaves of the line.
+// Buses which cannot be connected are answered with a gateway path
+// unavailable exception, requests without valid response with a gateway
+// target device failed to respond exception. Requests to unit id 0 are
+// broadcast on all buses and not answered.
+type Gateway struct {
+	// Timeout of routes without timeout, that of the bus handlers if
+	// zero. Serial masters must receive the exception before their own
+	// timeout expires.
+	Timeout time.Duration
+	// Logger logs forwarding failures if set
+	Logger *log.Logger
+
+	mu     sync.RWMutex
+	routes map[byte]*GatewayRoute
+}
+
+// NewGateway allocates a gateway without routes.
+func NewGateway() *Gateway {
+	return &Gateway{
+		routes: make(map[byte]*GatewayRoute),
+	}
+}
+
+// AddRoute routes the requests to unitID, replacing any previous route.
+func (g *Gateway) AddRoute(unitID byte, route GatewayRoute) error {
+	if unitID == 0 || route.Bus == nil {
+		return fmt.Errorf("modbus: invalid route of unit id '%v'", unitID)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.routes[unitID] = &route
+	return nil
+}
+
+// ServeTCP forwards the requests received by server until its listener is
+// closed.
+func (g *Gateway) ServeTCP(server *TcpServer) {
+	server.serve(func(pdu *PDUwithSlaveid) *PDUwithSlaveid {
+		return g.handle(pdu, false)
+	})
+}
+
+// ServeSerial forwards the requests received by server until its port is
+// closed or fails, it returns the read error.
+func (g *Gateway) ServeSerial(server *SerialServer) error {
+	return server.serve(func(pdu *PDUwithSlaveid) *PDUwithSlaveid {
+		return g.handle(pdu, true)
+	})
+}
+
+// handle forwards a request and returns the response of the slave. Unit ids
+// without route are not answered on a serial line.
+func (g *Gateway) handle(pdu *PDUwithSlaveid, serial bool) *PDUwithSlaveid {
+	if pdu.SlaveID == 0 {
+		g.broadcast(pdu)
+		return nil
+	}
+	g.mu.RLock()
+	route, ok := g.routes[pdu.SlaveID]
+	g.mu.RUnlock()
+	if !ok {
+		g.logf("modbus: no route to unit id '%v'", pdu.SlaveID)
+		if serial {
+			return nil
+		}
+		return encodeMbError(pdu.SlaveID, pdu.FunctionCode, ExceptionCodeGatewayPathUnavailable)
+	}
+	request := *pdu
+	if route.SlaveID != 0 {
+		request.SlaveID = route.SlaveID
+	}
+	timeout := route.Timeout
+	if timeout <= 0 {
+		timeout = g.Timeout
+	}
+	response, err := route.Bus.forward(&request, timeout)
+	if err != nil {
+		g.logf("modbus: unit id '%v' (slave id '%v') failed to respond: %v", pdu.SlaveID, request.SlaveID, err)
+		return encodeMbError(pdu.SlaveID, pdu.FunctionCode, gatewayExceptionCode(err))
+	}
+	response.SlaveID = pdu.SlaveID
+	return response
+}
+
+// broadcast sends a request to slave id 0 of every bus without waiting.
+func (g *Gateway) broadcast(pdu *PDUwithSlaveid) {
+	g.mu.RLock()
+	buses := make(map[*GatewayBus]bool)
+	for _, route := range g.routes {
+		buses[route.Bus] = true
+	}
+	g.mu.RUnlock()
+	for bus := range buses {
+		// Encode now, the request data is reused by the server
+		aduRequest, err := bus.handler.Encode(pdu)
+		if err != nil {
+			continue
+		}
+		go bus.broadcast(aduRequest)
+	}
+}
+
+// gatewayExceptionCode returns the exception answering a forwarding error:
+// path unavailable if the bus cannot be connected, i.e. the device cannot be
+// dialed or the serial port opened, target device failed to respond
+// otherwise.
+func gatewayExceptionCode(err error) byte {
+	var opError *net.OpError
+	if errors.As(err, &opError) && opError.Op == "dial" {
+		return ExceptionCodeGatewayPathUnavailable
+	}
+	var pathError *os.PathError
+	if errors.As(err, &pathError) && pathError.Op == "open" {
+		return ExceptionCodeGatewayPathUnavailable
+	}
+	return ExceptionCodeGatewayTargetDeviceFailedToRespond
+}
+
+// gatewayResult is the outcome of a request sent on a bus.
+type gatewayResult struct {
+	pdu *PDUwithSlaveid
+	err error
+}
+
+// forward sends a request on the bus and returns the response. The timeout
+// includes the wait for the requests queued before, a request whose timeout
+// expires before the bus is free is not sent. The bus is released when the
+// handler returns, even if timeout expired before.
+func (bus *GatewayBus) forward(pdu *PDUwithSlaveid, timeout time.Duration) (*PDUwithSlaveid, error) {
+	aduRequest, err := bus.handler.Encode(pdu)
+	if err != nil {
+		return nil, err
+	}
+	// expired is nil, and never ready, without timeout
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case bus.busy <- struct{}{}:
+	case <-expired:
+		return nil, fmt.Errorf("modbus: bus busy for '%v'", timeout)
+	}
+	done := make(chan gatewayResult, 1)
+	go func() {
+		defer func() { <-bus.busy }()
+		var r gatewayResult
+		aduResponse, err := bus.handler.Send(aduRequest)
+		if err == nil {
+			err = bus.handler.Verify(aduRequest, aduResponse)
+		}
+		if err == nil {
+			r.pdu, err = bus.handler.Decode(aduResponse)
+		}
+		r.err = err
+		done <- r
+	}()
+	select {
+	case r := <-done:
+		return r.pdu, r.err
+	case <-expired:
+		return nil, fmt.Errorf("modbus: no response within '%v'", timeout)
+	}
+}
+
+// broadcast sends a request without response. Buses wait for the read
+// timeout of their handler, which is the turnaround delay of broadcasts.
+func (bus *GatewayBus) broadcast(aduRequest []byte) {
+	bus.busy <- struct{}{}
+	defer func() { <-bus.busy }()
+	bus.handler.Send(aduRequest)
+}
+
+// Routes returns the routed unit ids in increasing order.
+func (g *Gateway) Routes() []byte {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	ids := make([]int, 0, len(g.routes))
+	for id := range g.routes {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	units := make([]byte, len(ids))
+	for i, id := range ids {
+		units[i] = byte(id)
+	}
+	return units
+}
+
+func (g *Gateway) logf(format string, v ...interface{}) {
+	if g.Logger != nil {
+		g.Logger.Printf(format, v...)
+	}
+}
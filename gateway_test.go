@@ -0,0 +1,274 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// busHandler is a serial bus of slaves sharing a data model.
+type busHandler struct {
+	ClientHandler
+	slaves map[byte]bool
+	delay  time.Duration
+
+	mu       sync.Mutex
+	requests [][]byte
+	active   int
+	overlap  bool
+}
+
+func newBusHandler(model *DataModel, slaves ...byte) *busHandler {
+	h := &busHandler{slaves: make(map[byte]bool)}
+	for _, s := range slaves {
+		h.slaves[s] = true
+	}
+	h.ClientHandler = newLoopbackHandler(FramingRTU, func(pdu *PDUwithSlaveid) *PDUwithSlaveid {
+		if !h.slaves[pdu.SlaveID] && pdu.SlaveID != 0 {
+			return nil
+		}
+		return mbServerHandler(model, "")(pdu)
+	})
+	return h
+}
+
+func (h *busHandler) Send(aduRequest []byte) ([]byte, error) {
+	h.mu.Lock()
+	h.requests = append(h.requests, append([]byte(nil), aduRequest...))
+	h.active++
+	h.overlap = h.overlap || h.active > 1
+	h.mu.Unlock()
+	time.Sleep(h.delay)
+	defer func() {
+		h.mu.Lock()
+		h.active--
+		h.mu.Unlock()
+	}()
+	return h.ClientHandler.Send(aduRequest)
+}
+
+func TestGateway(t *testing.T) {
+	model1 := NewDataModel(0, 0, 10, 0)
+	model1.WriteSingleRegister(1, 0, 0x1111)
+	model2 := NewDataModel(0, 0, 10, 0)
+	model2.WriteSingleRegister(1, 0, 0x2222)
+	bus1, bus2 := newBusHandler(model1, 1), newBusHandler(model2, 5)
+	g := NewGateway()
+	g.AddRoute(1, GatewayRoute{Bus: NewGatewayBus(bus1)})
+	g.AddRoute(2, GatewayRoute{Bus: NewGatewayBus(bus2), SlaveID: 5})
+	g.AddRoute(3, GatewayRoute{Bus: NewGatewayBus(bus2)})
+	if units := g.Routes(); !bytes.Equal([]byte{1, 2, 3}, units) {
+		t.Fatalf("routes %v", units)
+	}
+	client := clientOf(startGateway(t, g))
+
+	if results, err := client.ReadHoldingRegisters(1, 0, 1); err != nil || !bytes.Equal([]byte{0x11, 0x11}, results) {
+		t.Fatalf("unit 1: % x, %v", results, err)
+	}
+	// Unit 2 is slave 5 on the second bus
+	if results, err := client.ReadHoldingRegisters(2, 0, 1); err != nil || !bytes.Equal([]byte{0x22, 0x22}, results) {
+		t.Fatalf("unit 2: % x, %v", results, err)
+	}
+	if bus2.requests[0][0] != 5 {
+		t.Fatalf("unit 2 not remapped: % x", bus2.requests[0])
+	}
+	// Slave exceptions are passed through
+	_, err := client.ReadHoldingRegisters(1, 20, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("exception expected, actual %v", err)
+	}
+	_, err = client.ReadHoldingRegisters(3, 0, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeGatewayTargetDeviceFailedToRespond {
+		t.Fatalf("target failed to respond expected, actual %v", err)
+	}
+	_, err = client.ReadHoldingRegisters(4, 0, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeGatewayPathUnavailable {
+		t.Fatalf("path unavailable expected, actual %v", err)
+	}
+}
+
+func TestGatewayPathUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+	g := NewGateway()
+	g.AddRoute(1, GatewayRoute{Bus: NewGatewayBus(NewTCPClientHandler(address))})
+	g.AddRoute(2, GatewayRoute{Bus: NewGatewayBus(NewRTUClientHandler("/nonexistent/ttyS0"))})
+	g.AddRoute(3, GatewayRoute{Bus: NewGatewayBus(NewASCIIClientHandler("/nonexistent/ttyS0"))})
+	for _, unitID := range []byte{1, 2, 3} {
+		response := g.handle(&PDUwithSlaveid{unitID, ProtocolDataUnit{FuncCodeReadHoldingRegisters, []byte{0, 0, 0, 1}}}, false)
+		expected := encodeMbError(unitID, FuncCodeReadHoldingRegisters, ExceptionCodeGatewayPathUnavailable)
+		if !reflect.DeepEqual(expected, response) {
+			t.Errorf("unit %v: expected %v, actual %v", unitID, expected, response)
+		}
+	}
+}
+
+func TestGatewayBroadcast(t *testing.T) {
+	model1, model2 := NewDataModel(0, 0, 10, 0), NewDataModel(0, 0, 10, 0)
+	bus1, bus2 := newBusHandler(model1, 1), newBusHandler(model2, 2)
+	g := NewGateway()
+	g.AddRoute(1, GatewayRoute{Bus: NewGatewayBus(bus1)})
+	g.AddRoute(2, GatewayRoute{Bus: NewGatewayBus(bus2)})
+	handler := NewTCPClientHandler(startGateway(t, g))
+	handler.Timeout = 100 * time.Millisecond
+	defer handler.Close()
+	// No response to broadcasts
+	if _, err := NewClient(handler).WriteSingleRegister(0, 3, 7); err == nil {
+		t.Fatal("broadcast: no response expected")
+	}
+	for _, model := range []*DataModel{model1, model2} {
+		if values, _ := model.ReadHoldingRegisters(0, 3, 1); values[0] != 7 {
+			t.Errorf("broadcast not forwarded: %v", values)
+		}
+	}
+}
+
+func TestGatewayTimeoutAndSerialization(t *testing.T) {
+	model := NewDataModel(0, 0, 10, 0)
+	bus := newBusHandler(model, 1, 2)
+	bus.delay = 50 * time.Millisecond
+	g := NewGateway()
+	gbus := NewGatewayBus(bus)
+	g.AddRoute(1, GatewayRoute{Bus: gbus, Timeout: 10 * time.Millisecond})
+	g.AddRoute(2, GatewayRoute{Bus: gbus})
+	address := startGateway(t, g)
+
+	_, err := clientOf(address).ReadHoldingRegisters(1, 0, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeGatewayTargetDeviceFailedToRespond {
+		t.Fatalf("route timeout: unexpected error %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := clientOf(address).ReadHoldingRegisters(2, 0, 1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if bus.overlap {
+		t.Fatal("concurrent requests on the bus")
+	}
+}
+
+func TestGatewayTimeoutIncludesQueue(t *testing.T) {
+	bus := newBusHandler(NewDataModel(0, 0, 10, 0), 1)
+	bus.delay = 100 * time.Millisecond
+	g := NewGateway()
+	g.AddRoute(1, GatewayRoute{Bus: NewGatewayBus(bus), Timeout: 150 * time.Millisecond})
+
+	// The first request is answered, the second one times out while sent
+	// and the third one while queued
+	start := time.Now()
+	responses := make(chan *PDUwithSlaveid, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			responses <- g.handle(&PDUwithSlaveid{1, ProtocolDataUnit{FuncCodeReadHoldingRegisters, []byte{0, 0, 0, 1}}}, false)
+		}()
+	}
+	failed := 0
+	for i := 0; i < 3; i++ {
+		if response := <-responses; response.FunctionCode&0x80 != 0 {
+			failed++
+		}
+	}
+	if elapsed := time.Since(start); failed != 2 || elapsed > 190*time.Millisecond {
+		t.Fatalf("%v requests failed in %v", failed, elapsed)
+	}
+	time.Sleep(150 * time.Millisecond)
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if len(bus.requests) != 2 {
+		t.Fatalf("%v requests sent, expected 2", len(bus.requests))
+	}
+}
+
+func startGateway(t *testing.T, g *Gateway) string {
+	server, err := NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.ServeTCP(server)
+	t.Cleanup(func() { server.Close() })
+	return server.Addr().String()
+}
+
+func clientOf(address string) Client {
+	handler := NewTCPClientHandler(address)
+	handler.Timeout = time.Second
+	return NewClient(handler)
+}
+
+func TestReverseGateway(t *testing.T) {
+	model := NewDataModel(0, 0, 10, 0)
+	model.WriteSingleRegister(9, 0, 0x1234)
+	device, err := NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+	go device.ServeModbus(model)
+	// A device accepting connections without answering
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	// A closed port
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+
+	g := NewGateway()
+	g.Timeout = 100 * time.Millisecond
+	for unit, address := range map[byte]string{1: device.Addr().String(), 2: closed.Addr().String(), 3: silent.Addr().String()} {
+		handler := NewTCPClientHandler(address)
+		handler.Timeout = 200 * time.Millisecond
+		defer handler.Close()
+		g.AddRoute(unit, GatewayRoute{Bus: NewGatewayBus(handler), SlaveID: 9})
+	}
+	// Unit 4 without route is not answered
+	var requests []byte
+	for _, unit := range []byte{1, 4, 2, 3} {
+		adu, _ := NewPackager(FramingRTU).Encode(&PDUwithSlaveid{unit, ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{0, 0, 0, 1}}})
+		requests = append(requests, adu...)
+	}
+	port := &loopPort{Reader: bytes.NewReader(requests)}
+	start := time.Now()
+	if err = g.ServeSerial(NewSerialServer(port, FramingRTU)); err != io.EOF {
+		t.Fatalf("error expected %v, actual %v", io.EOF, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("responses took %v", elapsed)
+	}
+	var expected []byte
+	for _, pdu := range []*PDUwithSlaveid{
+		{1, ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{2, 0x12, 0x34}}},
+		encodeMbError(2, FuncCodeReadHoldingRegisters, ExceptionCodeGatewayPathUnavailable),
+		encodeMbError(3, FuncCodeReadHoldingRegisters, ExceptionCodeGatewayTargetDeviceFailedToRespond),
+	} {
+		adu, _ := NewPackager(FramingRTU).Encode(pdu)
+		expected = append(expected, adu...)
+	}
+	if !bytes.Equal(expected, port.Bytes()) {
+		t.Fatalf("responses expected % x, actual % x", expected, port.Bytes())
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Request is a request received by a server.
+type Request struct {
+	UnitID       byte
+	FunctionCode byte
+	// Data of the request, it must not be retained after the handler
+	// returns
+	Data []byte
+	// RemoteAddr is the address of the client, or the name of the serial
+	// port
+	RemoteAddr string
+	// Transport is the framing of the request: "tcp", "rtu" or "ascii"
+	Transport string
+}
+
+// Address returns the first address and the quantity of the registers or
+// coils accessed by the request, or a zero quantity if not known. The
+// write range of function 23 is returned by WriteAddress.
+func (r *Request) Address() (address, quantity uint16) {
+	return requestAddressQuantity(&ProtocolDataUnit{FunctionCode: r.FunctionCode, Data: r.Data})
+}
+
+// WriteAddress returns the write range of a read/write multiple registers
+// request, or that of Address for other writes.
+func (r *Request) WriteAddress() (address, quantity uint16) {
+	if wr, ok := writeRange(&PDUwithSlaveid{r.UnitID, ProtocolDataUnit{FunctionCode: r.FunctionCode, Data: r.Data}}); ok {
+		return uint16(wr.address), uint16(wr.quantity)
+	}
+	return 0, 0
+}
+
+// ResponseWriter answers a request. No response is sent if a handler
+// writes nothing, e.g. to broadcast requests.
+type ResponseWriter interface {
+	// Write answers with data and the function code of the request.
+	Write(data []byte) (int, error)
+	// WriteException answers with an exception.
+	WriteException(exceptionCode byte)
+}
+
+// Handler answers the requests received by a server.
+type Handler interface {
+	ServeModbus(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc is a function used as Handler.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+// ServeModbus calls f(w, r).
+func (f HandlerFunc) ServeModbus(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// Middleware wraps a handler, e.g. to log, authenticate or limit requests.
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middlewares, the first one is called first.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// ModelHandler returns a handler calling the methods of model, e.g. a
+// DataModel, like TcpServer.ServeModbus.
+func ModelHandler(model mbHandler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		writePDU(w, mbServerHandler(model, r.RemoteAddr)(&PDUwithSlaveid{r.UnitID, ProtocolDataUnit{FunctionCode: r.FunctionCode, Data: r.Data}}))
+	})
+}
+
+// writePDU writes a response built by a serverHandler.
+func writePDU(w ResponseWriter, pdu *PDUwithSlaveid) {
+	switch {
+	case pdu == nil:
+	case pdu.FunctionCode&0x80 != 0 && len(pdu.Data) == 1:
+		w.WriteException(pdu.Data[0])
+	default:
+		w.Write(pdu.Data)
+	}
+}
+
+// responseWriter builds the response of a request.
+type responseWriter struct {
+	request  *PDUwithSlaveid
+	response *PDUwithSlaveid
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	w.response = &PDUwithSlaveid{w.request.SlaveID,
+		ProtocolDataUnit{
+			FunctionCode: w.request.FunctionCode,
+			Data:         append([]byte(nil), data...),
+		}}
+	return len(data), nil
+}
+
+func (w *responseWriter) WriteException(exceptionCode byte) {
+	w.response = encodeMbError(w.request.SlaveID, w.request.FunctionCode, exceptionCode)
+}
+
+// handlerServer adapts a Handler to a serverHandler of a client or port.
+func handlerServer(handler Handler, transport, remote string) serverHandler {
+	return func(pdu *PDUwithSlaveid) *PDUwithSlaveid {
+		w := &responseWriter{request: pdu}
+		handler.ServeModbus(w, &Request{
+			UnitID:       pdu.SlaveID,
+			FunctionCode: pdu.FunctionCode,
+			Data:         pdu.Data,
+			RemoteAddr:   remote,
+			Transport:    transport,
+		})
+		return w.response
+	}
+}
+
+// Route selects the requests of a ServeMux handler. Empty fields match
+// all requests.
+type Route struct {
+	UnitIDs       []byte
+	FunctionCodes []byte
+	// Addresses of the request, which must be within one of the ranges
+	Addresses []AddressRange
+}
+
+// ServeMux routes requests to the handler of the first matching route in
+// the order they were added. Requests to unit ids without route are not
+// answered, function codes without route are answered with an illegal
+// function exception, and addresses without route with an illegal data
+// address exception.
+type ServeMux struct {
+	mu     sync.RWMutex
+	routes []muxEntry
+}
+
+type muxEntry struct {
+	route   Route
+	handler Handler
+}
+
+// NewServeMux allocates a ServeMux without routes.
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// Handle routes the requests matching route to handler.
+func (mux *ServeMux) Handle(route Route, handler Handler) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.routes = append(mux.routes, muxEntry{route, handler})
+}
+
+// HandleFunc routes the requests matching route to f.
+func (mux *ServeMux) HandleFunc(route Route, f func(w ResponseWriter, r *Request)) {
+	mux.Handle(route, HandlerFunc(f))
+}
+
+// ServeModbus implements Handler.
+func (mux *ServeMux) ServeModbus(w ResponseWriter, r *Request) {
+	mux.mu.RLock()
+	unitFound, functionFound := false, false
+	var handler Handler
+	for i := range mux.routes {
+		e := &mux.routes[i]
+		if !containsByte(e.route.UnitIDs, r.UnitID) {
+			continue
+		}
+		unitFound = true
+		if !containsByte(e.route.FunctionCodes, r.FunctionCode) {
+			continue
+		}
+		functionFound = true
+		if e.route.matchAddress(r) {
+			handler = e.handler
+			break
+		}
+	}
+	mux.mu.RUnlock()
+	switch {
+	case handler != nil:
+		handler.ServeModbus(w, r)
+	case functionFound:
+		w.WriteException(ExceptionCodeIllegalDataAddress)
+	case unitFound:
+		w.WriteException(ExceptionCodeIllegalFunction)
+	}
+}
+
+// matchAddress returns true if the read and write ranges of a request are
+// within a range of the route.
+func (route *Route) matchAddress(r *Request) bool {
+	if len(route.Addresses) == 0 {
+		return true
+	}
+	address, quantity := r.Address()
+	if !route.containsRange(address, quantity) {
+		return false
+	}
+	if r.FunctionCode == FuncCodeReadWriteMultipleRegisters {
+		address, quantity = r.WriteAddress()
+		return route.containsRange(address, quantity)
+	}
+	return true
+}
+
+func (route *Route) containsRange(address, quantity uint16) bool {
+	if quantity == 0 {
+		quantity = 1
+	}
+	last := int(address) + int(quantity) - 1
+	for _, ar := range route.Addresses {
+		if address >= ar.Start && last <= int(ar.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogRequests returns a middleware logging every request with its
+// response and latency.
+func LogRequests(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			start := time.Now()
+			lw := &loggingWriter{ResponseWriter: w}
+			next.ServeModbus(lw, r)
+			address, quantity := r.Address()
+			logger.Printf("modbus: %v %v unit id '%v' function '%v' address '%v' quantity '%v': %v in %v",
+				r.Transport, r.RemoteAddr, r.UnitID, r.FunctionCode, address, quantity, lw.result(), time.Since(start))
+		})
+	}
+}
+
+// loggingWriter records the outcome of a response.
+type loggingWriter struct {
+	ResponseWriter
+	written   bool
+	exception byte
+}
+
+func (w *loggingWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *loggingWriter) WriteException(exceptionCode byte) {
+	w.written, w.exception = true, exceptionCode
+	w.ResponseWriter.WriteException(exceptionCode)
+}
+
+func (w *loggingWriter) result() string {
+	switch {
+	case !w.written:
+		return "no response"
+	case w.exception != 0:
+		return ExceptionName(w.exception)
+	}
+	return "ok"
+}
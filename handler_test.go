@@ -0,0 +1,129 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeMux(t *testing.T) {
+	model1 := NewDataModel(0, 0, 100, 0)
+	model1.WriteSingleRegister(1, 0, 0x1111)
+	model2 := NewDataModel(0, 0, 100, 0)
+	model2.WriteSingleRegister(2, 10, 0x2222)
+	var last *Request
+	mux := NewServeMux()
+	mux.Handle(Route{UnitIDs: []byte{1}}, ModelHandler(model1))
+	mux.Handle(Route{
+		UnitIDs:       []byte{2},
+		FunctionCodes: []byte{FuncCodeReadHoldingRegisters, FuncCodeWriteSingleRegister},
+		Addresses:     []AddressRange{{10, 19}},
+	}, ModelHandler(model2))
+	mux.HandleFunc(Route{UnitIDs: []byte{3}}, func(w ResponseWriter, r *Request) {
+		last = r
+		w.Write([]byte{2, 0xAB, 0xCD})
+	})
+
+	server, err := NewTcpServerAddress("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	var logs bytes.Buffer
+	go server.ServeHandler(context.Background(), Chain(mux, LogRequests(log.New(&logs, "", 0))))
+	handler := NewTCPClientHandler(server.Addr().String())
+	handler.Timeout = 200 * time.Millisecond
+	defer handler.Close()
+	client := NewClient(handler)
+
+	if results, err := client.ReadHoldingRegisters(1, 0, 1); err != nil || !bytes.Equal([]byte{0x11, 0x11}, results) {
+		t.Fatalf("unit 1: % x, %v", results, err)
+	}
+	if results, err := client.ReadHoldingRegisters(2, 10, 10); err != nil || !bytes.Equal([]byte{0x22, 0x22}, results[:2]) {
+		t.Fatalf("unit 2: % x, %v", results, err)
+	}
+	for _, c := range []struct {
+		err       error
+		exception byte
+	}{
+		{ignoreResult(client.ReadHoldingRegisters(2, 15, 10)), ExceptionCodeIllegalDataAddress},
+		{ignoreResult(client.ReadInputRegisters(2, 10, 1)), ExceptionCodeIllegalFunction},
+	} {
+		if mbError, ok := c.err.(*ModbusError); !ok || mbError.ExceptionCode != c.exception {
+			t.Errorf("exception %v expected, actual %v", c.exception, c.err)
+		}
+	}
+	if results, err := client.ReadInputRegisters(3, 7, 1); err != nil || !bytes.Equal([]byte{0xAB, 0xCD}, results) {
+		t.Fatalf("unit 3: % x, %v", results, err)
+	}
+	if last.Transport != "tcp" || last.RemoteAddr != handler.conn.LocalAddr().String() || last.FunctionCode != FuncCodeReadInputRegisters {
+		t.Fatalf("unexpected request %+v", last)
+	}
+	if address, quantity := last.Address(); address != 7 || quantity != 1 {
+		t.Fatalf("unexpected address %v quantity %v", address, quantity)
+	}
+	// Unknown unit ids are not answered
+	if _, err = client.ReadHoldingRegisters(4, 0, 1); err == nil {
+		t.Fatal("unit 4: no response expected")
+	}
+	server.Shutdown(context.Background())
+	if !strings.Contains(logs.String(), "unit id '2' function '3' address '15' quantity '10': illegal data address") ||
+		!strings.Contains(logs.String(), "unit id '4' function '3' address '0' quantity '1': no response") {
+		t.Fatalf("unexpected logs:\n%v", logs.String())
+	}
+}
+
+func ignoreResult(_ []byte, err error) error {
+	return err
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, r *Request) {
+				calls = append(calls, name)
+				if r.UnitID == 9 && name == "auth" {
+					w.WriteException(ExceptionCodeIllegalFunction)
+					return
+				}
+				next.ServeModbus(w, r)
+			})
+		}
+	}
+	handler := Chain(ModelHandler(NewDataModel(0, 0, 10, 0)), middleware("log"), middleware("auth"))
+	serve := handlerServer(handler, transportRTU, "/dev/ttyS0")
+	response := serve(&PDUwithSlaveid{9, ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{0, 0, 0, 1}}})
+	if response.FunctionCode != 0x83 || response.Data[0] != ExceptionCodeIllegalFunction {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	if strings.Join(calls, ",") != "log,auth" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+	response = serve(&PDUwithSlaveid{1, ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{0, 0, 0, 1}}})
+	if response.FunctionCode != FuncCodeReadHoldingRegisters || !bytes.Equal([]byte{2, 0, 0}, response.Data) {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
+
+func TestSerialServerHandler(t *testing.T) {
+	var remote, transport string
+	mux := NewServeMux()
+	mux.HandleFunc(Route{UnitIDs: []byte{1}}, func(w ResponseWriter, r *Request) {
+		remote, transport = r.RemoteAddr, r.Transport
+		w.Write([]byte{2, 0, 5})
+	})
+	request, _ := NewPackager(FramingRTU).Encode(&PDUwithSlaveid{1, ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{0, 0, 0, 1}}})
+	port := &loopPort{Reader: bytes.NewReader(request)}
+	NewSerialServer(port, FramingRTU).ServeHandler(mux)
+	expected, _ := NewPackager(FramingRTU).Encode(&PDUwithSlaveid{1, ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{2, 0, 5}}})
+	if !bytes.Equal(expected, port.Bytes()) {
+		t.Fatalf("response expected % x, actual % x", expected, port.Bytes())
+	}
+	if remote != transportSerial || transport != "rtu" {
+		t.Fatalf("unexpected remote %v transport %v", remote, transport)
+	}
+}
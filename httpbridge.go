@@ -0,0 +1,428 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Largest quantity of registers of a write request
+const maxWriteRegisters = 123
+
+// httpTables are the tables in bridge paths.
+var httpTables = map[string]Table{
+	"coils":    TableCoils,
+	"discrete": TableDiscreteInputs,
+	"holding":  TableHoldingRegisters,
+	"input":    TableInputRegisters,
+}
+
+// HTTPBridge is an http.Handler exposing named devices as JSON resources:
+//
+//	GET /devices
+//	GET /devices/{name}/{table}/{address}?count=2&type=float32&order=cdab
+//	PUT /devices/{name}/{table}/{address}?type=int16  {"values": [-1, 2]}
+//
+// Table is coils, discrete, holding or input. Count is the number of
+// values, 1 by default, type and order apply to registers as in
+// DecodeValues. NaN and infinite floats are answered as the strings "NaN",
+// "+Inf" and "-Inf". Coils take boolean values. Exceptions are answered
+// with HTTPStatus of the exception code, other errors with 502 or 504 if
+// the device timed out.
+//
+// Writes are rejected unless allowed by AllowWrite. Successful writes are
+// answered with the values read back, or with readback_error if they could
+// not be read.
+type HTTPBridge struct {
+	// ReadOnly rejects all writes
+	ReadOnly bool
+	// Logger logs failed requests if set
+	Logger *log.Logger
+
+	mu      sync.RWMutex
+	devices map[string]*bridgeDevice
+}
+
+// bridgeDevice is a registered device, requests are serialized.
+type bridgeDevice struct {
+	mu       sync.Mutex
+	client   Client
+	slaveID  byte
+	writable map[Table][]AddressRange
+}
+
+// NewHTTPBridge allocates a bridge without devices.
+func NewHTTPBridge() *HTTPBridge {
+	return &HTTPBridge{
+		devices: make(map[string]*bridgeDevice),
+	}
+}
+
+// AddDevice registers a device under name, replacing any device of the
+// same name.
+func (b *HTTPBridge) AddDevice(name string, client Client, slaveID byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.devices[name] = &bridgeDevice{
+		client:   client,
+		slaveID:  slaveID,
+		writable: make(map[Table][]AddressRange),
+	}
+}
+
+// AllowWrite allows writes to a range of coils or holding registers of a
+// device.
+func (b *HTTPBridge) AllowWrite(name string, table Table, r AddressRange) error {
+	if table != TableCoils && table != TableHoldingRegisters {
+		return fmt.Errorf("modbus: %v are not writable", table)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	device, ok := b.devices[name]
+	if !ok {
+		return fmt.Errorf("modbus: unknown device '%v'", name)
+	}
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	device.writable[table] = append(device.writable[table], r)
+	return nil
+}
+
+// HTTPStatus returns the HTTP status code answering an exception code.
+func HTTPStatus(exceptionCode byte) int {
+	switch exceptionCode {
+	case ExceptionCodeIllegalFunction:
+		return http.StatusNotImplemented
+	case ExceptionCodeIllegalDataAddress:
+		return http.StatusNotFound
+	case ExceptionCodeIllegalDataValue:
+		return http.StatusBadRequest
+	case ExceptionCodeAcknowledge:
+		return http.StatusAccepted
+	case ExceptionCodeServerDeviceBusy:
+		return http.StatusServiceUnavailable
+	case ExceptionCodeGatewayTargetDeviceFailedToRespond:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// bridgeValues is the JSON body of register resources.
+type bridgeValues struct {
+	Device  string        `json:"device"`
+	Table   string        `json:"table"`
+	Address uint16        `json:"address"`
+	Type    string        `json:"type,omitempty"`
+	Order   string        `json:"order,omitempty"`
+	Values  []interface{} `json:"values,omitempty"`
+	// ReadbackError is set if the values could not be read after a
+	// successful write
+	ReadbackError string `json:"readback_error,omitempty"`
+}
+
+// bridgeError is the JSON body of errors.
+type bridgeError struct {
+	Error         string `json:"error"`
+	ExceptionCode byte   `json:"exception_code,omitempty"`
+	Exception     string `json:"exception,omitempty"`
+}
+
+// httpError is an error answered with a status code.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func httpErrorf(status int, format string, v ...interface{}) error {
+	return &httpError{status, fmt.Sprintf(format, v...)}
+}
+
+// ServeHTTP implements http.Handler.
+func (b *HTTPBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var result interface{}
+	var err error
+	switch {
+	case len(parts) == 1 && parts[0] == "devices":
+		if r.Method != http.MethodGet {
+			err = httpErrorf(http.StatusMethodNotAllowed, "method %v not allowed", r.Method)
+			break
+		}
+		result = map[string][]string{"devices": b.names()}
+	case len(parts) == 4 && parts[0] == "devices":
+		result, err = b.serveValues(r, parts[1], parts[2], parts[3])
+	default:
+		err = httpErrorf(http.StatusNotFound, "unknown resource %v", r.URL.Path)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err == nil {
+		// Encode before the status is written to report failures
+		var data []byte
+		if data, err = json.Marshal(result); err == nil {
+			w.Write(append(data, '\n'))
+			return
+		}
+		err = httpErrorf(http.StatusInternalServerError, "%v", err)
+	}
+	status, body := http.StatusBadGateway, &bridgeError{Error: err.Error()}
+	var herr *httpError
+	var mberr *ModbusError
+	var nerr net.Error
+	switch {
+	case errors.As(err, &herr):
+		status = herr.status
+	case errors.As(err, &mberr):
+		status = HTTPStatus(mberr.ExceptionCode)
+		body.ExceptionCode = mberr.ExceptionCode
+		body.Exception = ExceptionName(mberr.ExceptionCode)
+	case errors.As(err, &nerr) && nerr.Timeout():
+		status = http.StatusGatewayTimeout
+	}
+	if b.Logger != nil {
+		b.Logger.Printf("modbus: %v %v: %v", r.Method, r.URL, err)
+	}
+	if status == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", "GET, PUT")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (b *HTTPBridge) names() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.devices))
+	for name := range b.devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serveValues reads or writes the values of a device.
+func (b *HTTPBridge) serveValues(r *http.Request, name, tableName, addr string) (result *bridgeValues, err error) {
+	b.mu.RLock()
+	device, ok := b.devices[name]
+	b.mu.RUnlock()
+	if !ok {
+		err = httpErrorf(http.StatusNotFound, "unknown device '%v'", name)
+		return
+	}
+	table, ok := httpTables[tableName]
+	if !ok {
+		err = httpErrorf(http.StatusNotFound, "unknown table '%v', expected coils, discrete, holding or input", tableName)
+		return
+	}
+	address, err := strconv.ParseUint(addr, 0, 16)
+	if err != nil {
+		err = httpErrorf(http.StatusBadRequest, "invalid address '%v'", addr)
+		return
+	}
+	query := r.URL.Query()
+	result = &bridgeValues{Device: name, Table: tableName, Address: uint16(address)}
+	typ, order := TypeUint16, ValueOrder{}
+	bits := table == TableCoils || table == TableDiscreteInputs
+	if !bits {
+		if v := query.Get("type"); v != "" {
+			if typ, err = ParseValueType(v); err != nil {
+				err = httpErrorf(http.StatusBadRequest, "%v", strings.TrimPrefix(err.Error(), "modbus: "))
+				return
+			}
+		}
+		if order, err = ParseValueOrder(query.Get("order")); err != nil {
+			err = httpErrorf(http.StatusBadRequest, "%v", strings.TrimPrefix(err.Error(), "modbus: "))
+			return
+		}
+		result.Type, result.Order = typ.String(), order.String()
+	}
+
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		count := 1
+		if v := query.Get("count"); v != "" {
+			if count, err = strconv.Atoi(v); err != nil || count <= 0 {
+				err = httpErrorf(http.StatusBadRequest, "invalid count '%v'", v)
+				return
+			}
+		}
+		result.Values, err = device.read(table, uint16(address), count, typ, order)
+	case http.MethodPut:
+		if b.ReadOnly {
+			err = httpErrorf(http.StatusForbidden, "bridge is read-only")
+			return
+		}
+		if table != TableCoils && table != TableHoldingRegisters {
+			err = httpErrorf(http.StatusMethodNotAllowed, "%v are read-only", table)
+			return
+		}
+		var body struct {
+			Value  json.RawMessage   `json:"value"`
+			Values []json.RawMessage `json:"values"`
+		}
+		if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+			err = httpErrorf(http.StatusBadRequest, "invalid body: %v", err)
+			return
+		}
+		if body.Value != nil {
+			body.Values = append([]json.RawMessage{body.Value}, body.Values...)
+		}
+		if len(body.Values) == 0 {
+			err = httpErrorf(http.StatusBadRequest, "no values")
+			return
+		}
+		if err = device.write(table, uint16(address), body.Values, typ, order); err != nil {
+			break
+		}
+		// The write is committed, a failed readback is not an error
+		var rerr error
+		if result.Values, rerr = device.read(table, uint16(address), len(body.Values), typ, order); rerr != nil {
+			result.ReadbackError = rerr.Error()
+			if b.Logger != nil {
+				b.Logger.Printf("modbus: %v %v: readback failed: %v", r.Method, r.URL, rerr)
+			}
+		}
+	default:
+		err = httpErrorf(http.StatusMethodNotAllowed, "method %v not allowed", r.Method)
+	}
+	if err != nil {
+		result = nil
+	}
+	return
+}
+
+// read returns count values of table from address.
+func (d *bridgeDevice) read(table Table, address uint16, count int, typ ValueType, order ValueOrder) (values []interface{}, err error) {
+	quantity := count * typ.Registers()
+	limit := maxReadRegisters
+	if table == TableCoils || table == TableDiscreteInputs {
+		quantity, limit = count, maxReadBits
+	}
+	if quantity > limit || int(address)+quantity > 0x10000 {
+		err = httpErrorf(http.StatusBadRequest, "count %v out of range", count)
+		return
+	}
+	var results []byte
+	switch table {
+	case TableCoils:
+		results, err = d.client.ReadCoils(d.slaveID, address, uint16(quantity))
+	case TableDiscreteInputs:
+		results, err = d.client.ReadDiscreteInputs(d.slaveID, address, uint16(quantity))
+	case TableHoldingRegisters:
+		results, err = d.client.ReadHoldingRegisters(d.slaveID, address, uint16(quantity))
+	case TableInputRegisters:
+		results, err = d.client.ReadInputRegisters(d.slaveID, address, uint16(quantity))
+	}
+	if err != nil {
+		return
+	}
+	if table == TableCoils || table == TableDiscreteInputs {
+		for _, v := range unpackBits(results, quantity) {
+			values = append(values, v)
+		}
+		return
+	}
+	if values, err = DecodeValues(results, typ, order); err != nil {
+		return
+	}
+	for i, v := range values {
+		values[i] = jsonFloat(v)
+	}
+	return
+}
+
+// jsonFloat returns NaN and infinite floats as strings, JSON has no
+// representation of them.
+func jsonFloat(v interface{}) interface{} {
+	var f float64
+	switch n := v.(type) {
+	case float32:
+		f = float64(n)
+	case float64:
+		f = n
+	default:
+		return v
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+// write writes JSON values to table from address.
+func (d *bridgeDevice) write(table Table, address uint16, raw []json.RawMessage, typ ValueType, order ValueOrder) (err error) {
+	quantity := len(raw)
+	if table == TableHoldingRegisters {
+		quantity *= typ.Registers()
+	}
+	if int(address)+quantity > 0x10000 || (table == TableHoldingRegisters && quantity > maxWriteRegisters) || quantity > maxWriteBits {
+		return httpErrorf(http.StatusBadRequest, "%v values out of range", len(raw))
+	}
+	if !d.writeAllowed(table, address, quantity) {
+		return httpErrorf(http.StatusForbidden, "writes to %v %v-%v are not allowed", table, address, int(address)+quantity-1)
+	}
+	if table == TableCoils {
+		bits := make([]bool, len(raw))
+		for i, v := range raw {
+			if err = json.Unmarshal(v, &bits[i]); err != nil {
+				return httpErrorf(http.StatusBadRequest, "invalid coil value %s", v)
+			}
+		}
+		if len(bits) == 1 {
+			value := uint16(0x0000)
+			if bits[0] {
+				value = 0xFF00
+			}
+			_, err = d.client.WriteSingleCoil(d.slaveID, address, value)
+			return
+		}
+		_, err = d.client.WriteMultipleCoils(d.slaveID, address, uint16(len(bits)), packBits(bits))
+		return
+	}
+	var data []byte
+	for _, v := range raw {
+		var number json.Number
+		if err = json.Unmarshal(v, &number); err != nil {
+			return httpErrorf(http.StatusBadRequest, "invalid %v value %s", typ, v)
+		}
+		b, err := EncodeValue(number.String(), typ, order)
+		if err != nil {
+			return httpErrorf(http.StatusBadRequest, "%v", strings.TrimPrefix(err.Error(), "modbus: "))
+		}
+		data = append(data, b...)
+	}
+	if len(data) == 2 {
+		_, err = d.client.WriteSingleRegister(d.slaveID, address, uint16(data[0])<<8|uint16(data[1]))
+		return
+	}
+	_, err = d.client.WriteMultipleRegisters(d.slaveID, address, uint16(len(data)/2), data)
+	return
+}
+
+// writeAllowed returns true if an allowed range holds the written range.
+func (d *bridgeDevice) writeAllowed(table Table, address uint16, quantity int) bool {
+	last := int(address) + quantity - 1
+	for _, r := range d.writable[table] {
+		if address >= r.Start && last <= int(r.End) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package modbus
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestBridge(t *testing.T) (*HTTPBridge, *DataModel, *httptest.Server) {
+	model := NewDataModel(16, 16, 100, 10)
+	model.WriteMultipleRegisters(1, 10, []uint16{0x3F80, 0x0000, 0xFFFF})
+	model.WriteMultipleCoils(1, 0, []bool{true, false, true})
+	bridge := NewHTTPBridge()
+	bridge.AddDevice("plc", NewClient(NewLoopbackHandler(FramingRTU, model)), 1)
+	server := httptest.NewServer(bridge)
+	t.Cleanup(server.Close)
+	return bridge, model, server
+}
+
+func bridgeRequest(t *testing.T, method, url, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var v map[string]interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("%v %v: invalid JSON %q", method, url, data)
+	}
+	return resp.StatusCode, v
+}
+
+func TestHTTPBridgeRead(t *testing.T) {
+	_, model, server := newTestBridge(t)
+	status, v := bridgeRequest(t, "GET", server.URL+"/devices/plc/holding/10?type=float32", "")
+	if status != 200 || v["type"] != "float32" || v["values"].([]interface{})[0] != 1.0 {
+		t.Fatalf("float32: %v %v", status, v)
+	}
+	status, v = bridgeRequest(t, "GET", server.URL+"/devices/plc/holding/12?count=2&type=int16", "")
+	if values := v["values"].([]interface{}); status != 200 || len(values) != 2 || values[0] != -1.0 || values[1] != 0.0 {
+		t.Fatalf("int16: %v %v", status, v)
+	}
+	status, v = bridgeRequest(t, "GET", server.URL+"/devices/plc/coils/0?count=3", "")
+	if values := v["values"].([]interface{}); status != 200 || values[0] != true || values[1] != false || values[2] != true {
+		t.Fatalf("coils: %v %v", status, v)
+	}
+	model.WriteMultipleRegisters(1, 20, []uint16{0x7FC0, 0x0000, 0xFF80, 0x0000})
+	status, v = bridgeRequest(t, "GET", server.URL+"/devices/plc/holding/20?count=2&type=float32", "")
+	if values := v["values"].([]interface{}); status != 200 || values[0] != "NaN" || values[1] != "-Inf" {
+		t.Fatalf("non-finite float32: %v %v", status, v)
+	}
+	status, v = bridgeRequest(t, "GET", server.URL+"/devices", "")
+	if status != 200 || len(v["devices"].([]interface{})) != 1 {
+		t.Fatalf("devices: %v %v", status, v)
+	}
+}
+
+func TestHTTPBridgeErrors(t *testing.T) {
+	bridge, _, server := newTestBridge(t)
+	bridge.AllowWrite("plc", TableHoldingRegisters, AddressRange{0, 9})
+	cases := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/devices/plc/holding/99?count=2", "", http.StatusNotFound},
+		{"GET", "/devices/plc/holding/0?count=126", "", http.StatusBadRequest},
+		{"GET", "/devices/plc/holding/0?type=int128", "", http.StatusBadRequest},
+		{"GET", "/devices/rtu/holding/0", "", http.StatusNotFound},
+		{"GET", "/devices/plc/fifo/0", "", http.StatusNotFound},
+		{"PUT", "/devices/plc/input/0", `{"value": 1}`, http.StatusMethodNotAllowed},
+		{"PUT", "/devices/plc/holding/20", `{"value": 1}`, http.StatusForbidden},
+		{"PUT", "/devices/plc/holding/8", `{"values": [1, 2, 3]}`, http.StatusForbidden},
+		{"PUT", "/devices/plc/holding/0", `{"value": "x"}`, http.StatusBadRequest},
+		{"PUT", "/devices/plc/holding/0", `{"value": 70000}`, http.StatusBadRequest},
+		{"PUT", "/devices/plc/coils/0", `{"values": [` + strings.Repeat("true, ", maxWriteBits) + `true]}`, http.StatusBadRequest},
+		{"DELETE", "/devices/plc/holding/0", "", http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		if status, v := bridgeRequest(t, c.method, server.URL+c.path, c.body); status != c.status || v["error"] == nil {
+			t.Errorf("%v %v: expected status %v, actual %v %v", c.method, c.path, c.status, status, v)
+		}
+	}
+	_, v := bridgeRequest(t, "GET", server.URL+"/devices/plc/holding/99?count=2", "")
+	if v["exception_code"] != 2.0 || v["exception"] != "illegal data address" {
+		t.Errorf("exception body: %v", v)
+	}
+	bridge.ReadOnly = true
+	if status, _ := bridgeRequest(t, "PUT", server.URL+"/devices/plc/holding/0", `{"value": 1}`); status != http.StatusForbidden {
+		t.Errorf("read-only: status %v", status)
+	}
+}
+
+func TestHTTPBridgeWrite(t *testing.T) {
+	bridge, model, server := newTestBridge(t)
+	bridge.AllowWrite("plc", TableHoldingRegisters, AddressRange{0, 9})
+	bridge.AllowWrite("plc", TableCoils, AddressRange{4, 15})
+	status, v := bridgeRequest(t, "PUT", server.URL+"/devices/plc/holding/2?type=float32&order=cdab", `{"values": [1.5, -2]}`)
+	if status != 200 || v["values"].([]interface{})[1] != -2.0 {
+		t.Fatalf("float32: %v %v", status, v)
+	}
+	values, _ := model.ReadHoldingRegisters(1, 2, 4)
+	if values[0] != 0 || values[1] != 0x3FC0 || values[3] != 0xC000 {
+		t.Fatalf("registers %04x", values)
+	}
+	if status, v = bridgeRequest(t, "PUT", server.URL+"/devices/plc/holding/9", `{"value": 7}`); status != 200 {
+		t.Fatalf("single register: %v %v", status, v)
+	}
+	if status, v = bridgeRequest(t, "PUT", server.URL+"/devices/plc/coils/4", `{"values": [true, true]}`); status != 200 {
+		t.Fatalf("coils: %v %v", status, v)
+	}
+	if coils, _ := model.ReadCoils(1, 4, 2); !coils[0] || !coils[1] {
+		t.Fatalf("coils %v", coils)
+	}
+}
+
+// writeOnlyModel fails the reads of holding registers.
+type writeOnlyModel struct {
+	*DataModel
+}
+
+func (m writeOnlyModel) ReadHoldingRegisters(slaveid byte, address, quantity uint16) ([]uint16, error) {
+	return nil, errIllegalDataAddress
+}
+
+func TestHTTPBridgeWriteReadbackFailure(t *testing.T) {
+	model := NewDataModel(0, 0, 10, 0)
+	bridge := NewHTTPBridge()
+	bridge.AddDevice("plc", NewClient(NewLoopbackHandler(FramingRTU, writeOnlyModel{model})), 1)
+	bridge.AllowWrite("plc", TableHoldingRegisters, AddressRange{0, 9})
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	status, v := bridgeRequest(t, "PUT", server.URL+"/devices/plc/holding/3", `{"value": 7}`)
+	if status != 200 || v["values"] != nil || v["readback_error"] == nil {
+		t.Fatalf("unexpected response %v %v", status, v)
+	}
+	if values, _ := model.ReadHoldingRegisters(1, 3, 1); values[0] != 7 {
+		t.Fatalf("register %v", values[0])
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	cases := map[byte]int{
+		ExceptionCodeIllegalFunction:                    501,
+		ExceptionCodeIllegalDataAddress:                 404,
+		ExceptionCodeIllegalDataValue:                   400,
+		ExceptionCodeServerDeviceFailure:                502,
+		ExceptionCodeServerDeviceBusy:                   503,
+		ExceptionCodeGatewayPathUnavailable:             502,
+		ExceptionCodeGatewayTargetDeviceFailedToRespond: 504,
+	}
+	for code, status := range cases {
+		if actual := HTTPStatus(code); actual != status {
+			t.Errorf("exception %v: expected %v, actual %v", code, status, actual)
+		}
+	}
+}
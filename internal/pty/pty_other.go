@@ -0,0 +1,19 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !linux
+
+// Package pty opens pseudo terminals to serve modbus serial framings
+// without a serial port.
+package pty
+
+import (
+	"errors"
+	"os"
+)
+
+// Open returns an error, pseudo terminals are only supported on linux.
+func Open() (*os.File, string, error) {
+	return nil, "", errors.New("pseudo terminals are not supported on this platform")
+}
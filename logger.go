@@ -0,0 +1,75 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Transport names used in structured logs.
+const (
+	transportTCP    = "tcp"
+	transportRTU    = "rtu"
+	transportASCII  = "ascii"
+	transportSerial = "serial"
+)
+
+// slogFrame logs an application data unit at debug level with the fields
+// decoded from its header. A positive duration is the round trip time.
+func slogFrame(logger *slog.Logger, msg, transport, address string, adu []byte, duration time.Duration) {
+	if logger == nil || !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, 8)
+	attrs = append(attrs, slog.String("transport", transport), slog.String("address", address))
+	attrs = append(attrs, frameAttrs(transport, adu)...)
+	if transport == transportASCII {
+		attrs = append(attrs, slog.String("bytes", fmt.Sprintf("%q", adu)))
+	} else {
+		attrs = append(attrs, slog.String("bytes", fmt.Sprintf("% x", adu)))
+	}
+	if duration > 0 {
+		attrs = append(attrs, slog.Duration("duration", duration))
+	}
+	logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs...)
+}
+
+// slogEvent logs a connection lifecycle event at info level.
+func slogEvent(logger *slog.Logger, msg, transport, address string, args ...any) {
+	if logger == nil {
+		return
+	}
+	logger.Info(msg, append([]any{"transport", transport, "address", address}, args...)...)
+}
+
+// frameAttrs extracts transaction id, slave id and function code of an adu.
+func frameAttrs(transport string, adu []byte) (attrs []slog.Attr) {
+	switch transport {
+	case transportTCP:
+		if len(adu) > tcpHeaderSize {
+			attrs = append(attrs,
+				slog.Int("transaction", int(binary.BigEndian.Uint16(adu))),
+				slog.Int("slave", int(adu[tcpHeaderSize-1])),
+				slog.Int("function", int(adu[tcpHeaderSize])))
+		}
+	case transportRTU:
+		if len(adu) >= 2 {
+			attrs = append(attrs, slog.Int("slave", int(adu[0])), slog.Int("function", int(adu[1])))
+		}
+	case transportASCII:
+		if len(adu) >= 5 {
+			slave, err1 := readHex(adu[1:])
+			function, err2 := readHex(adu[3:])
+			if err1 == nil && err2 == nil {
+				attrs = append(attrs, slog.Int("slave", int(slave)), slog.Int("function", int(function)))
+			}
+		}
+	}
+	return
+}
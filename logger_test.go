@@ -0,0 +1,54 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogFrame(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	slogFrame(logger, "modbus: sending", transportTCP, "localhost:502", []byte{0, 7, 0, 0, 0, 6, 17, 3, 0, 107, 0, 3}, 0)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"level":       "DEBUG",
+		"transport":   "tcp",
+		"address":     "localhost:502",
+		"transaction": 7.0,
+		"slave":       17.0,
+		"function":    3.0,
+		"bytes":       "00 07 00 00 00 06 11 03 00 6b 00 03",
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("%v: expected %v, actual %v", k, v, entry[k])
+		}
+	}
+
+	buf.Reset()
+	slogFrame(logger, "modbus: received", transportASCII, "/dev/ttyS0", []byte(":1103006B00037E\r\n"), 0)
+	entry = nil
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["slave"] != 17.0 || entry["function"] != 3.0 {
+		t.Fatalf("unexpected ascii entry: %v", entry)
+	}
+}
+
+func TestSlogFrameDisabled(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	slogFrame(logger, "modbus: sending", transportRTU, "COM1", []byte{1, 3, 0, 0, 0, 1, 0x84, 0x0A}, 0)
+	if buf.Len() != 0 {
+		t.Fatalf("frame must not be logged at info level: %s", buf.String())
+	}
+	slogFrame(nil, "modbus: sending", transportRTU, "COM1", []byte{1, 3}, 0)
+}
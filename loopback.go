@@ -0,0 +1,60 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"net"
+)
+
+const loopbackAddress = "loopback"
+
+// NewLoopbackHandler returns a client handler which sends requests to a
+// server handler in the same process, without sockets or serial ports.
+// TCP requests go through net.Pipe to a TcpServer connection loop, RTU and
+// ASCII requests are decoded and encoded by the server side packager.
+// The returned handler can be used with NewClient.
+func NewLoopbackHandler(framing Framing, handler mbHandler) ClientHandler {
+	return newLoopbackHandler(framing, mbServerHandler(handler, ""))
+}
+
+func newLoopbackHandler(framing Framing, handler serverHandler) ClientHandler {
+	if framing == FramingTCP {
+		server := &TcpServer{}
+		h := NewTCPClientHandler(loopbackAddress)
+		h.dial = func() (net.Conn, error) {
+			client, conn := net.Pipe()
+			go server.serveConn(conn, handler)
+			return client, nil
+		}
+		return h
+	}
+	return &loopbackClientHandler{
+		Packager: NewPackager(framing),
+		server:   NewPackager(framing),
+		handler:  handler,
+	}
+}
+
+// loopbackClientHandler implements ClientHandler for serial framings.
+type loopbackClientHandler struct {
+	Packager
+	server  Packager
+	handler serverHandler
+}
+
+// Send decodes the request and encodes the response of the server handler.
+func (mb *loopbackClientHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	request, err := mb.server.Decode(aduRequest)
+	if err != nil {
+		return
+	}
+	response := mb.handler(request)
+	if response == nil {
+		err = fmt.Errorf("modbus: no response from slave '%v'", request.SlaveID)
+		return
+	}
+	return mb.server.Encode(response)
+}
@@ -0,0 +1,240 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+)
+
+// Optional methods of a mbHandler. Function codes of a method which is not
+// implemented are answered with an illegal function exception, except
+// function 16, 22 and 23 which fall back to ReadHoldingRegisters and
+// WriteSingleRegister (without atomicity).
+type (
+	mbCoilsReader interface {
+		ReadCoils(slaveid byte, address, quantity uint16) ([]bool, error)
+	}
+	mbDiscreteInputsReader interface {
+		ReadDiscreteInputs(slaveid byte, address, quantity uint16) ([]bool, error)
+	}
+	mbInputRegistersReader interface {
+		ReadInputRegisters(slaveid byte, address, quantity uint16) ([]uint16, error)
+	}
+	mbSingleCoilWriter interface {
+		WriteSingleCoil(slaveid byte, address uint16, value bool) error
+	}
+	mbMultipleCoilsWriter interface {
+		WriteMultipleCoils(slaveid byte, address uint16, values []bool) error
+	}
+	mbMultipleRegistersWriter interface {
+		WriteMultipleRegisters(slaveid byte, address uint16, values []uint16) error
+	}
+	mbMaskRegisterWriter interface {
+		MaskWriteRegister(slaveid byte, address, andMask, orMask uint16) error
+	}
+	mbRegistersReadWriter interface {
+		ReadWriteMultipleRegisters(slaveid byte, readAddress, readQuantity, writeAddress uint16, values []uint16) ([]uint16, error)
+	}
+	mbFIFOQueueReader interface {
+		ReadFIFOQueue(slaveid byte, address uint16) ([]uint16, error)
+	}
+)
+
+var (
+	errIllegalFunction  = &ModbusError{ExceptionCode: ExceptionCodeIllegalFunction}
+	errIllegalDataValue = &ModbusError{ExceptionCode: ExceptionCodeIllegalDataValue}
+)
+
+// mbServerHandler adapts a mbHandler to a serverHandler of the requests of
+// remote. A *ModbusError returned by the handler is answered with its
+// exception code, any other error with an illegal data address exception.
+// The writes to a DataModel are notified with remote.
+func mbServerHandler(handler mbHandler, remote string) serverHandler {
+	if m, ok := handler.(*DataModel); ok {
+		handler = &dataModelClient{m, remote}
+	}
+	return func(pdu *PDUwithSlaveid) *PDUwithSlaveid {
+		data, err := serveMbHandler(handler, pdu)
+		if err != nil {
+			return encodeMbError(pdu.SlaveID, pdu.FunctionCode, exceptionCode(err))
+		}
+		return &PDUwithSlaveid{pdu.SlaveID,
+			ProtocolDataUnit{
+				FunctionCode: pdu.FunctionCode,
+				Data:         data,
+			}}
+	}
+}
+
+// exceptionCode returns the exception code answering an error.
+func exceptionCode(err error) byte {
+	if mbError, ok := err.(*ModbusError); ok {
+		return mbError.ExceptionCode
+	}
+	return ExceptionCodeIllegalDataAddress
+}
+
+// serveMbHandler calls the handler method of the function code and returns
+// the response data.
+func serveMbHandler(handler mbHandler, pdu *PDUwithSlaveid) (data []byte, err error) {
+	request := pdu.Data
+	if !knownFunction(pdu.FunctionCode) {
+		err = errIllegalFunction
+		return
+	}
+	if err = ValidateRequest(&pdu.ProtocolDataUnit); err != nil {
+		return
+	}
+	address := binary.BigEndian.Uint16(request)
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils:
+		h, ok := handler.(mbCoilsReader)
+		if !ok {
+			err = errIllegalFunction
+			return
+		}
+		var values []bool
+		if values, err = h.ReadCoils(pdu.SlaveID, address, binary.BigEndian.Uint16(request[2:])); err == nil {
+			data = bitsResponse(values)
+		}
+	case FuncCodeReadDiscreteInputs:
+		h, ok := handler.(mbDiscreteInputsReader)
+		if !ok {
+			err = errIllegalFunction
+			return
+		}
+		var values []bool
+		if values, err = h.ReadDiscreteInputs(pdu.SlaveID, address, binary.BigEndian.Uint16(request[2:])); err == nil {
+			data = bitsResponse(values)
+		}
+	case FuncCodeReadHoldingRegisters:
+		var values []uint16
+		if values, err = handler.ReadHoldingRegisters(pdu.SlaveID, address, binary.BigEndian.Uint16(request[2:])); err == nil {
+			data = registersResponse(values)
+		}
+	case FuncCodeReadInputRegisters:
+		h, ok := handler.(mbInputRegistersReader)
+		if !ok {
+			err = errIllegalFunction
+			return
+		}
+		var values []uint16
+		if values, err = h.ReadInputRegisters(pdu.SlaveID, address, binary.BigEndian.Uint16(request[2:])); err == nil {
+			data = registersResponse(values)
+		}
+	case FuncCodeWriteSingleCoil:
+		if h, ok := handler.(mbSingleCoilWriter); ok {
+			err = h.WriteSingleCoil(pdu.SlaveID, address, request[2] == 0xFF)
+		} else {
+			err = errIllegalFunction
+		}
+		data = request[:4]
+	case FuncCodeWriteSingleRegister:
+		err = handler.WriteSingleRegister(pdu.SlaveID, address, binary.BigEndian.Uint16(request[2:]))
+		data = request[:4]
+	case FuncCodeWriteMultipleCoils:
+		quantity := binary.BigEndian.Uint16(request[2:])
+		if h, ok := handler.(mbMultipleCoilsWriter); ok {
+			err = h.WriteMultipleCoils(pdu.SlaveID, address, unpackBits(request[5:], int(quantity)))
+		} else {
+			err = errIllegalFunction
+		}
+		data = request[:4]
+	case FuncCodeWriteMultipleRegisters:
+		quantity := binary.BigEndian.Uint16(request[2:])
+		err = writeMultipleRegisters(handler, pdu.SlaveID, address, registerValues(request[5:], int(quantity)))
+		data = request[:4]
+	case FuncCodeMaskWriteRegister:
+		andMask := binary.BigEndian.Uint16(request[2:])
+		orMask := binary.BigEndian.Uint16(request[4:])
+		if h, ok := handler.(mbMaskRegisterWriter); ok {
+			err = h.MaskWriteRegister(pdu.SlaveID, address, andMask, orMask)
+		} else {
+			var current []uint16
+			if current, err = handler.ReadHoldingRegisters(pdu.SlaveID, address, 1); err == nil && len(current) == 1 {
+				err = handler.WriteSingleRegister(pdu.SlaveID, address, (current[0]&andMask)|(orMask&^andMask))
+			}
+		}
+		data = request[:6]
+	case FuncCodeReadWriteMultipleRegisters:
+		quantity := binary.BigEndian.Uint16(request[2:])
+		writeAddress := binary.BigEndian.Uint16(request[4:])
+		writeQuantity := binary.BigEndian.Uint16(request[6:])
+		writeValues := registerValues(request[9:], int(writeQuantity))
+		var values []uint16
+		if h, ok := handler.(mbRegistersReadWriter); ok {
+			values, err = h.ReadWriteMultipleRegisters(pdu.SlaveID, address, quantity, writeAddress, writeValues)
+		} else if err = writeMultipleRegisters(handler, pdu.SlaveID, writeAddress, writeValues); err == nil {
+			values, err = handler.ReadHoldingRegisters(pdu.SlaveID, address, quantity)
+		}
+		if err == nil {
+			data = registersResponse(values)
+		}
+	case FuncCodeReadFIFOQueue:
+		h, ok := handler.(mbFIFOQueueReader)
+		if !ok {
+			err = errIllegalFunction
+			return
+		}
+		var values []uint16
+		if values, err = h.ReadFIFOQueue(pdu.SlaveID, address); err != nil {
+			return
+		}
+		if len(values) > 31 {
+			err = errIllegalDataValue
+			return
+		}
+		data = dataBlock(append([]uint16{uint16(2 + 2*len(values)), uint16(len(values))}, values...)...)
+	}
+	return
+}
+
+// knownFunction returns true if the function code is defined in this package.
+func knownFunction(functionCode byte) bool {
+	switch functionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters,
+		FuncCodeMaskWriteRegister, FuncCodeReadWriteMultipleRegisters,
+		FuncCodeReadFIFOQueue:
+		return true
+	}
+	return false
+}
+
+// writeMultipleRegisters writes values with WriteMultipleRegisters if the
+// handler implements it, otherwise register by register.
+func writeMultipleRegisters(handler mbHandler, slaveid byte, address uint16, values []uint16) (err error) {
+	if h, ok := handler.(mbMultipleRegistersWriter); ok {
+		return h.WriteMultipleRegisters(slaveid, address, values)
+	}
+	for i, v := range values {
+		if err = handler.WriteSingleRegister(slaveid, address+uint16(i), v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// bitsResponse returns byte count and packed bit states.
+func bitsResponse(values []bool) []byte {
+	bits := packBits(values)
+	return append([]byte{byte(len(bits))}, bits...)
+}
+
+// registersResponse returns byte count and register values.
+func registersResponse(values []uint16) []byte {
+	return append([]byte{byte(len(values) * 2)}, dataBlock(values...)...)
+}
+
+// registerValues decodes quantity big endian registers.
+func registerValues(data []byte, quantity int) []uint16 {
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	return values
+}
@@ -28,6 +28,39 @@ const (
 	FuncCodeReadFIFOQueue              = 24
 )
 
+// FunctionName returns the name of a function code, exception responses
+// are named after their function.
+func FunctionName(functionCode byte) string {
+	var name string
+	switch functionCode &^ 0x80 {
+	case FuncCodeReadDiscreteInputs:
+		name = "read discrete inputs"
+	case FuncCodeReadCoils:
+		name = "read coils"
+	case FuncCodeWriteSingleCoil:
+		name = "write single coil"
+	case FuncCodeWriteMultipleCoils:
+		name = "write multiple coils"
+	case FuncCodeReadInputRegisters:
+		name = "read input registers"
+	case FuncCodeReadHoldingRegisters:
+		name = "read holding registers"
+	case FuncCodeWriteSingleRegister:
+		name = "write single register"
+	case FuncCodeWriteMultipleRegisters:
+		name = "write multiple registers"
+	case FuncCodeReadWriteMultipleRegisters:
+		name = "read/write multiple registers"
+	case FuncCodeMaskWriteRegister:
+		name = "mask write register"
+	case FuncCodeReadFIFOQueue:
+		name = "read fifo queue"
+	default:
+		name = fmt.Sprintf("function %v", functionCode&^0x80)
+	}
+	return name
+}
+
 const (
 	ExceptionCodeIllegalFunction                    = 1
 	ExceptionCodeIllegalDataAddress                 = 2
@@ -49,8 +82,31 @@ type ModbusError struct {
 
 // Error converts known modbus exception code to error message.
 func (e *ModbusError) Error() string {
+	return fmt.Sprintf("modbus: exception '%v' (%s), function '%v'", e.ExceptionCode, ExceptionName(e.ExceptionCode), e.FunctionCode)
+}
+
+// ChecksumError is returned when the CRC of a RTU response or the LRC of an
+// ASCII response is invalid.
+type ChecksumError struct {
+	Framing  Framing
+	Checksum uint16
+	Expected uint16
+}
+
+// Error returns the received and expected checksums.
+func (e *ChecksumError) Error() string {
+	name := "crc"
+	if e.Framing == FramingASCII {
+		name = "lrc"
+	}
+	return fmt.Sprintf("modbus: response %v '%v' does not match expected '%v'", name, e.Checksum, e.Expected)
+}
+
+// ExceptionName returns the name of an exception code, "unknown" if the
+// code is not known.
+func ExceptionName(exceptionCode byte) string {
 	var name string
-	switch e.ExceptionCode {
+	switch exceptionCode {
 	case ExceptionCodeIllegalFunction:
 		name = "illegal function"
 	case ExceptionCodeIllegalDataAddress:
@@ -72,7 +128,7 @@ func (e *ModbusError) Error() string {
 	default:
 		name = "unknown"
 	}
-	return fmt.Sprintf("modbus: exception '%v' (%s), function '%v'", e.ExceptionCode, name, e.FunctionCode)
+	return name
 }
 
 // ProtocolDataUnit (PDU) is independent of underlying communication layers.
@@ -94,6 +150,74 @@ type Packager interface {
 	Verify(aduRequest []byte, aduResponse []byte) (err error)
 }
 
+// Table identifies one of the four data tables of a device.
+type Table int
+
+const (
+	TableCoils Table = iota
+	TableDiscreteInputs
+	TableHoldingRegisters
+	TableInputRegisters
+)
+
+var tableNames = [...]string{
+	TableCoils:            "coils",
+	TableDiscreteInputs:   "discrete_inputs",
+	TableHoldingRegisters: "holding_registers",
+	TableInputRegisters:   "input_registers",
+}
+
+// ParseTable returns the table of the given name, e.g. "holding_registers".
+func ParseTable(name string) (Table, error) {
+	for t, n := range tableNames {
+		if n == name {
+			return Table(t), nil
+		}
+	}
+	return 0, fmt.Errorf("modbus: unknown table '%v'", name)
+}
+
+// String returns the lower case name of the table.
+func (t Table) String() string {
+	if t >= 0 && int(t) < len(tableNames) {
+		return tableNames[t]
+	}
+	return fmt.Sprintf("table(%d)", int(t))
+}
+
+// Framing identifies how a PDU is wrapped in an application data unit.
+type Framing int
+
+const (
+	FramingTCP Framing = iota
+	FramingRTU
+	FramingASCII
+)
+
+// String returns the lower case name of the framing.
+func (f Framing) String() string {
+	switch f {
+	case FramingTCP:
+		return transportTCP
+	case FramingRTU:
+		return transportRTU
+	case FramingASCII:
+		return transportASCII
+	}
+	return fmt.Sprintf("framing(%d)", int(f))
+}
+
+// NewPackager returns a new packager of the given framing.
+func NewPackager(framing Framing) Packager {
+	switch framing {
+	case FramingRTU:
+		return &rtuPackager{}
+	case FramingASCII:
+		return &asciiPackager{}
+	}
+	return &tcpPackager{}
+}
+
 // Transporter specifies the transport layer.
 type Transporter interface {
 	Send(aduRequest []byte) (aduResponse []byte, err error)
@@ -0,0 +1,276 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+/*
+Package modbustest provides utilities for testing code using modbus.Client.
+
+A MockHandler is a modbus.ClientHandler which answers requests from
+expectations scripted at the PDU level:
+
+	mock := modbustest.NewMockHandler(t, modbus.FramingRTU)
+	mock.Expect(1, modbus.FuncCodeReadHoldingRegisters, 100, 2).ReturnRegisters(7, 8)
+	mock.Expect(1, modbus.FuncCodeReadHoldingRegisters, 200, 1).ReturnException(modbus.ExceptionCodeIllegalDataAddress)
+	mock.Expect(1, modbus.FuncCodeWriteSingleRegister, 100, 1).Timeout()
+	client := modbus.NewClient(mock)
+*/
+package modbustest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mythay/modbus"
+)
+
+// timeoutError is returned by Send for expectations set with Timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "modbustest: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// ErrTimeout is the error returned for a request expected to time out.
+// It implements net.Error.
+var ErrTimeout error = timeoutError{}
+
+type responseKind int
+
+const (
+	responseDefault responseKind = iota
+	responseData
+	responseException
+	responseTimeout
+)
+
+// Expectation is a request expected by a MockHandler and its scripted response.
+type Expectation struct {
+	slaveID      byte
+	functionCode byte
+	address      uint16
+	quantity     uint16
+	data         []byte
+
+	kind      responseKind
+	response  []byte
+	exception byte
+	garble    bool
+}
+
+// WithData requires the request PDU data (after function code) to be data.
+func (e *Expectation) WithData(data []byte) *Expectation {
+	e.data = data
+	return e
+}
+
+// ReturnData answers with the given PDU data (after function code).
+func (e *Expectation) ReturnData(data []byte) *Expectation {
+	e.kind = responseData
+	e.response = data
+	return e
+}
+
+// ReturnRegisters answers a register read with the given values.
+func (e *Expectation) ReturnRegisters(values ...uint16) *Expectation {
+	data := make([]byte, 1+2*len(values))
+	data[0] = byte(2 * len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(data[1+2*i:], v)
+	}
+	return e.ReturnData(data)
+}
+
+// ReturnBits answers a coil or discrete input read with the given states.
+func (e *Expectation) ReturnBits(values ...bool) *Expectation {
+	data := make([]byte, 1+(len(values)+7)/8)
+	data[0] = byte(len(data) - 1)
+	for i, v := range values {
+		if v {
+			data[1+i/8] |= 1 << uint(i%8)
+		}
+	}
+	return e.ReturnData(data)
+}
+
+// ReturnException answers with an exception response.
+func (e *Expectation) ReturnException(code byte) *Expectation {
+	e.kind = responseException
+	e.exception = code
+	return e
+}
+
+// Timeout makes Send fail with ErrTimeout.
+func (e *Expectation) Timeout() *Expectation {
+	e.kind = responseTimeout
+	return e
+}
+
+// Garble corrupts the last byte of the response: the CRC or LRC for
+// serial framing, the last data byte for TCP.
+func (e *Expectation) Garble() *Expectation {
+	e.garble = true
+	return e
+}
+
+func (e *Expectation) String() string {
+	return fmt.Sprintf("function %v slave %v address %v quantity %v", e.functionCode, e.slaveID, e.address, e.quantity)
+}
+
+// MockHandler implements modbus.ClientHandler, it checks requests against
+// the expectations in order and reports unmet expectations when the test
+// finishes. It is safe for concurrent use.
+type MockHandler struct {
+	modbus.Packager
+
+	t            testing.TB
+	framing      modbus.Framing
+	mu           sync.Mutex
+	expectations []*Expectation
+	next         int
+}
+
+// NewMockHandler creates a handler using the packager of framing.
+func NewMockHandler(t testing.TB, framing modbus.Framing) *MockHandler {
+	m := &MockHandler{
+		Packager: modbus.NewPackager(framing),
+		t:        t,
+		framing:  framing,
+	}
+	t.Cleanup(m.verify)
+	return m
+}
+
+// Expect appends an expectation of a request. Quantity is 1 for single
+// writes and the read quantity for function 23.
+func (m *MockHandler) Expect(slaveID, functionCode byte, address, quantity uint16) *Expectation {
+	e := &Expectation{
+		slaveID:      slaveID,
+		functionCode: functionCode,
+		address:      address,
+		quantity:     quantity,
+	}
+	m.mu.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mu.Unlock()
+	return e
+}
+
+// Unmet returns the expectations which have not been requested yet.
+func (m *MockHandler) Unmet() []*Expectation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Expectation(nil), m.expectations[m.next:]...)
+}
+
+func (m *MockHandler) verify() {
+	if unmet := m.Unmet(); len(unmet) > 0 {
+		names := make([]string, len(unmet))
+		for i, e := range unmet {
+			names[i] = e.String()
+		}
+		m.t.Errorf("modbustest: %v unmet expectations:\n\t%v", len(unmet), strings.Join(names, "\n\t"))
+	}
+}
+
+// Send decodes the request, checks it against the next expectation and
+// returns the encoded response.
+func (m *MockHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	request, err := m.Decode(aduRequest)
+	if err != nil {
+		m.t.Errorf("modbustest: invalid request % x: %v", aduRequest, err)
+		return
+	}
+	m.mu.Lock()
+	if m.next >= len(m.expectations) {
+		m.mu.Unlock()
+		err = fmt.Errorf("modbustest: unexpected request: function %v slave %v data % x", request.FunctionCode, request.SlaveID, request.Data)
+		m.t.Error(err)
+		return
+	}
+	e := m.expectations[m.next]
+	m.next++
+	m.mu.Unlock()
+
+	if err = e.match(request); err != nil {
+		m.t.Error(err)
+		return
+	}
+	if e.kind == responseTimeout {
+		err = ErrTimeout
+		return
+	}
+	response := &modbus.PDUwithSlaveid{SlaveID: request.SlaveID}
+	response.FunctionCode = request.FunctionCode
+	switch e.kind {
+	case responseData:
+		response.Data = e.response
+	case responseException:
+		response.FunctionCode |= 0x80
+		response.Data = []byte{e.exception}
+	default:
+		response.Data = defaultResponse(request, e)
+	}
+	if aduResponse, err = m.Encode(response); err != nil {
+		return
+	}
+	// Transaction identifier is generated by the shared packager
+	if m.framing == modbus.FramingTCP {
+		copy(aduResponse, aduRequest[:2])
+	}
+	if e.garble {
+		aduResponse[len(aduResponse)-1] ^= 0x5A
+	}
+	return
+}
+
+func (e *Expectation) match(request *modbus.PDUwithSlaveid) error {
+	address, quantity := requestAddressQuantity(request)
+	if request.SlaveID != e.slaveID || request.FunctionCode != e.functionCode ||
+		address != e.address || quantity != e.quantity {
+		return fmt.Errorf("modbustest: unexpected request: function %v slave %v address %v quantity %v, expected %v",
+			request.FunctionCode, request.SlaveID, address, quantity, e)
+	}
+	if e.data != nil && string(e.data) != string(request.Data) {
+		return fmt.Errorf("modbustest: unexpected request data % x, expected % x (%v)", request.Data, e.data, e)
+	}
+	return nil
+}
+
+// requestAddressQuantity extracts the address and quantity of a request.
+func requestAddressQuantity(request *modbus.PDUwithSlaveid) (address, quantity uint16) {
+	data := request.Data
+	if len(data) >= 2 {
+		address = binary.BigEndian.Uint16(data)
+	}
+	switch request.FunctionCode {
+	case modbus.FuncCodeWriteSingleCoil, modbus.FuncCodeWriteSingleRegister, modbus.FuncCodeMaskWriteRegister:
+		quantity = 1
+	case modbus.FuncCodeReadFIFOQueue:
+	default:
+		if len(data) >= 4 {
+			quantity = binary.BigEndian.Uint16(data[2:])
+		}
+	}
+	return
+}
+
+// defaultResponse returns zero values for reads and echoes writes.
+func defaultResponse(request *modbus.PDUwithSlaveid, e *Expectation) []byte {
+	switch request.FunctionCode {
+	case modbus.FuncCodeReadCoils, modbus.FuncCodeReadDiscreteInputs:
+		n := (int(e.quantity) + 7) / 8
+		return append([]byte{byte(n)}, make([]byte, n)...)
+	case modbus.FuncCodeReadHoldingRegisters, modbus.FuncCodeReadInputRegisters,
+		modbus.FuncCodeReadWriteMultipleRegisters:
+		n := 2 * int(e.quantity)
+		return append([]byte{byte(n)}, make([]byte, n)...)
+	case modbus.FuncCodeWriteMultipleCoils, modbus.FuncCodeWriteMultipleRegisters:
+		return request.Data[:4]
+	case modbus.FuncCodeReadFIFOQueue:
+		return []byte{0, 2, 0, 0}
+	}
+	return request.Data
+}
@@ -0,0 +1,97 @@
+package modbustest
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/mythay/modbus"
+)
+
+func TestMockHandler(t *testing.T) {
+	for _, framing := range []modbus.Framing{modbus.FramingTCP, modbus.FramingRTU, modbus.FramingASCII} {
+		t.Run(framing.String(), func(t *testing.T) {
+			mock := NewMockHandler(t, framing)
+			mock.Expect(1, modbus.FuncCodeReadHoldingRegisters, 100, 2).ReturnRegisters(7, 8)
+			mock.Expect(1, modbus.FuncCodeReadCoils, 0, 10).ReturnBits(true, false, true)
+			mock.Expect(2, modbus.FuncCodeWriteSingleRegister, 5, 1).WithData([]byte{0, 5, 0, 9})
+			mock.Expect(1, modbus.FuncCodeReadHoldingRegisters, 200, 1).ReturnException(modbus.ExceptionCodeIllegalDataAddress)
+			mock.Expect(1, modbus.FuncCodeReadInputRegisters, 0, 1).Timeout()
+			client := modbus.NewClient(mock)
+
+			results, err := client.ReadHoldingRegisters(1, 100, 2)
+			if err != nil || !bytes.Equal([]byte{0, 7, 0, 8}, results) {
+				t.Fatalf("unexpected results: %v, %v", results, err)
+			}
+			results, err = client.ReadCoils(1, 0, 10)
+			if err != nil || !bytes.Equal([]byte{5}, results) {
+				t.Fatalf("unexpected results: %v, %v", results, err)
+			}
+			if _, err = client.WriteSingleRegister(2, 5, 9); err != nil {
+				t.Fatal(err)
+			}
+			_, err = client.ReadHoldingRegisters(1, 200, 1)
+			if mbErr, ok := err.(*modbus.ModbusError); !ok || mbErr.ExceptionCode != modbus.ExceptionCodeIllegalDataAddress {
+				t.Fatalf("expected exception, actual %v", err)
+			}
+			_, err = client.ReadInputRegisters(1, 0, 1)
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				t.Fatalf("expected timeout, actual %v", err)
+			}
+		})
+	}
+}
+
+func TestMockHandlerGarble(t *testing.T) {
+	mock := NewMockHandler(t, modbus.FramingRTU)
+	mock.Expect(1, modbus.FuncCodeReadHoldingRegisters, 0, 1).Garble()
+	client := modbus.NewClient(mock)
+	if _, err := client.ReadHoldingRegisters(1, 0, 1); err == nil {
+		t.Fatal("expected crc error")
+	}
+}
+
+// recordingTB captures errors reported by a mock handler.
+type recordingTB struct {
+	testing.TB
+	errors  []string
+	cleanup []func()
+}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, format)
+}
+
+func (r *recordingTB) Error(args ...interface{}) {
+	r.errors = append(r.errors, "error")
+}
+
+func (r *recordingTB) Cleanup(f func()) {
+	r.cleanup = append(r.cleanup, f)
+}
+
+func TestMockHandlerUnmet(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	mock := NewMockHandler(tb, modbus.FramingTCP)
+	mock.Expect(1, modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	mock.Expect(1, modbus.FuncCodeReadHoldingRegisters, 1, 1)
+	client := modbus.NewClient(mock)
+
+	if _, err := client.ReadHoldingRegisters(1, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	// Out of order request
+	if _, err := client.ReadHoldingRegisters(1, 5, 1); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(tb.errors) != 1 {
+		t.Fatalf("expected 1 error, actual %v", tb.errors)
+	}
+	mock.Expect(1, modbus.FuncCodeReadHoldingRegisters, 2, 1)
+	for _, f := range tb.cleanup {
+		f()
+	}
+	if len(tb.errors) != 2 || len(mock.Unmet()) != 1 {
+		t.Fatalf("expected unmet expectation reported, actual %v", tb.errors)
+	}
+}
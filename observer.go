@@ -0,0 +1,120 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Observer is notified when a request starts and ends, on both client and
+// server side. It is the hook for metrics, tracing and latency measurement.
+// Implementations must be safe for concurrent use.
+type Observer interface {
+	// RequestStart is called before the request is sent by a client or
+	// handled by a server. Response, Duration and Err are not set yet.
+	RequestStart(o *Observation)
+	// RequestEnd is called with the same observation when the transaction
+	// is completed or failed.
+	RequestEnd(o *Observation)
+}
+
+// Observation describes one modbus transaction.
+// Request and Response may refer to internal buffers and must not be
+// retained after RequestEnd returns.
+type Observation struct {
+	// Server is true if the transaction is handled by a server.
+	Server bool
+	// RemoteAddr is the address of the peer (server side only).
+	RemoteAddr string
+
+	SlaveID      byte
+	FunctionCode byte
+	// Address and Quantity are extracted from the request when the
+	// function code has them (read address and quantity for function 23).
+	Address  uint16
+	Quantity uint16
+
+	// Application data units as sent and received.
+	Request  []byte
+	Response []byte
+
+	Start    time.Time
+	Duration time.Duration
+	// Retry is the number of previous attempts of the same request.
+	Retry int
+	// Err is the transmission error or the *ModbusError of an exception.
+	Err error
+}
+
+// newObservation creates an observation of the given request and starts its clock.
+func newObservation(pdu *PDUwithSlaveid, aduRequest []byte) *Observation {
+	o := &Observation{
+		SlaveID:      pdu.SlaveID,
+		FunctionCode: pdu.FunctionCode,
+		Request:      aduRequest,
+		Start:        time.Now(),
+	}
+	o.Address, o.Quantity = requestAddressQuantity(&pdu.ProtocolDataUnit)
+	return o
+}
+
+// end stops the clock of the observation.
+func (o *Observation) end(aduResponse []byte, err error) {
+	o.Response = aduResponse
+	o.Duration = time.Since(o.Start)
+	o.Err = err
+}
+
+// observeRequest starts the observation of a request handled by a server,
+// it returns nil if there is no observer.
+func observeRequest(observer Observer, pdu *PDUwithSlaveid, aduRequest []byte, remote string) *Observation {
+	if observer == nil {
+		return nil
+	}
+	o := newObservation(pdu, aduRequest)
+	o.Server = true
+	o.RemoteAddr = remote
+	observer.RequestStart(o)
+	return o
+}
+
+// observeResponse ends the observation of a server request, an exception
+// response is reported as a *ModbusError.
+func observeResponse(observer Observer, o *Observation, request, response *PDUwithSlaveid, aduResponse []byte, err error) {
+	if o == nil {
+		return
+	}
+	if err == nil && response != nil && response.FunctionCode != request.FunctionCode {
+		err = responseError(response)
+	}
+	o.end(aduResponse, err)
+	observer.RequestEnd(o)
+}
+
+// requestAddressQuantity returns the starting address and quantity of a request pdu.
+func requestAddressQuantity(pdu *ProtocolDataUnit) (address, quantity uint16) {
+	if len(pdu.Data) < 2 {
+		return
+	}
+	address = binary.BigEndian.Uint16(pdu.Data)
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils,
+		FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters,
+		FuncCodeReadInputRegisters,
+		FuncCodeWriteMultipleCoils,
+		FuncCodeWriteMultipleRegisters,
+		FuncCodeReadWriteMultipleRegisters:
+		if len(pdu.Data) >= 4 {
+			quantity = binary.BigEndian.Uint16(pdu.Data[2:])
+		}
+	case FuncCodeWriteSingleCoil,
+		FuncCodeWriteSingleRegister,
+		FuncCodeMaskWriteRegister:
+		quantity = 1
+	}
+	return
+}
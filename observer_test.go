@@ -0,0 +1,144 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordObserver struct {
+	mu      sync.Mutex
+	started []Observation
+	ended   []Observation
+}
+
+func (r *recordObserver) RequestStart(o *Observation) {
+	r.mu.Lock()
+	r.started = append(r.started, *o)
+	r.mu.Unlock()
+}
+
+func (r *recordObserver) RequestEnd(o *Observation) {
+	r.mu.Lock()
+	// The application data units must not be retained
+	e := *o
+	e.Request = append([]byte(nil), o.Request...)
+	e.Response = append([]byte(nil), o.Response...)
+	r.ended = append(r.ended, e)
+	r.mu.Unlock()
+}
+
+// funcTransporter answers requests with a function.
+type funcTransporter func(aduRequest []byte) ([]byte, error)
+
+func (f funcTransporter) Send(aduRequest []byte) ([]byte, error) {
+	return f(aduRequest)
+}
+
+func TestClientObserverRetry(t *testing.T) {
+	packager := &rtuPackager{}
+	attempts := 0
+	transporter := funcTransporter(func(aduRequest []byte) ([]byte, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("timeout")
+		}
+		return packager.Encode(&PDUwithSlaveid{1, ProtocolDataUnit{FunctionCode: 3, Data: []byte{4, 0, 1, 0, 2}}})
+	})
+	observer := &recordObserver{}
+	client := NewClient2(packager, transporter, WithObserver(observer), WithRetries(2))
+
+	results, err := client.ReadHoldingRegisters(1, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if len(observer.started) != 2 || len(observer.ended) != 2 {
+		t.Fatalf("expected 2 observations, actual %v started, %v ended", len(observer.started), len(observer.ended))
+	}
+	first, second := observer.ended[0], observer.ended[1]
+	if first.Err == nil || first.Retry != 0 {
+		t.Fatalf("unexpected first attempt: %+v", first)
+	}
+	if second.Err != nil || second.Retry != 1 || second.Response == nil {
+		t.Fatalf("unexpected second attempt: %+v", second)
+	}
+	if second.SlaveID != 1 || second.FunctionCode != 3 || second.Address != 100 || second.Quantity != 2 {
+		t.Fatalf("unexpected request fields: %+v", second)
+	}
+}
+
+func TestClientObserverException(t *testing.T) {
+	packager := &rtuPackager{}
+	attempts := 0
+	transporter := funcTransporter(func(aduRequest []byte) ([]byte, error) {
+		attempts++
+		return packager.Encode(encodeMbError(1, 3, ExceptionCodeIllegalDataAddress))
+	})
+	observer := &recordObserver{}
+	client := NewClient2(packager, transporter, WithObserver(observer), WithRetries(2))
+
+	_, err := client.ReadHoldingRegisters(1, 100, 2)
+	if _, ok := err.(*ModbusError); !ok {
+		t.Fatalf("expected modbus error, actual %v", err)
+	}
+	if attempts != 1 || len(observer.ended) != 1 || observer.ended[0].Err != err {
+		t.Fatalf("exception must not be retried: %v attempts, %+v", attempts, observer.ended)
+	}
+}
+
+func TestTcpServerObserver(t *testing.T) {
+	server, err := NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	observer := &recordObserver{}
+	server.Observer = observer
+	model := NewDataModel(0, 0, 10, 0)
+	model.WriteSingleRegister(1, 2, 0x1234)
+	go server.ServeModbus(model)
+	handler := NewTCPClientHandler(server.Addr().String())
+	handler.Timeout = time.Second
+	defer handler.Close()
+	client := NewClient(handler)
+	if _, err = client.ReadHoldingRegisters(1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.ReadHoldingRegisters(1, 20, 1); err == nil {
+		t.Fatal("exception expected")
+	}
+	// Requests end once the response is written
+	var started int
+	var ended []Observation
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		observer.mu.Lock()
+		started, ended = len(observer.started), append([]Observation(nil), observer.ended...)
+		observer.mu.Unlock()
+		if len(ended) == 2 {
+			break
+		}
+	}
+	if started != 2 || len(ended) != 2 {
+		t.Fatalf("expected 2 observations, actual %+v", ended)
+	}
+	o := ended[0]
+	if !o.Server || o.RemoteAddr == "" || o.SlaveID != 1 || o.FunctionCode != FuncCodeReadHoldingRegisters ||
+		o.Address != 2 || o.Quantity != 1 || o.Err != nil || o.Start.IsZero() || o.Duration < 0 {
+		t.Fatalf("unexpected observation %+v", o)
+	}
+	if !bytes.HasSuffix(o.Request, []byte{1, 3, 0, 2, 0, 1}) || !bytes.HasSuffix(o.Response, []byte{1, 3, 2, 0x12, 0x34}) {
+		t.Fatalf("unexpected request % x, response % x", o.Request, o.Response)
+	}
+	o = ended[1]
+	if mbError, ok := o.Err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress || o.Address != 20 {
+		t.Fatalf("unexpected exception observation %+v", o)
+	}
+	if !bytes.HasSuffix(o.Response, []byte{1, 0x83, 2}) {
+		t.Fatalf("unexpected exception response % x", o.Response)
+	}
+}
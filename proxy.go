@@ -0,0 +1,284 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"log"
+	"sync"
+	"time"
+)
+
+// Proxy forwards the requests of many clients of a TcpServer to a device
+// through a single upstream client handler, usually a TCPClientHandler,
+// so that devices accepting few connections can be shared. Transaction ids
+// are those of the upstream handler and restored for each client.
+//
+// Read responses are cached for TTL and identical concurrent reads are
+// sent once. Writes are always forwarded and invalidate the cached reads
+// of the table ranges they overlap, before and after they are sent; the
+// overlapping reads received meanwhile are not cached. Requests without
+// valid response are answered with a gateway exception.
+type Proxy struct {
+	// TTL of cached read responses, reads are not cached if zero
+	TTL time.Duration
+	// Timeout of upstream responses, that of the handler if zero
+	Timeout time.Duration
+	// Logger logs upstream failures if set
+	Logger *log.Logger
+
+	upstream *GatewayBus
+
+	mu       sync.Mutex
+	cache    map[proxyKey]*proxyEntry
+	inflight map[proxyKey]*proxyCall
+	// swept is when the expired entries were last removed
+	swept time.Time
+	// writes being sent
+	writes map[*proxyRange]bool
+}
+
+// proxyKey identifies identical read requests.
+type proxyKey struct {
+	slaveID      byte
+	functionCode byte
+	data         string
+}
+
+// proxyRange is the table range read or written by a request.
+type proxyRange struct {
+	slaveID  byte
+	table    Table
+	address  int
+	quantity int
+}
+
+func (r *proxyRange) overlaps(o *proxyRange) bool {
+	return r.slaveID == o.slaveID && r.table == o.table &&
+		r.address < o.address+o.quantity && o.address < r.address+r.quantity
+}
+
+type proxyEntry struct {
+	proxyRange
+	response *PDUwithSlaveid
+	expires  time.Time
+}
+
+// proxyCall is a read sent upstream which identical reads wait for.
+type proxyCall struct {
+	proxyRange
+	done     chan struct{}
+	response *PDUwithSlaveid
+	err      error
+	// stale is set if a write overlapped the read, its response is not
+	// cached nor shared with the reads received after the write
+	stale bool
+}
+
+// NewProxy allocates a proxy sending requests with handler.
+func NewProxy(handler ClientHandler) *Proxy {
+	return &Proxy{
+		upstream: NewGatewayBus(handler),
+		cache:    make(map[proxyKey]*proxyEntry),
+		inflight: make(map[proxyKey]*proxyCall),
+		writes:   make(map[*proxyRange]bool),
+	}
+}
+
+// ServeTCP serves the clients of server until its listener is closed.
+func (p *Proxy) ServeTCP(server *TcpServer) {
+	server.serve(p.handle)
+}
+
+// handle answers a request from the cache or forwards it.
+func (p *Proxy) handle(pdu *PDUwithSlaveid) *PDUwithSlaveid {
+	if r, ok := readRange(pdu); ok {
+		return p.read(pdu, r)
+	}
+	if r, ok := writeRange(pdu); ok {
+		p.startWrite(&r)
+		defer p.endWrite(&r)
+	}
+	response, err := p.forward(pdu)
+	if err != nil {
+		return encodeMbError(pdu.SlaveID, pdu.FunctionCode, gatewayExceptionCode(err))
+	}
+	return response
+}
+
+// read returns the cached response of a read, or the response of an
+// identical read being sent, or sends the read.
+func (p *Proxy) read(pdu *PDUwithSlaveid, r proxyRange) *PDUwithSlaveid {
+	key := proxyKey{pdu.SlaveID, pdu.FunctionCode, string(pdu.Data)}
+	p.mu.Lock()
+	if entry, ok := p.cache[key]; ok {
+		if time.Now().Before(entry.expires) {
+			p.mu.Unlock()
+			return entry.response
+		}
+		delete(p.cache, key)
+	}
+	if call, ok := p.inflight[key]; ok {
+		p.mu.Unlock()
+		<-call.done
+		return p.answer(pdu, call.response, call.err)
+	}
+	call := &proxyCall{proxyRange: r, done: make(chan struct{}), stale: p.writing(&r)}
+	p.inflight[key] = call
+	p.mu.Unlock()
+
+	call.response, call.err = p.forward(pdu)
+
+	p.mu.Lock()
+	if p.inflight[key] == call {
+		delete(p.inflight, key)
+	}
+	// Exceptions are not cached
+	if call.err == nil && !call.stale && p.TTL > 0 && call.response.FunctionCode == pdu.FunctionCode {
+		now := time.Now()
+		p.sweep(now)
+		p.cache[key] = &proxyEntry{
+			proxyRange: r,
+			response:   call.response,
+			expires:    now.Add(p.TTL),
+		}
+	}
+	p.mu.Unlock()
+	close(call.done)
+	return p.answer(pdu, call.response, call.err)
+}
+
+// sweep removes the expired entries at most once per TTL, so that the cache
+// does not grow with the distinct reads, e.g. of a scanner. Caller must
+// hold the mutex.
+func (p *Proxy) sweep(now time.Time) {
+	if now.Sub(p.swept) < p.TTL {
+		return
+	}
+	p.swept = now
+	for key, entry := range p.cache {
+		if !now.Before(entry.expires) {
+			delete(p.cache, key)
+		}
+	}
+}
+
+func (p *Proxy) answer(pdu, response *PDUwithSlaveid, err error) *PDUwithSlaveid {
+	if err != nil {
+		return encodeMbError(pdu.SlaveID, pdu.FunctionCode, gatewayExceptionCode(err))
+	}
+	return response
+}
+
+// forward sends a request upstream.
+func (p *Proxy) forward(pdu *PDUwithSlaveid) (*PDUwithSlaveid, error) {
+	response, err := p.upstream.forward(pdu, p.Timeout)
+	if err != nil {
+		if p.Logger != nil {
+			p.Logger.Printf("modbus: slave id '%v' function '%v' failed: %v", pdu.SlaveID, pdu.FunctionCode, err)
+		}
+		return nil, err
+	}
+	// The response is shared by clients and cached
+	response.Data = append([]byte(nil), response.Data...)
+	return response, nil
+}
+
+// startWrite invalidates the responses overlapping a write, the reads
+// received until endWrite is called are not cached.
+func (p *Proxy) startWrite(r *proxyRange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writes[r] = true
+	p.invalidate(r)
+}
+
+// endWrite invalidates again the responses overlapping a write once it
+// was sent, the reads sent meanwhile may complete after it.
+func (p *Proxy) endWrite(r *proxyRange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.writes, r)
+	p.invalidate(r)
+	// The reads sent before are not shared with the reads received after
+	for key, call := range p.inflight {
+		if call.overlaps(r) {
+			delete(p.inflight, key)
+		}
+	}
+}
+
+// invalidate removes the cached responses overlapping r and marks the
+// overlapping reads being sent stale. Caller must hold the mutex.
+func (p *Proxy) invalidate(r *proxyRange) {
+	for key, entry := range p.cache {
+		if entry.overlaps(r) {
+			delete(p.cache, key)
+		}
+	}
+	for _, call := range p.inflight {
+		if call.overlaps(r) {
+			call.stale = true
+		}
+	}
+}
+
+// writing returns true if a write overlapping r is being sent. Caller must
+// hold the mutex.
+func (p *Proxy) writing(r *proxyRange) bool {
+	for w := range p.writes {
+		if w.overlaps(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// readRange returns the range of a cacheable read request.
+func readRange(pdu *PDUwithSlaveid) (r proxyRange, ok bool) {
+	if len(pdu.Data) != 4 {
+		return
+	}
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils:
+		r.table = TableCoils
+	case FuncCodeReadDiscreteInputs:
+		r.table = TableDiscreteInputs
+	case FuncCodeReadHoldingRegisters:
+		r.table = TableHoldingRegisters
+	case FuncCodeReadInputRegisters:
+		r.table = TableInputRegisters
+	default:
+		return
+	}
+	r.slaveID = pdu.SlaveID
+	r.address = int(binary.BigEndian.Uint16(pdu.Data))
+	r.quantity = int(binary.BigEndian.Uint16(pdu.Data[2:]))
+	return r, true
+}
+
+// writeRange returns the range written by a request.
+func writeRange(pdu *PDUwithSlaveid) (r proxyRange, ok bool) {
+	r.slaveID = pdu.SlaveID
+	switch pdu.FunctionCode {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteMultipleCoils:
+		r.table = TableCoils
+	case FuncCodeWriteSingleRegister, FuncCodeWriteMultipleRegisters, FuncCodeMaskWriteRegister:
+		r.table = TableHoldingRegisters
+	case FuncCodeReadWriteMultipleRegisters:
+		if len(pdu.Data) < 8 {
+			return
+		}
+		r.table = TableHoldingRegisters
+		r.address = int(binary.BigEndian.Uint16(pdu.Data[4:]))
+		r.quantity = int(binary.BigEndian.Uint16(pdu.Data[6:]))
+		return r, true
+	default:
+		return
+	}
+	address, quantity := requestAddressQuantity(&pdu.ProtocolDataUnit)
+	r.address, r.quantity = int(address), int(quantity)
+	return r, len(pdu.Data) >= 4
+}
@@ -0,0 +1,202 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingModel counts and delays the reads of holding registers.
+type countingModel struct {
+	*DataModel
+	delay time.Duration
+	reads int32
+}
+
+func (m *countingModel) ReadHoldingRegisters(slaveid byte, address, quantity uint16) ([]uint16, error) {
+	atomic.AddInt32(&m.reads, 1)
+	time.Sleep(m.delay)
+	return m.DataModel.ReadHoldingRegisters(slaveid, address, quantity)
+}
+
+func startProxy(t *testing.T, model *countingModel, ttl time.Duration) string {
+	device, err := NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go device.ServeModbus(model)
+	t.Cleanup(func() { device.Close() })
+	upstream := NewTCPClientHandler(device.Addr().String())
+	t.Cleanup(func() { upstream.Close() })
+	proxy := NewProxy(upstream)
+	proxy.TTL = ttl
+	server, err := NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.ServeTCP(server)
+	t.Cleanup(func() { server.Close() })
+	return server.Addr().String()
+}
+
+func TestProxyCache(t *testing.T) {
+	model := &countingModel{DataModel: NewDataModel(0, 0, 100, 0)}
+	model.WriteSingleRegister(1, 10, 1)
+	client := clientOf(startProxy(t, model, time.Minute))
+	for i := 0; i < 3; i++ {
+		if results, err := client.ReadHoldingRegisters(1, 10, 2); err != nil || !bytes.Equal([]byte{0, 1, 0, 0}, results) {
+			t.Fatalf("read: % x, %v", results, err)
+		}
+	}
+	if model.reads != 1 {
+		t.Fatalf("upstream reads expected 1, actual %v", model.reads)
+	}
+	// A write elsewhere keeps the cache
+	if _, err := client.WriteSingleRegister(1, 50, 5); err != nil {
+		t.Fatal(err)
+	}
+	client.ReadHoldingRegisters(1, 10, 2)
+	if model.reads != 1 {
+		t.Fatalf("upstream reads expected 1, actual %v", model.reads)
+	}
+	// An overlapping write invalidates it
+	if _, err := client.WriteMultipleRegisters(1, 11, 1, []byte{0, 7}); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := client.ReadHoldingRegisters(1, 10, 2); err != nil || !bytes.Equal([]byte{0, 1, 0, 7}, results) {
+		t.Fatalf("read after write: % x, %v", results, err)
+	}
+	if model.reads != 2 {
+		t.Fatalf("upstream reads expected 2, actual %v", model.reads)
+	}
+	// Exceptions are passed through and not cached
+	for i := 0; i < 2; i++ {
+		if _, err := client.ReadHoldingRegisters(1, 99, 2); err == nil {
+			t.Fatal("exception expected")
+		}
+	}
+	if model.reads != 4 {
+		t.Fatalf("upstream reads expected 4, actual %v", model.reads)
+	}
+}
+
+// gatedHandler blocks the writes until gate is closed.
+type gatedHandler struct {
+	ClientHandler
+	writing chan struct{}
+	gate    chan struct{}
+}
+
+func (h *gatedHandler) Encode(pdu *PDUwithSlaveid) ([]byte, error) {
+	if _, ok := writeRange(pdu); ok {
+		close(h.writing)
+		<-h.gate
+	}
+	return h.ClientHandler.Encode(pdu)
+}
+
+func TestProxyReadDuringWrite(t *testing.T) {
+	model := NewDataModel(0, 0, 100, 0)
+	handler := &gatedHandler{
+		ClientHandler: newLoopbackHandler(FramingRTU, mbServerHandler(model, "")),
+		writing:       make(chan struct{}),
+		gate:          make(chan struct{}),
+	}
+	proxy := NewProxy(handler)
+	proxy.TTL = time.Minute
+	read := &PDUwithSlaveid{1, ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{0, 10, 0, 1}}}
+	done := make(chan *PDUwithSlaveid)
+	go func() {
+		done <- proxy.handle(&PDUwithSlaveid{1, ProtocolDataUnit{FunctionCode: FuncCodeWriteSingleRegister, Data: []byte{0, 10, 0, 7}}})
+	}()
+	<-handler.writing
+	// The read is sent before the write, its response is not cached
+	if response := proxy.handle(read); !bytes.Equal([]byte{2, 0, 0}, response.Data) {
+		t.Fatalf("read during write: % x", response.Data)
+	}
+	close(handler.gate)
+	if response := <-done; response.FunctionCode != FuncCodeWriteSingleRegister {
+		t.Fatalf("write: %v", response)
+	}
+	if response := proxy.handle(read); !bytes.Equal([]byte{2, 0, 7}, response.Data) {
+		t.Fatalf("read after write: % x", response.Data)
+	}
+}
+
+func TestProxyCoalescing(t *testing.T) {
+	model := &countingModel{DataModel: NewDataModel(0, 0, 100, 0), delay: 100 * time.Millisecond}
+	address := startProxy(t, model, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := clientOf(address).ReadHoldingRegisters(1, 0, 10); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if model.reads < 1 || model.reads > 2 {
+		t.Fatalf("upstream reads expected 1, actual %v", model.reads)
+	}
+	// Without TTL, reads are not cached
+	clientOf(address).ReadHoldingRegisters(1, 0, 10)
+	if model.reads < 2 {
+		t.Fatalf("read served from cache without TTL")
+	}
+}
+
+func TestProxyTransactionID(t *testing.T) {
+	model := &countingModel{DataModel: NewDataModel(0, 0, 10, 0)}
+	conn, err := net.Dial("tcp", startProxy(t, model, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	for _, tid := range [][]byte{{0xBE, 0xEF}, {0x00, 0x07}} {
+		request := append(append([]byte{}, tid...), 0, 0, 0, 6, 1, 3, 0, 0, 0, 1)
+		if _, err = conn.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		response := make([]byte, 11)
+		if _, err = io.ReadFull(conn, response); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tid, response[:2]) {
+			t.Fatalf("transaction id expected % x, actual % x", tid, response[:2])
+		}
+	}
+}
+
+func TestProxyUpstreamFailure(t *testing.T) {
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	proxy := NewProxy(NewTCPClientHandler(closed.Addr().String()))
+	response := proxy.handle(&PDUwithSlaveid{1, ProtocolDataUnit{FunctionCode: FuncCodeReadCoils, Data: []byte{0, 0, 0, 1}}})
+	if response.FunctionCode != 0x81 || response.Data[0] != ExceptionCodeGatewayPathUnavailable {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
+
+func TestProxyCacheEviction(t *testing.T) {
+	proxy := NewProxy(newLoopbackHandler(FramingRTU, mbServerHandler(NewDataModel(0, 0, 1000, 0), "")))
+	proxy.TTL = 20 * time.Millisecond
+	// A scanner reads distinct ranges, the expired ones are removed
+	for _, start := range []uint16{0, 500} {
+		for address := start; address < start+100; address++ {
+			proxy.handle(&PDUwithSlaveid{1, ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{byte(address >> 8), byte(address), 0, 1}}})
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	if len(proxy.cache) > 100 {
+		t.Fatalf("%v cached reads, expected at most 100", len(proxy.cache))
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrUnexpectedRequest is returned by ReplayTransporter when a request
+// does not match the recording.
+var ErrUnexpectedRequest = errors.New("modbus: unexpected request")
+
+// RecordedTransaction is one line of a recording.
+// Request and Response are hexadecimal encoded application data units.
+type RecordedTransaction struct {
+	Time     time.Time     `json:"time"`
+	Request  string        `json:"request"`
+	Response string        `json:"response,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// RecordTransporter writes every transaction of the underlying transporter
+// to a JSON-lines stream.
+type RecordTransporter struct {
+	Transporter Transporter
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewRecordTransporter allocates a RecordTransporter writing to w.
+func NewRecordTransporter(transporter Transporter, w io.Writer) *RecordTransporter {
+	return &RecordTransporter{
+		Transporter: transporter,
+		encoder:     json.NewEncoder(w),
+	}
+}
+
+// Send sends the request and records it with the response.
+// A failure to write the recording is returned if the transaction succeeded.
+func (mb *RecordTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	start := time.Now()
+	aduResponse, err = mb.Transporter.Send(aduRequest)
+	t := RecordedTransaction{
+		Time:     start,
+		Request:  hex.EncodeToString(aduRequest),
+		Response: hex.EncodeToString(aduResponse),
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if encodeErr := mb.encoder.Encode(&t); encodeErr != nil && err == nil {
+		err = encodeErr
+	}
+	return
+}
+
+// ReplayMode specifies how requests are matched against a recording.
+type ReplayMode int
+
+const (
+	// ReplayStrict expects the requests in the recorded order.
+	ReplayStrict ReplayMode = iota
+	// ReplayLenient answers a request with any recorded transaction
+	// having the same request, preferring ones which are not replayed yet.
+	ReplayLenient
+)
+
+type replayEntry struct {
+	request  []byte
+	response []byte
+	duration time.Duration
+	err      string
+	replayed bool
+}
+
+// ReplayTransporter answers requests from a recording made by
+// RecordTransporter. TCP transaction identifiers are ignored when matching
+// and copied from the request to the response.
+type ReplayTransporter struct {
+	// Mode is ReplayStrict by default
+	Mode ReplayMode
+	// Timing delays each response by its recorded duration
+	Timing bool
+
+	framing Framing
+	mu      sync.Mutex
+	entries []*replayEntry
+	next    int
+}
+
+// NewReplayTransporter loads a recording of the given framing from r.
+func NewReplayTransporter(r io.Reader, framing Framing) (*ReplayTransporter, error) {
+	mb := &ReplayTransporter{framing: framing}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var t RecordedTransaction
+		if err := json.Unmarshal(scanner.Bytes(), &t); err != nil {
+			return nil, fmt.Errorf("modbus: recording line %v: %v", line, err)
+		}
+		entry := &replayEntry{duration: t.Duration, err: t.Error}
+		var err error
+		if entry.request, err = hex.DecodeString(t.Request); err != nil {
+			return nil, fmt.Errorf("modbus: recording line %v: request: %v", line, err)
+		}
+		if entry.response, err = hex.DecodeString(t.Response); err != nil {
+			return nil, fmt.Errorf("modbus: recording line %v: response: %v", line, err)
+		}
+		mb.entries = append(mb.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mb, nil
+}
+
+// Send returns the recorded response of the request.
+func (mb *ReplayTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	mb.mu.Lock()
+	entry, err := mb.match(aduRequest)
+	mb.mu.Unlock()
+	if err != nil {
+		return
+	}
+	if mb.Timing {
+		time.Sleep(entry.duration)
+	}
+	if entry.err != "" {
+		err = errors.New(entry.err)
+		return
+	}
+	aduResponse = make([]byte, len(entry.response))
+	copy(aduResponse, entry.response)
+	if mb.framing == FramingTCP && len(aduResponse) >= 2 && len(aduRequest) >= 2 {
+		copy(aduResponse, aduRequest[:2])
+	}
+	return
+}
+
+// Remaining returns the number of recorded transactions not replayed yet.
+func (mb *ReplayTransporter) Remaining() (n int) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for _, entry := range mb.entries {
+		if !entry.replayed {
+			n++
+		}
+	}
+	return
+}
+
+// match finds the entry of a request. Caller must hold the mutex.
+func (mb *ReplayTransporter) match(aduRequest []byte) (*replayEntry, error) {
+	if mb.Mode == ReplayStrict {
+		if mb.next >= len(mb.entries) {
+			return nil, fmt.Errorf("%w % x: recording has ended", ErrUnexpectedRequest, aduRequest)
+		}
+		entry := mb.entries[mb.next]
+		if !mb.equal(entry.request, aduRequest) {
+			return nil, fmt.Errorf("%w % x: expected % x (transaction %v)", ErrUnexpectedRequest, aduRequest, entry.request, mb.next+1)
+		}
+		mb.next++
+		entry.replayed = true
+		return entry, nil
+	}
+	var found *replayEntry
+	for _, entry := range mb.entries {
+		if mb.equal(entry.request, aduRequest) {
+			if !entry.replayed {
+				found = entry
+				break
+			}
+			if found == nil {
+				found = entry
+			}
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w % x: not found in recording", ErrUnexpectedRequest, aduRequest)
+	}
+	found.replayed = true
+	return found, nil
+}
+
+// equal compares two requests, ignoring TCP transaction identifiers.
+func (mb *ReplayTransporter) equal(recorded, request []byte) bool {
+	if mb.framing == FramingTCP && len(recorded) >= 2 && len(request) >= 2 {
+		return bytes.Equal(recorded[2:], request[2:])
+	}
+	return bytes.Equal(recorded, request)
+}
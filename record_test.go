@@ -0,0 +1,84 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var recording bytes.Buffer
+	live := funcTransporter(func(aduRequest []byte) ([]byte, error) {
+		if aduRequest[tcpHeaderSize] == FuncCodeWriteSingleRegister {
+			return nil, errors.New("i/o timeout")
+		}
+		response := []byte{0, 0, 0, 0, 0, 5, 1, 3, 2, 0, 42}
+		copy(response, aduRequest[:2])
+		return response, nil
+	})
+	client := NewClient2(&tcpPackager{}, NewRecordTransporter(live, &recording))
+	if _, err := client.ReadHoldingRegisters(1, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteSingleRegister(1, 0, 1); err == nil {
+		t.Fatal("expected error")
+	}
+
+	replay, err := NewReplayTransporter(bytes.NewReader(recording.Bytes()), FramingTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A new packager starts another transaction id sequence
+	packager := &tcpPackager{transactionId: 100}
+	client = NewClient2(packager, replay)
+	results, err := client.ReadHoldingRegisters(1, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 42}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if _, err = client.WriteSingleRegister(1, 0, 1); err == nil || err.Error() != "i/o timeout" {
+		t.Fatalf("expected recorded error, actual %v", err)
+	}
+	if replay.Remaining() != 0 {
+		t.Fatalf("expected all transactions replayed, remaining %v", replay.Remaining())
+	}
+	if _, err = client.ReadHoldingRegisters(1, 0, 1); !errors.Is(err, ErrUnexpectedRequest) {
+		t.Fatalf("expected unexpected request, actual %v", err)
+	}
+}
+
+func TestReplayModes(t *testing.T) {
+	recording := `{"request":"010300000001840a","response":"010302002ab85b"}
+{"request":"010300010001d5ca","response":"01030200076f88"}
+`
+	requestA := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a}
+	requestB := []byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x01, 0xd5, 0xca}
+
+	replay, err := NewReplayTransporter(bytes.NewBufferString(recording), FramingRTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = replay.Send(requestB); !errors.Is(err, ErrUnexpectedRequest) {
+		t.Fatalf("strict mode must enforce order, actual %v", err)
+	}
+
+	replay, err = NewReplayTransporter(bytes.NewBufferString(recording), FramingRTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Mode = ReplayLenient
+	for _, request := range [][]byte{requestB, requestA, requestB} {
+		if _, err = replay.Send(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	response, err := replay.Send(requestA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x01, 0x03, 0x02, 0x00, 0x2a, 0xb8, 0x5b}, response) {
+		t.Fatalf("unexpected response: %x", response)
+	}
+}
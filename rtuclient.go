@@ -7,7 +7,6 @@ package modbus
 import (
 	"encoding/binary"
 	"fmt"
-	"io"
 	"time"
 
 	"github.com/tarm/serial"
@@ -28,6 +27,7 @@ type RTUClientHandler struct {
 func NewRTUClientHandler(address string) *RTUClientHandler {
 	handler := &RTUClientHandler{}
 	handler.Name = address
+	handler.transport = transportRTU
 	handler.ReadTimeout = serialTimeout
 	handler.IdleTimeout = serialIdleTimeout
 	handler.Baud = 19200
@@ -103,7 +103,7 @@ func (mb *rtuPackager) Decode(adu []byte) (pdu *PDUwithSlaveid, err error) {
 	crc.reset().pushBytes(adu[0 : length-2])
 	checksum := uint16(adu[length-1])<<8 | uint16(adu[length-2])
 	if checksum != crc.value() {
-		err = fmt.Errorf("modbus: response crc '%v' does not match expected '%v'", checksum, crc.value())
+		err = &ChecksumError{Framing: FramingRTU, Checksum: checksum, Expected: crc.value()}
 		return
 	}
 	// Function code & data
@@ -130,47 +130,33 @@ func (mb *rtuSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, err
 
 	// Send the request
 	mb.serialPort.logf("modbus: sending % x\n", aduRequest)
+	mb.serialPort.slogFrame("modbus: sending", aduRequest, 0)
 	if _, err = mb.port.Write(aduRequest); err != nil {
 		return
 	}
-	function := aduRequest[1]
-	functionFail := aduRequest[1] & 0x80
 	bytesToRead := calculateResponseLength(aduRequest)
 	time.Sleep(mb.calculateDelay(len(aduRequest) + bytesToRead))
 
-	var n int
-	var n1 int
-	var data [rtuMaxSize]byte
-	//We first read the minimum length and then read either the full package
-	//or the error package, depending on the error status (byte 2 of the response)
-	n, err = io.ReadAtLeast(mb.port, data[:], rtuMinSize)
-	if err != nil {
-		return
+	// Frames are resynchronized on noise and validated by CRC
+	frames := NewRTUFrameReader(mb.port, mb.Baud)
+	frames.Expect = ResponseFrames
+	var skipped []byte
+	frames.Skipped = func(data []byte) {
+		mb.serialPort.logf("modbus: skipped % x\n", data)
+		skipped = append(skipped, data...)
 	}
-	//if the function is correct
-	if data[1] == function {
-		//we read the rest of the bytes
-		if n < bytesToRead {
-			if bytesToRead > rtuMinSize && bytesToRead <= rtuMaxSize {
-				if bytesToRead > n {
-					n1, err = io.ReadFull(mb.port, data[n:bytesToRead])
-					n += n1
-				}
-			}
-		}
-	} else if data[1] == functionFail {
-		//for error we need to read 5 bytes
-		if n < bytesToRead {
-			n1, err = io.ReadFull(mb.port, data[n:5])
+	if aduResponse, err = frames.ReadFrame(); err != nil {
+		// Bytes were received but do not make a valid frame
+		if length := len(skipped); length >= rtuMinSize {
+			var crc crc
+			crc.reset().pushBytes(skipped[:length-2])
+			checksum := uint16(skipped[length-1])<<8 | uint16(skipped[length-2])
+			err = &ChecksumError{Framing: FramingRTU, Checksum: checksum, Expected: crc.value()}
 		}
-		n += n1
-	}
-
-	if err != nil {
 		return
 	}
-	aduResponse = data[:n]
 	mb.serialPort.logf("modbus: received % x\n", aduResponse)
+	mb.serialPort.slogFrame("modbus: received", aduResponse, time.Since(mb.serialPort.lastActivity))
 	return
 }
 
@@ -6,6 +6,7 @@ package modbus
 
 import (
 	"bytes"
+	"io"
 	"testing"
 )
 
@@ -92,3 +93,38 @@ func BenchmarkRTUDecoder(b *testing.B) {
 		}
 	}
 }
+
+// chunkPort returns data at most n bytes per read and discards writes.
+type chunkPort struct {
+	data []byte
+	n    int
+}
+
+func (p *chunkPort) Read(b []byte) (int, error) {
+	if len(p.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b[:min(len(b), p.n)], p.data)
+	p.data = p.data[n:]
+	return n, nil
+}
+
+func (p *chunkPort) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *chunkPort) Close() error {
+	return nil
+}
+
+func TestRTUExceptionResponse(t *testing.T) {
+	response, _ := (&rtuPackager{}).Encode(encodeMbError(1, FuncCodeReadHoldingRegisters, ExceptionCodeIllegalDataAddress))
+	handler := NewRTUClientHandler("")
+	handler.Baud = 115200
+	handler.port = &chunkPort{data: response, n: rtuMinSize}
+	defer handler.Close()
+	_, err := NewClient(handler).ReadHoldingRegisters(1, 0, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("illegal data address expected, actual %v", err)
+	}
+}
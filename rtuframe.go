@@ -0,0 +1,272 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// rtuMinFrameGap is the smallest silent interval ending a frame. Serial
+// drivers and USB adapters deliver bytes in chunks, a shorter interval
+// between two reads does not mean the line was silent.
+const rtuMinFrameGap = 20 * time.Millisecond
+
+// FrameKind tells a frame reader which frames are expected on the line.
+type FrameKind int
+
+const (
+	// AnyFrames expects both requests and responses, e.g. when sniffing.
+	AnyFrames FrameKind = iota
+	// RequestFrames expects requests only, as a slave does.
+	RequestFrames
+	// ResponseFrames expects responses only, as a master does.
+	ResponseFrames
+)
+
+// RTUFrameReader reads RTU frames from a stream.
+// A frame is returned as soon as the bytes received have the length given
+// by the function code and a valid CRC. Frames do not span silent intervals
+// longer than Gap, which also delimit frames of unknown function codes.
+// Bytes which are not part of a frame are skipped one by one until the
+// reader is synchronized again.
+type RTUFrameReader struct {
+	// Expect selects the frame lengths allowed for a function code.
+	Expect FrameKind
+	// Gap is the silent interval ending a frame.
+	Gap time.Duration
+	// Skipped is called, if set, with bytes discarded while resynchronizing,
+	// e.g. a frame with a bad CRC.
+	Skipped func(data []byte)
+
+	r       io.Reader
+	buf     []byte
+	chunk   [rtuMaxSize]byte
+	last    time.Time
+	segment int
+	skipped []byte
+}
+
+// NewRTUFrameReader allocates a frame reader of r, baud is used to compute
+// the silent interval ending a frame, see rtuFrameGap.
+func NewRTUFrameReader(r io.Reader, baud int) *RTUFrameReader {
+	return &RTUFrameReader{
+		Gap: rtuFrameGap(baud),
+		r:   r,
+	}
+}
+
+// ReadFrame returns the next frame with a valid CRC. A read error is
+// returned once the bytes received before it have been processed; a serial
+// port returns io.EOF when its read timeout expires.
+func (f *RTUFrameReader) ReadFrame() (adu []byte, err error) {
+	empty := 0
+	for {
+		if adu = f.frame(); adu != nil {
+			return
+		}
+		var n int
+		n, err = f.r.Read(f.chunk[:])
+		now := time.Now()
+		if n > 0 {
+			if len(f.buf) > 0 && now.Sub(f.last) > f.Gap {
+				f.segment = len(f.buf)
+			}
+			f.buf = append(f.buf, f.chunk[:n]...)
+			f.last = now
+			empty = 0
+		}
+		if err != nil {
+			// Nothing else is coming, pending bytes end a frame
+			if len(f.buf) > 0 {
+				f.segment = len(f.buf)
+				if adu = f.frame(); adu != nil {
+					err = nil
+					return
+				}
+			}
+			f.flushSkipped()
+			return
+		}
+		if n == 0 {
+			empty++
+			if empty >= 100 {
+				err = io.ErrNoProgress
+				return
+			}
+		}
+	}
+}
+
+// frame returns the next frame of the buffer, or nil if more bytes are needed.
+func (f *RTUFrameReader) frame() []byte {
+	for len(f.buf) > 0 {
+		limit := len(f.buf)
+		if f.segment > 0 {
+			limit = f.segment
+		}
+		lengths, wait := rtuFrameLengths(f.buf[:limit], f.Expect)
+		for _, length := range lengths {
+			if length > limit {
+				wait = wait || length <= rtuMaxSize
+			} else if rtuValid(f.buf[:length]) {
+				return f.take(length)
+			}
+		}
+		if f.segment > 0 {
+			if len(lengths) == 0 && rtuValid(f.buf[:f.segment]) {
+				return f.take(f.segment)
+			}
+		} else if wait || (len(lengths) == 0 && limit < rtuMaxSize) {
+			return nil
+		}
+		// Not a frame, resynchronize on the next byte
+		f.skip()
+	}
+	return nil
+}
+
+// take removes the first length bytes of the buffer and returns them.
+func (f *RTUFrameReader) take(length int) []byte {
+	f.flushSkipped()
+	adu := make([]byte, length)
+	copy(adu, f.buf)
+	f.buf = f.buf[length:]
+	if f.segment > 0 {
+		f.segment -= length
+	}
+	return adu
+}
+
+// skip discards the first byte of the buffer.
+func (f *RTUFrameReader) skip() {
+	f.skipped = append(f.skipped, f.buf[0])
+	f.buf = f.buf[1:]
+	if f.segment > 0 {
+		f.segment--
+		if f.segment == 0 {
+			f.flushSkipped()
+		}
+	}
+}
+
+func (f *RTUFrameReader) flushSkipped() {
+	if len(f.skipped) == 0 {
+		return
+	}
+	if f.Skipped != nil {
+		f.Skipped(f.skipped)
+	}
+	f.skipped = nil
+}
+
+// rtuFrameGap returns the silent interval ending a frame at baud. The 3.5
+// character times of the specification, 1.75ms above 19200 baud, are far
+// shorter than the latency of serial drivers, so rtuMinFrameGap is
+// deliberately used instead unless the character times are longer, i.e.
+// below 1750 baud.
+func rtuFrameGap(baud int) time.Duration {
+	if baud > 0 {
+		if gap := time.Duration(35000000/baud) * time.Microsecond; gap > rtuMinFrameGap {
+			return gap
+		}
+	}
+	return rtuMinFrameGap
+}
+
+// rtuValid returns true if adu is long enough and its CRC is valid.
+func rtuValid(adu []byte) bool {
+	length := len(adu)
+	if length < rtuMinSize {
+		return false
+	}
+	var crc crc
+	crc.reset().pushBytes(adu[:length-2])
+	return uint16(adu[length-1])<<8|uint16(adu[length-2]) == crc.value()
+}
+
+// rtuFrameLengths returns the possible lengths of a frame starting with
+// header. pending is true if header is too short to know all of them.
+// There is no length for unknown function codes.
+func rtuFrameLengths(header []byte, kind FrameKind) (lengths []int, pending bool) {
+	if len(header) < 2 {
+		return nil, true
+	}
+	if kind != ResponseFrames {
+		length, complete := rtuRequestLength(header)
+		if !complete {
+			pending = true
+		} else if length > 0 {
+			lengths = append(lengths, length)
+		}
+	}
+	if kind != RequestFrames {
+		length, complete := rtuResponseLength(header)
+		if !complete {
+			pending = true
+		} else if length > 0 {
+			lengths = append(lengths, length)
+		}
+	}
+	return
+}
+
+// rtuRequestLength returns the length of a request frame given its first
+// bytes. It returns the number of bytes needed to know the length if header
+// is too short, and zero if the function code is unknown.
+func rtuRequestLength(header []byte) (length int, complete bool) {
+	switch header[1] {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister:
+		return 8, true
+	case FuncCodeMaskWriteRegister:
+		return 10, true
+	case FuncCodeReadFIFOQueue:
+		return 6, true
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		if len(header) < 7 {
+			return 7, false
+		}
+		return 9 + int(header[6]), true
+	case FuncCodeReadWriteMultipleRegisters:
+		if len(header) < 11 {
+			return 11, false
+		}
+		return 13 + int(header[10]), true
+	}
+	return 0, true
+}
+
+// rtuResponseLength is the same as rtuRequestLength for response frames.
+func rtuResponseLength(header []byte) (length int, complete bool) {
+	if header[1]&0x80 != 0 {
+		if length, _ = rtuRequestLength([]byte{header[0], header[1] &^ 0x80}); length > 0 {
+			return 5, true
+		}
+		return 0, true
+	}
+	switch header[1] {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeReadWriteMultipleRegisters:
+		if len(header) < 3 {
+			return 3, false
+		}
+		return 5 + int(header[2]), true
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		return 8, true
+	case FuncCodeMaskWriteRegister:
+		return 10, true
+	case FuncCodeReadFIFOQueue:
+		if len(header) < 4 {
+			return 4, false
+		}
+		return 6 + int(binary.BigEndian.Uint16(header[2:])), true
+	}
+	return 0, true
+}
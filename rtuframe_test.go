@@ -0,0 +1,128 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// chunkReader returns one chunk per read, waiting before each of them.
+type chunkReader struct {
+	chunks [][]byte
+	delay  time.Duration
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(b, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestRTUFrameReaderResync(t *testing.T) {
+	request := []byte{0x01, 0x03, 0x00, 0x6B, 0x00, 0x03, 0x74, 0x17}
+	// Leading noise and a corrupted copy of the frame
+	var stream []byte
+	stream = append(stream, 0xFF, 0x00)
+	stream = append(stream, 0x01, 0x03, 0x00, 0x6B, 0x00, 0x03, 0x74, 0x18)
+	stream = append(stream, request...)
+
+	var skipped []byte
+	frames := NewRTUFrameReader(bytes.NewReader(stream), 19200)
+	frames.Expect = RequestFrames
+	frames.Skipped = func(data []byte) {
+		skipped = append(skipped, data...)
+	}
+	adu, err := frames.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(request, adu) {
+		t.Fatalf("adu expected % x, actual % x", request, adu)
+	}
+	if !bytes.Equal(stream[:10], skipped) {
+		t.Fatalf("skipped expected % x, actual % x", stream[:10], skipped)
+	}
+	if _, err = frames.ReadFrame(); err != io.EOF {
+		t.Fatalf("error expected %v, actual %v", io.EOF, err)
+	}
+}
+
+func TestRTUFrameReaderBothDirections(t *testing.T) {
+	adus := [][]byte{
+		// Read holding registers
+		{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A},
+		{0x01, 0x03, 0x02, 0x12, 0x34, 0xB5, 0x33},
+		// Read FIFO queue
+		{0x01, 0x18, 0x04, 0xDE, 0x03, 0x47},
+		{0x01, 0x18, 0x00, 0x06, 0x00, 0x02, 0x01, 0xB8, 0x12, 0x84, 0x19, 0x18},
+		// Exception
+		{0x01, 0x83, 0x02, 0xC0, 0xF1},
+	}
+	r, w := io.Pipe()
+	go func() {
+		for _, adu := range adus {
+			w.Write(adu)
+		}
+		w.Close()
+	}()
+	frames := NewRTUFrameReader(r, 9600)
+	for i, expected := range adus {
+		adu, err := frames.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, adu) {
+			t.Fatalf("adu %v expected % x, actual % x", i, expected, adu)
+		}
+	}
+}
+
+func TestRTUFrameReaderGap(t *testing.T) {
+	// Unknown function code, the frame ends with the silent interval
+	custom := []byte{0x01, 0x41, 0x01, 0x02}
+	var crc crc
+	crc.reset().pushBytes(custom)
+	custom = append(custom, byte(crc.value()), byte(crc.value()>>8))
+	request := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}
+
+	r := &chunkReader{
+		chunks: [][]byte{custom, {0x01, 0x10}, request},
+		delay:  5 * time.Millisecond,
+	}
+	frames := NewRTUFrameReader(r, 19200)
+	frames.Gap = time.Millisecond
+	var skipped []byte
+	frames.Skipped = func(data []byte) {
+		skipped = append(skipped, data...)
+	}
+	for _, expected := range [][]byte{custom, request} {
+		adu, err := frames.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, adu) {
+			t.Fatalf("adu expected % x, actual % x", expected, adu)
+		}
+	}
+	// A truncated frame does not take bytes of the next one
+	if !bytes.Equal([]byte{0x01, 0x10}, skipped) {
+		t.Fatalf("skipped expected % x, actual % x", []byte{0x01, 0x10}, skipped)
+	}
+}
+
+func TestRTUFrameGap(t *testing.T) {
+	if rtuMinFrameGap != rtuFrameGap(115200) {
+		t.Fatalf("gap expected %v, actual %v", rtuMinFrameGap, rtuFrameGap(115200))
+	}
+	if rtuMinFrameGap != rtuFrameGap(9600) || rtuMinFrameGap != rtuFrameGap(0) {
+		t.Fatalf("gap expected %v, actual %v and %v", rtuMinFrameGap, rtuFrameGap(9600), rtuFrameGap(0))
+	}
+	if 29166*time.Microsecond != rtuFrameGap(1200) {
+		t.Fatalf("gap expected %v, actual %v", 29166*time.Microsecond, rtuFrameGap(1200))
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"log"
+)
+
+const (
+	scannerStep = 16
+	// Largest quantities of a read request
+	maxReadBits      = 2000
+	maxReadRegisters = 125
+)
+
+// AddressRange is a range of addresses, both ends included.
+type AddressRange struct {
+	Start uint16 `json:"start"`
+	End   uint16 `json:"end"`
+}
+
+// ScannedDevice is a slave found by a Scanner with its readable ranges.
+type ScannedDevice struct {
+	SlaveID          byte           `json:"slave"`
+	Coils            []AddressRange `json:"coils"`
+	DiscreteInputs   []AddressRange `json:"discrete_inputs"`
+	HoldingRegisters []AddressRange `json:"holding_registers"`
+	InputRegisters   []AddressRange `json:"input_registers"`
+}
+
+// Ranges returns the readable ranges of a table.
+func (d *ScannedDevice) Ranges(table Table) []AddressRange {
+	switch table {
+	case TableCoils:
+		return d.Coils
+	case TableDiscreteInputs:
+		return d.DiscreteInputs
+	case TableHoldingRegisters:
+		return d.HoldingRegisters
+	case TableInputRegisters:
+		return d.InputRegisters
+	}
+	return nil
+}
+
+func (d *ScannedDevice) setRanges(table Table, ranges []AddressRange) {
+	switch table {
+	case TableCoils:
+		d.Coils = ranges
+	case TableDiscreteInputs:
+		d.DiscreteInputs = ranges
+	case TableHoldingRegisters:
+		d.HoldingRegisters = ranges
+	case TableInputRegisters:
+		d.InputRegisters = ranges
+	}
+}
+
+// DeviceMap is the result of a scan, it is meant to be saved as JSON.
+type DeviceMap struct {
+	Devices []*ScannedDevice `json:"devices"`
+}
+
+// Scanner finds the slaves answering on a bus or behind a gateway, and the
+// address ranges they can read. The timeout of the client handler should be
+// short as most probes of absent slaves time out.
+type Scanner struct {
+	Client Client
+	// Slaves to probe, 1 to 247 if empty
+	Slaves []byte
+	// Tables to scan, all of them if empty
+	Tables []Table
+	// Step between probed addresses, a range shorter than Step may be
+	// missed. Ends of ranges are found by binary search.
+	Step int
+	// MaxAddress is the last address scanned, 0xFFFF if zero
+	MaxAddress uint16
+	// Progress logger
+	Logger *log.Logger
+}
+
+// NewScanner allocates a scanner using client.
+func NewScanner(client Client) *Scanner {
+	return &Scanner{
+		Client: client,
+		Step:   scannerStep,
+	}
+}
+
+// Scan probes the slaves then scans the tables of those answering.
+func (s *Scanner) Scan() *DeviceMap {
+	m := &DeviceMap{Devices: []*ScannedDevice{}}
+	for _, slave := range s.ProbeSlaves() {
+		device := &ScannedDevice{SlaveID: slave}
+		for _, table := range s.tables() {
+			ranges := s.ScanTable(slave, table)
+			s.logf("modbus: slave %v %v: %v ranges", slave, table, len(ranges))
+			device.setRanges(table, ranges)
+		}
+		m.Devices = append(m.Devices, device)
+	}
+	return m
+}
+
+// ProbeSlaves returns the slaves answering a read of holding register 0.
+// An exception response counts as an answer, except gateway exceptions.
+func (s *Scanner) ProbeSlaves() []byte {
+	slaves := s.Slaves
+	if len(slaves) == 0 {
+		for i := 1; i <= 247; i++ {
+			slaves = append(slaves, byte(i))
+		}
+	}
+	var found []byte
+	for _, slave := range slaves {
+		_, err := s.Client.ReadHoldingRegisters(slave, 0, 1)
+		if mbError, ok := err.(*ModbusError); ok {
+			if mbError.ExceptionCode == ExceptionCodeGatewayPathUnavailable ||
+				mbError.ExceptionCode == ExceptionCodeGatewayTargetDeviceFailedToRespond {
+				continue
+			}
+		} else if err != nil {
+			continue
+		}
+		s.logf("modbus: slave %v answered", slave)
+		found = append(found, slave)
+	}
+	return found
+}
+
+// ScanTable returns the readable ranges of a table of slave, nil if the
+// slave does not support the table.
+func (s *Scanner) ScanTable(slave byte, table Table) []AddressRange {
+	ranges := []AddressRange{}
+	step := s.Step
+	if step <= 0 {
+		step = 1
+	}
+	max := int(s.MaxAddress)
+	if max == 0 {
+		max = 0xFFFF
+	}
+	// unreadable is the last address known not to be readable
+	unreadable := -1
+	for address := 0; address <= max; {
+		ok, supported := s.readable(slave, table, address, 1)
+		if !supported {
+			return nil
+		}
+		if !ok {
+			unreadable = address
+			address += step
+			continue
+		}
+		// Binary search the start between the last unreadable address
+		lo, hi := unreadable, address
+		for hi-lo > 1 {
+			mid := (lo + hi) / 2
+			if ok, _ = s.readable(slave, table, mid, 1); ok {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+		end := s.rangeEnd(slave, table, address, max)
+		ranges = append(ranges, AddressRange{Start: uint16(hi), End: uint16(end)})
+		unreadable = end + 1
+		address = end + 1 + step
+	}
+	return ranges
+}
+
+// rangeEnd returns the last address of the range of the readable address.
+// Multiple reads double the quantity until they fail, then binary search it.
+func (s *Scanner) rangeEnd(slave byte, table Table, address, max int) int {
+	limit := maxReadRegisters
+	if table == TableCoils || table == TableDiscreteInputs {
+		limit = maxReadBits
+	}
+	for {
+		if limit > max-address+1 {
+			limit = max - address + 1
+		}
+		// quantity is readable, quantity*2 may not
+		quantity, failed := 1, limit+1
+		for n := 2; n <= limit; n *= 2 {
+			if ok, _ := s.readable(slave, table, address, n); !ok {
+				failed = n
+				break
+			}
+			quantity = n
+		}
+		if failed > limit && quantity < limit {
+			if ok, _ := s.readable(slave, table, address, limit); ok {
+				quantity = limit
+			} else {
+				failed = limit
+			}
+		}
+		for failed-quantity > 1 {
+			mid := (quantity + failed) / 2
+			if ok, _ := s.readable(slave, table, address, mid); ok {
+				quantity = mid
+			} else {
+				failed = mid
+			}
+		}
+		// The device may read fewer items per request than the range holds
+		next := address + quantity
+		if next > max {
+			return max
+		}
+		if ok, _ := s.readable(slave, table, next, 1); !ok {
+			return next - 1
+		}
+		address = next
+	}
+}
+
+// readable reads quantity items of a table. supported is false if the
+// slave answers with an illegal function exception.
+func (s *Scanner) readable(slave byte, table Table, address, quantity int) (ok, supported bool) {
+	var err error
+	switch table {
+	case TableCoils:
+		_, err = s.Client.ReadCoils(slave, uint16(address), uint16(quantity))
+	case TableDiscreteInputs:
+		_, err = s.Client.ReadDiscreteInputs(slave, uint16(address), uint16(quantity))
+	case TableHoldingRegisters:
+		_, err = s.Client.ReadHoldingRegisters(slave, uint16(address), uint16(quantity))
+	case TableInputRegisters:
+		_, err = s.Client.ReadInputRegisters(slave, uint16(address), uint16(quantity))
+	}
+	if mbError, isException := err.(*ModbusError); isException {
+		return false, mbError.ExceptionCode != ExceptionCodeIllegalFunction
+	}
+	return err == nil, true
+}
+
+func (s *Scanner) tables() []Table {
+	if len(s.Tables) > 0 {
+		return s.Tables
+	}
+	return []Table{TableCoils, TableDiscreteInputs, TableHoldingRegisters, TableInputRegisters}
+}
+
+func (s *Scanner) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
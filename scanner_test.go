@@ -0,0 +1,77 @@
+package modbus
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// scannedClient is a device with readable holding register ranges which
+// reads at most 50 registers per request.
+type scannedClient struct {
+	Client
+	slaves   map[byte]bool
+	ranges   []AddressRange
+	requests int
+}
+
+func (c *scannedClient) read(slaveid byte, address, quantity uint16) ([]byte, error) {
+	c.requests++
+	if !c.slaves[slaveid] {
+		return nil, errors.New("timeout")
+	}
+	if quantity > 50 {
+		return nil, &ModbusError{SlaveID: slaveid, ExceptionCode: ExceptionCodeIllegalDataValue}
+	}
+	for _, r := range c.ranges {
+		if address >= r.Start && int(address)+int(quantity)-1 <= int(r.End) {
+			return make([]byte, 2*quantity), nil
+		}
+	}
+	return nil, &ModbusError{SlaveID: slaveid, ExceptionCode: ExceptionCodeIllegalDataAddress}
+}
+
+func (c *scannedClient) ReadHoldingRegisters(slaveid byte, address, quantity uint16) ([]byte, error) {
+	return c.read(slaveid, address, quantity)
+}
+
+func (c *scannedClient) ReadInputRegisters(slaveid byte, address, quantity uint16) ([]byte, error) {
+	return nil, &ModbusError{SlaveID: slaveid, ExceptionCode: ExceptionCodeIllegalFunction}
+}
+
+func TestScanner(t *testing.T) {
+	client := &scannedClient{
+		slaves: map[byte]bool{3: true},
+		ranges: []AddressRange{{0, 9}, {100, 299}, {1000, 1000}, {1005, 1030}},
+	}
+	scanner := NewScanner(client)
+	scanner.Slaves = []byte{1, 2, 3}
+	scanner.Tables = []Table{TableHoldingRegisters, TableInputRegisters}
+	scanner.Step = 4
+	scanner.MaxAddress = 2000
+	m := scanner.Scan()
+	if len(m.Devices) != 1 || m.Devices[0].SlaveID != 3 {
+		t.Fatalf("unexpected devices %+v", m.Devices)
+	}
+	device := m.Devices[0]
+	if !reflect.DeepEqual(client.ranges, device.HoldingRegisters) {
+		t.Fatalf("ranges expected %v, actual %v", client.ranges, device.HoldingRegisters)
+	}
+	if device.InputRegisters != nil {
+		t.Fatalf("unsupported table expected, actual %v", device.InputRegisters)
+	}
+	if client.requests > 1000 {
+		t.Fatalf("too many requests %v", client.requests)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"devices":[{"slave":3,"coils":null,"discrete_inputs":null,` +
+		`"holding_registers":[{"start":0,"end":9},{"start":100,"end":299},{"start":1000,"end":1000},{"start":1005,"end":1030}],` +
+		`"input_registers":null}]}`
+	if expected != string(data) {
+		t.Fatalf("json expected %s, actual %s", expected, data)
+	}
+}
@@ -7,11 +7,10 @@ package modbus
 import (
 	"io"
 	"log"
+	"log/slog"
 	"sync"
 	"time"
 
-	"fmt"
-
 	"github.com/tarm/serial"
 )
 
@@ -26,14 +25,21 @@ type serialPort struct {
 	// Serial port configuration.
 	serial.Config
 
-	Logger      *log.Logger
-	IdleTimeout time.Duration
+	Logger *log.Logger
+	// Structured logger, frames are logged at debug level and
+	// connection events at info level
+	StructuredLogger *slog.Logger
+	IdleTimeout      time.Duration
 
 	mu sync.Mutex
 	// port is platform-dependent data structure for serial port.
 	port         io.ReadWriteCloser
 	lastActivity time.Time
 	closeTimer   *time.Timer
+	// transport is the framing name used in structured logs
+	transport string
+	// connected is set once the port has been opened
+	connected bool
 }
 
 func (mb *serialPort) Connect() (err error) {
@@ -47,12 +53,16 @@ func (mb *serialPort) Connect() (err error) {
 func (mb *serialPort) connect() error {
 	if mb.port == nil {
 		port, err := serial.OpenPort(&mb.Config)
-		fmt.Println(err, 1)
-
 		if err != nil {
 			return err
 		}
 		mb.port = port
+		if mb.connected {
+			mb.slogEvent("modbus: reconnected")
+		} else {
+			mb.slogEvent("modbus: connected", "baud", mb.Baud)
+		}
+		mb.connected = true
 	}
 	return nil
 }
@@ -79,6 +89,21 @@ func (mb *serialPort) logf(format string, v ...interface{}) {
 	}
 }
 
+func (mb *serialPort) transportName() string {
+	if mb.transport == "" {
+		return transportSerial
+	}
+	return mb.transport
+}
+
+func (mb *serialPort) slogFrame(msg string, adu []byte, duration time.Duration) {
+	slogFrame(mb.StructuredLogger, msg, mb.transportName(), mb.Name, adu, duration)
+}
+
+func (mb *serialPort) slogEvent(msg string, args ...any) {
+	slogEvent(mb.StructuredLogger, msg, mb.transportName(), mb.Name, args...)
+}
+
 func (mb *serialPort) startCloseTimer() {
 	if mb.IdleTimeout <= 0 {
 		return
@@ -101,6 +126,7 @@ func (mb *serialPort) closeIdle() {
 	idle := time.Now().Sub(mb.lastActivity)
 	if idle >= mb.IdleTimeout {
 		mb.logf("modbus: closing connection due to idle timeout: %v", idle)
+		mb.slogEvent("modbus: closing idle connection", "idle", idle)
 		mb.close()
 	}
 }
@@ -0,0 +1,206 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// SerialServer serves modbus requests received on a serial line in RTU or
+// ASCII framing, i.e. it acts as a slave device.
+type SerialServer struct {
+	// Transmission logger
+	Logger *log.Logger
+	// Structured logger, frames are logged at debug level
+	StructuredLogger *slog.Logger
+	// Observer is notified of every handled request
+	Observer Observer
+	// Capture records every request and response if set
+	Capture *CaptureWriter
+	// Faults injects faults in responses if set
+	Faults *FaultInjector
+	// Access denies clients and requests if set
+	Access *AccessControl
+
+	framing  Framing
+	name     string
+	baud     int
+	port     io.ReadWriteCloser
+	packager Packager
+}
+
+// NewSerialServer allocates a server reading requests from port, which can
+// be a serial port or the master side of a pseudo terminal.
+func NewSerialServer(port io.ReadWriteCloser, framing Framing) *SerialServer {
+	return &SerialServer{
+		framing:  framing,
+		name:     transportSerial,
+		port:     port,
+		packager: NewPackager(framing),
+	}
+}
+
+// OpenSerialServer opens the serial port of config and allocates a server
+// on it. ReadTimeout of config should be zero so that reads block.
+func OpenSerialServer(config *serial.Config, framing Framing) (*SerialServer, error) {
+	port, err := serial.OpenPort(config)
+	if err != nil {
+		return nil, err
+	}
+	mb := NewSerialServer(port, framing)
+	mb.name = config.Name
+	mb.baud = config.Baud
+	return mb, nil
+}
+
+// ServeModbus serves requests with handler until the port is closed or
+// fails, it returns the read error.
+func (mb *SerialServer) ServeModbus(handler mbHandler) error {
+	return mb.serve(mbServerHandler(handler, mb.name))
+}
+
+// ServeHandler is like ServeModbus with a Handler. The remote address of
+// requests is the name of the port.
+func (mb *SerialServer) ServeHandler(handler Handler) error {
+	return mb.serve(handlerServer(handler, mb.framing.String(), mb.name))
+}
+
+// Close closes the port.
+func (mb *SerialServer) Close() error {
+	return mb.port.Close()
+}
+
+func (mb *SerialServer) serve(handler serverHandler) error {
+	var readRequest func() ([]byte, error)
+	if mb.framing == FramingASCII {
+		readRequest = newASCIIFrameReader(mb.port).ReadFrame
+	} else {
+		frames := NewRTUFrameReader(mb.port, mb.baud)
+		frames.Expect = RequestFrames
+		readRequest = frames.ReadFrame
+	}
+	for {
+		aduRequest, err := readRequest()
+		if err != nil {
+			// A serial port with read timeout returns no data
+			if err == io.ErrNoProgress {
+				continue
+			}
+			return err
+		}
+		if err = mb.handle(handler, aduRequest); err != nil {
+			return err
+		}
+	}
+}
+
+// handle serves one request frame, it returns write errors only.
+func (mb *SerialServer) handle(handler serverHandler, aduRequest []byte) (err error) {
+	received := time.Now()
+	mb.logf("modbus: received % x", aduRequest)
+	slogFrame(mb.StructuredLogger, "modbus: received", mb.framing.String(), mb.name, aduRequest, 0)
+	if mb.Capture != nil {
+		mb.Capture.WriteSerial(mb.framing, false, aduRequest, received)
+	}
+	pdu, err := mb.packager.Decode(aduRequest)
+	if err != nil {
+		mb.logf("modbus: invalid request: %v", err)
+		return nil
+	}
+	o := observeRequest(mb.Observer, pdu, aduRequest, mb.name)
+	// Denied requests are not answered if dropped
+	resp, drop := mb.Access.deny(pdu, nil, mb.name)
+	if resp == nil && !drop {
+		resp = handler(pdu)
+	}
+	fault := mb.Faults.fault(pdu)
+	if fault != nil {
+		mb.logf("modbus: injecting fault %+v", *fault)
+		resp = fault.response(pdu, resp)
+	}
+	// No response to broadcast requests
+	if resp == nil || pdu.SlaveID == 0 {
+		observeResponse(mb.Observer, o, pdu, resp, nil, nil)
+		return nil
+	}
+	adu, err := mb.packager.Encode(resp)
+	if err == nil {
+		if fault != nil {
+			adu = fault.frame(adu, mb.framing)
+		}
+		mb.logf("modbus: sending % x", adu)
+		slogFrame(mb.StructuredLogger, "modbus: sending", mb.framing.String(), mb.name, adu, time.Since(received))
+		if fault != nil {
+			_, err = fault.write(mb.port, adu)
+		} else {
+			_, err = mb.port.Write(adu)
+		}
+		if mb.Capture != nil {
+			mb.Capture.WriteSerial(mb.framing, true, adu, time.Now())
+		}
+	}
+	observeResponse(mb.Observer, o, pdu, resp, adu, err)
+	return
+}
+
+func (mb *SerialServer) logf(format string, v ...interface{}) {
+	if mb.Logger != nil {
+		mb.Logger.Printf(format, v...)
+	}
+}
+
+// asciiFrameReader reads ASCII frames from a stream, a partial line is
+// kept when a read fails so that read timeouts do not break frames.
+type asciiFrameReader struct {
+	r       *bufio.Reader
+	line    []byte
+	skipped func(data []byte)
+}
+
+func newASCIIFrameReader(r io.Reader) *asciiFrameReader {
+	return &asciiFrameReader{r: bufio.NewReaderSize(r, asciiMaxSize*2)}
+}
+
+// ReadFrame reads the next line starting with a colon.
+func (f *asciiFrameReader) ReadFrame() ([]byte, error) {
+	for {
+		chunk, err := f.r.ReadSlice('\n')
+		f.line = append(f.line, chunk...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line := f.line
+		f.line = nil
+		start := bytes.LastIndex(line, []byte(asciiStart))
+		if start < 0 {
+			f.skip(line)
+			continue
+		}
+		f.skip(line[:start])
+		frame := line[start:]
+		if len(frame) < asciiMinSize+6 || len(frame)%2 != 1 || !bytes.HasSuffix(frame, []byte(asciiEnd)) {
+			f.skip(frame)
+			continue
+		}
+		return frame, nil
+	}
+}
+
+func (f *asciiFrameReader) skip(data []byte) {
+	if len(data) > 0 && f.skipped != nil {
+		f.skipped(data)
+	}
+}
@@ -0,0 +1,58 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestASCIIFrameReader(t *testing.T) {
+	var skipped []byte
+	r := newASCIIFrameReader(bytes.NewBufferString("garbage\r\nxx:0103006B000389\r\n"))
+	r.skipped = func(data []byte) {
+		skipped = append(skipped, data...)
+	}
+	adu, err := r.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ":0103006B000389\r\n" != string(adu) {
+		t.Fatalf("adu expected %q, actual %q", ":0103006B000389\r\n", adu)
+	}
+	if "garbage\r\nxx" != string(skipped) {
+		t.Fatalf("skipped expected %q, actual %q", "garbage\r\nxx", skipped)
+	}
+}
+
+func TestSerialServer(t *testing.T) {
+	model := NewDataModel(0, 0, 10, 0)
+	model.WriteMultipleRegisters(1, 0, []uint16{0x1234})
+	request := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}
+	port := &loopPort{Reader: bytes.NewReader(request)}
+	server := NewSerialServer(port, FramingRTU)
+	if err := server.ServeModbus(model); err != io.EOF {
+		t.Fatalf("error expected %v, actual %v", io.EOF, err)
+	}
+	expected := []byte{0x01, 0x03, 0x02, 0x12, 0x34, 0xB5, 0x33}
+	if !bytes.Equal(expected, port.Bytes()) {
+		t.Fatalf("response expected % x, actual % x", expected, port.Bytes())
+	}
+}
+
+// loopPort reads requests from Reader and records responses.
+type loopPort struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (p *loopPort) Read(b []byte) (int, error) {
+	return p.Reader.Read(b)
+}
+
+func (p *loopPort) Write(b []byte) (int, error) {
+	return p.Buffer.Write(b)
+}
+
+func (p *loopPort) Close() error {
+	return nil
+}
@@ -0,0 +1,508 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const simulatorInterval = time.Second
+
+// SimulatorConfig describes the units of a Simulator, it is usually loaded
+// from JSON with LoadSimulatorConfig:
+//
+//	{
+//	  "interval": "500ms",
+//	  "units": [{
+//	    "id": 1,
+//	    "holding_registers": 100,
+//	    "input_registers": 100,
+//	    "points": [
+//	      {"table": "input_registers", "address": 0, "type": "float32",
+//	       "generator": {"kind": "sine", "offset": 20, "amplitude": 5, "period": "1m"}},
+//	      {"table": "input_registers", "address": 2,
+//	       "generator": {"kind": "counter", "step": 1, "max": 999}}
+//	    ]
+//	  }]
+//	}
+type SimulatorConfig struct {
+	// Interval between updates of generated values, 1s if empty
+	Interval string          `json:"interval"`
+	Units    []SimulatorUnit `json:"units"`
+}
+
+// SimulatorUnit is a unit id with the sizes of its tables and its
+// generated points.
+type SimulatorUnit struct {
+	ID               byte             `json:"id"`
+	Coils            int              `json:"coils"`
+	DiscreteInputs   int              `json:"discrete_inputs"`
+	HoldingRegisters int              `json:"holding_registers"`
+	InputRegisters   int              `json:"input_registers"`
+	Points           []SimulatorPoint `json:"points"`
+}
+
+// SimulatorPoint is a value of a table updated by a generator. Values of
+// coils and discrete inputs are on when not zero.
+type SimulatorPoint struct {
+	Table   string `json:"table"`
+	Address uint16 `json:"address"`
+	// Value type of registers, uint16 if empty
+	Type string `json:"type"`
+	// Byte order of registers, abcd if empty
+	Order     string          `json:"order"`
+	Generator GeneratorConfig `json:"generator"`
+}
+
+// GeneratorConfig selects a generator and its parameters. Kind is one of:
+//
+//	constant     Value
+//	counter      Start, Step (1 if zero), Min and Max, wrapping to Min
+//	sine         Offset + Amplitude * sin(2π t / Period)
+//	random_walk  Start, moves by up to Step within Min and Max, Seed
+//	csv          replays Column (name or index) of File, one row per
+//	             Period (the simulator interval if empty), looping
+type GeneratorConfig struct {
+	Kind      string  `json:"kind"`
+	Value     float64 `json:"value"`
+	Start     float64 `json:"start"`
+	Step      float64 `json:"step"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Offset    float64 `json:"offset"`
+	Amplitude float64 `json:"amplitude"`
+	Period    string  `json:"period"`
+	Seed      int64   `json:"seed"`
+	File      string  `json:"file"`
+	Column    string  `json:"column"`
+}
+
+// LoadSimulatorConfig reads a JSON configuration, CSV files are relative
+// to the directory of the configuration.
+func LoadSimulatorConfig(path string) (config *SimulatorConfig, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	config = &SimulatorConfig{}
+	if err = json.Unmarshal(data, config); err != nil {
+		err = fmt.Errorf("modbus: invalid simulator configuration '%v': %v", path, err)
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	for i := range config.Units {
+		for j := range config.Units[i].Points {
+			g := &config.Units[i].Points[j].Generator
+			if g.File != "" && !filepath.IsAbs(g.File) {
+				g.File = filepath.Join(dir, g.File)
+			}
+		}
+	}
+	return
+}
+
+// Generator returns the value of a point at a time elapsed since the start
+// of a simulation. Next is called once per update with increasing times.
+type Generator interface {
+	Next(elapsed time.Duration) float64
+}
+
+// GeneratorFunc adapts a function to a Generator.
+type GeneratorFunc func(elapsed time.Duration) float64
+
+// Next calls f.
+func (f GeneratorFunc) Next(elapsed time.Duration) float64 {
+	return f(elapsed)
+}
+
+// NewGenerator allocates the generator of config, interval is the update
+// interval of the simulation.
+func NewGenerator(config *GeneratorConfig, interval time.Duration) (Generator, error) {
+	var period time.Duration
+	if config.Period != "" {
+		var err error
+		if period, err = time.ParseDuration(config.Period); err != nil || period <= 0 {
+			return nil, fmt.Errorf("modbus: invalid generator period '%v'", config.Period)
+		}
+	}
+	switch config.Kind {
+	case "constant", "":
+		value := config.Value
+		return GeneratorFunc(func(time.Duration) float64 { return value }), nil
+	case "counter":
+		return newCounter(config), nil
+	case "sine":
+		if period == 0 {
+			return nil, fmt.Errorf("modbus: sine generator requires a period")
+		}
+		offset, amplitude := config.Offset, config.Amplitude
+		return GeneratorFunc(func(elapsed time.Duration) float64 {
+			return offset + amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(period))
+		}), nil
+	case "random_walk":
+		return newRandomWalk(config), nil
+	case "csv":
+		if period == 0 {
+			period = interval
+		}
+		return newCSVReplay(config.File, config.Column, period)
+	}
+	return nil, fmt.Errorf("modbus: unknown generator '%v', expected constant, counter, sine, random_walk or csv", config.Kind)
+}
+
+// newCounter returns a generator incrementing by step on every update.
+func newCounter(config *GeneratorConfig) Generator {
+	step := config.Step
+	if step == 0 {
+		step = 1
+	}
+	min, max := config.Min, config.Max
+	value, started := config.Start, false
+	return GeneratorFunc(func(time.Duration) float64 {
+		if started {
+			value += step
+		}
+		started = true
+		if max > min && (value > max || value < min) {
+			if step > 0 {
+				value = min
+			} else {
+				value = max
+			}
+		}
+		return value
+	})
+}
+
+// newRandomWalk returns a generator moving by a random amount of up to
+// step on every update, bounded by min and max if max > min.
+func newRandomWalk(config *GeneratorConfig) Generator {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed))
+	step := config.Step
+	if step == 0 {
+		step = 1
+	}
+	min, max := config.Min, config.Max
+	value, started := config.Start, false
+	return GeneratorFunc(func(time.Duration) float64 {
+		if started {
+			value += (2*r.Float64() - 1) * step
+		}
+		started = true
+		if max > min {
+			value = math.Max(min, math.Min(max, value))
+		}
+		return value
+	})
+}
+
+// newCSVReplay reads a column of a CSV file and returns a generator
+// replaying one row per period. The column is a header name or an index,
+// the first column if empty. A first row which is not numeric is a header.
+func newCSVReplay(path, column string, period time.Duration) (Generator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	index, err := strconv.Atoi(column)
+	if column == "" {
+		index, err = 0, nil
+	}
+	var values []float64
+	for line := 1; ; line++ {
+		record, err2 := r.Read()
+		if err2 == io.EOF {
+			break
+		}
+		if err2 != nil {
+			return nil, fmt.Errorf("modbus: %v: %v", path, err2)
+		}
+		if line == 1 && err != nil {
+			// Look up the column name in the header
+			for i, name := range record {
+				if name == column {
+					index, err = i, nil
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("modbus: %v: unknown column '%v'", path, column)
+			}
+			continue
+		}
+		if index >= len(record) {
+			return nil, fmt.Errorf("modbus: %v:%v: missing column %v", path, line, index)
+		}
+		v, err2 := strconv.ParseFloat(record[index], 64)
+		if err2 != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("modbus: %v:%v: invalid value '%v'", path, line, record[index])
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("modbus: %v: no values", path)
+	}
+	return GeneratorFunc(func(elapsed time.Duration) float64 {
+		return values[int(elapsed/period)%len(values)]
+	}), nil
+}
+
+// simulatorPoint is a point of a unit with its generator.
+type simulatorPoint struct {
+	model     *DataModel
+	table     Table
+	address   uint16
+	typ       ValueType
+	order     ValueOrder
+	generator Generator
+}
+
+// Simulator serves units whose values are updated by generators, e.g. to
+// develop against a moving plant rather than static values. Each unit has
+// its own DataModel, requests to other unit ids are not answered and
+// broadcast writes are applied to all units.
+type Simulator struct {
+	// Interval between updates
+	Interval time.Duration
+
+	mu     sync.Mutex
+	units  map[byte]*DataModel
+	points []*simulatorPoint
+	stop   chan struct{}
+}
+
+// NewSimulator allocates a simulator with the units of config.
+func NewSimulator(config *SimulatorConfig) (*Simulator, error) {
+	s := &Simulator{
+		Interval: simulatorInterval,
+		units:    make(map[byte]*DataModel),
+	}
+	if config.Interval != "" {
+		interval, err := time.ParseDuration(config.Interval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("modbus: invalid simulator interval '%v'", config.Interval)
+		}
+		s.Interval = interval
+	}
+	for i := range config.Units {
+		unit := &config.Units[i]
+		if _, ok := s.units[unit.ID]; ok || unit.ID == 0 {
+			return nil, fmt.Errorf("modbus: invalid or duplicate unit id '%v'", unit.ID)
+		}
+		model := NewDataModel(unit.Coils, unit.DiscreteInputs, unit.HoldingRegisters, unit.InputRegisters)
+		s.units[unit.ID] = model
+		for j := range unit.Points {
+			p := &unit.Points[j]
+			table, err := ParseTable(p.Table)
+			if err != nil {
+				return nil, err
+			}
+			typ := TypeUint16
+			if p.Type != "" {
+				if typ, err = ParseValueType(p.Type); err != nil {
+					return nil, err
+				}
+			}
+			order, err := ParseValueOrder(p.Order)
+			if err != nil {
+				return nil, err
+			}
+			g, err := NewGenerator(&p.Generator, s.Interval)
+			if err != nil {
+				return nil, fmt.Errorf("modbus: unit %v %v %v: %v", unit.ID, table, p.Address, err)
+			}
+			if err = s.AddPoint(unit.ID, table, p.Address, typ, order, g); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// Unit returns the data model of a unit, nil if the unit does not exist.
+func (s *Simulator) Unit(id byte) *DataModel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.units[id]
+}
+
+// AddPoint updates a value of a table of unit with generator. Values of
+// coils and discrete inputs ignore typ and order.
+func (s *Simulator) AddPoint(unit byte, table Table, address uint16, typ ValueType, order ValueOrder, generator Generator) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	model, ok := s.units[unit]
+	if !ok {
+		return fmt.Errorf("modbus: unknown unit '%v'", unit)
+	}
+	size := typ.Registers()
+	if table == TableCoils || table == TableDiscreteInputs {
+		size = 1
+	}
+	if !inRange(model.size(table), address, uint16(size)) {
+		return fmt.Errorf("modbus: unit %v %v %v: address out of table", unit, table, address)
+	}
+	s.points = append(s.points, &simulatorPoint{
+		model:     model,
+		table:     table,
+		address:   address,
+		typ:       typ,
+		order:     order,
+		generator: generator,
+	})
+	return nil
+}
+
+// Update sets all points to the values of their generators at elapsed.
+func (s *Simulator) Update(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.points {
+		v := p.generator.Next(elapsed)
+		switch p.table {
+		case TableCoils:
+			p.model.WriteSingleCoil(0, p.address, v != 0)
+		case TableDiscreteInputs:
+			p.model.WriteDiscreteInputs(p.address, []bool{v != 0})
+		case TableHoldingRegisters:
+			p.model.WriteMultipleRegisters(0, p.address, encodeFloat(v, p.typ, p.order))
+		case TableInputRegisters:
+			p.model.WriteInputRegisters(p.address, encodeFloat(v, p.typ, p.order))
+		}
+	}
+}
+
+// Start updates the points now then every Interval until Stop is called.
+func (s *Simulator) Start() {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.stop = make(chan struct{})
+	stop := s.stop
+	s.mu.Unlock()
+	// The goroutine of a previous Start may still be running
+	start := time.Now()
+	s.Update(0)
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.Update(now.Sub(start))
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the updates started by Start.
+func (s *Simulator) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// ServeTCP serves the units on server until its listener is closed.
+func (s *Simulator) ServeTCP(server *TcpServer) {
+	server.serveContext(context.Background(), func(c net.Conn) serverHandler {
+		return s.handler(c.RemoteAddr().String())
+	})
+}
+
+// ServeSerial serves the units on server until its port is closed or
+// fails, it returns the read error.
+func (s *Simulator) ServeSerial(server *SerialServer) error {
+	return server.serve(s.handler(server.name))
+}
+
+// handler dispatches the requests of remote to the data model of their
+// unit.
+func (s *Simulator) handler(remote string) serverHandler {
+	return func(pdu *PDUwithSlaveid) *PDUwithSlaveid {
+		if pdu.SlaveID == 0 {
+			s.mu.Lock()
+			ids := make([]int, 0, len(s.units))
+			for id := range s.units {
+				ids = append(ids, int(id))
+			}
+			s.mu.Unlock()
+			sort.Ints(ids)
+			for _, id := range ids {
+				request := *pdu
+				request.SlaveID = byte(id)
+				mbServerHandler(s.Unit(byte(id)), remote)(&request)
+			}
+			return nil
+		}
+		model := s.Unit(pdu.SlaveID)
+		if model == nil {
+			return nil
+		}
+		return mbServerHandler(model, remote)(pdu)
+	}
+}
+
+// encodeFloat returns the registers of v converted to typ. Integers are
+// rounded and saturated to the range of typ.
+func encodeFloat(v float64, typ ValueType, order ValueOrder) []uint16 {
+	b := make([]byte, 2*typ.Registers())
+	switch typ {
+	case TypeFloat32:
+		binary.BigEndian.PutUint32(b, math.Float32bits(float32(v)))
+	case TypeFloat64:
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+	case TypeInt16, TypeInt32, TypeInt64:
+		bits := uint(16 * typ.Registers())
+		max := math.Ldexp(1, int(bits)-1)
+		v = math.Max(-max, math.Min(max-1, math.Round(v)))
+		if typ == TypeInt64 && v >= max-1 {
+			putUint(b, math.MaxInt64)
+		} else {
+			putUint(b, uint64(int64(v)))
+		}
+	default:
+		bits := uint(16 * typ.Registers())
+		max := math.Ldexp(1, int(bits))
+		v = math.Max(0, math.Min(max-1, math.Round(v)))
+		if typ == TypeUint64 && v >= max-1 {
+			putUint(b, math.MaxUint64)
+		} else {
+			putUint(b, uint64(v))
+		}
+	}
+	b = order.reorder(b)
+	return registerValues(b, len(b)/2)
+}
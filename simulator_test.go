@@ -0,0 +1,193 @@
+package modbus
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func generate(t *testing.T, config *GeneratorConfig, times ...time.Duration) []float64 {
+	g, err := NewGenerator(config, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]float64, len(times))
+	for i, elapsed := range times {
+		values[i] = g.Next(elapsed)
+	}
+	return values
+}
+
+func TestGenerators(t *testing.T) {
+	s := time.Second
+	values := generate(t, &GeneratorConfig{Kind: "constant", Value: 7}, 0, s)
+	if !reflect.DeepEqual([]float64{7, 7}, values) {
+		t.Errorf("constant: %v", values)
+	}
+	values = generate(t, &GeneratorConfig{Kind: "counter", Start: 8, Step: 1, Min: 5, Max: 10}, 0, s, 2*s, 3*s, 4*s)
+	if !reflect.DeepEqual([]float64{8, 9, 10, 5, 6}, values) {
+		t.Errorf("counter: %v", values)
+	}
+	values = generate(t, &GeneratorConfig{Kind: "sine", Offset: 10, Amplitude: 2, Period: "4s"}, 0, s, 2*s, 3*s)
+	expected := []float64{10, 12, 10, 8}
+	for i := range values {
+		if d := values[i] - expected[i]; d > 1e-9 || d < -1e-9 {
+			t.Errorf("sine: expected %v, actual %v", expected, values)
+			break
+		}
+	}
+	values = generate(t, &GeneratorConfig{Kind: "random_walk", Start: 50, Step: 10, Min: 45, Max: 55, Seed: 1}, 0, s, 2*s, 3*s, 4*s, 5*s)
+	if values[0] != 50 {
+		t.Errorf("random walk: first value %v", values[0])
+	}
+	for _, v := range values {
+		if v < 45 || v > 55 {
+			t.Errorf("random walk: %v out of bounds", values)
+		}
+	}
+	if _, err := NewGenerator(&GeneratorConfig{Kind: "square"}, s); err == nil {
+		t.Error("unknown generator: error expected")
+	}
+	if _, err := NewGenerator(&GeneratorConfig{Kind: "sine"}, s); err == nil {
+		t.Error("sine without period: error expected")
+	}
+}
+
+func TestCSVGenerator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plant.csv")
+	if err := os.WriteFile(path, []byte("time,level,flow\n0,1.5,10\n1,2.5,20\n2,3.5,30\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := time.Second
+	values := generate(t, &GeneratorConfig{Kind: "csv", File: path, Column: "flow"}, 0, s, 2*s, 3*s)
+	if !reflect.DeepEqual([]float64{10, 20, 30, 10}, values) {
+		t.Errorf("column name: %v", values)
+	}
+	values = generate(t, &GeneratorConfig{Kind: "csv", File: path, Column: "1", Period: "2s"}, 0, s, 2*s, 4*s)
+	if !reflect.DeepEqual([]float64{1.5, 1.5, 2.5, 3.5}, values) {
+		t.Errorf("column index: %v", values)
+	}
+	if _, err := NewGenerator(&GeneratorConfig{Kind: "csv", File: path, Column: "pressure"}, s); err == nil {
+		t.Error("unknown column: error expected")
+	}
+}
+
+func TestEncodeFloat(t *testing.T) {
+	cases := []struct {
+		v        float64
+		typ      ValueType
+		order    ValueOrder
+		expected []uint16
+	}{
+		{12.6, TypeUint16, ValueOrder{}, []uint16{13}},
+		{-3, TypeUint16, ValueOrder{}, []uint16{0}},
+		{70000, TypeUint16, ValueOrder{}, []uint16{0xFFFF}},
+		{-2, TypeInt16, ValueOrder{}, []uint16{0xFFFE}},
+		{-40000, TypeInt16, ValueOrder{}, []uint16{0x8000}},
+		{0x12345678, TypeUint32, ValueOrder{SwapWords: true}, []uint16{0x5678, 0x1234}},
+		{1, TypeFloat32, ValueOrder{}, []uint16{0x3F80, 0x0000}},
+		{1e30, TypeInt64, ValueOrder{}, []uint16{0x7FFF, 0xFFFF, 0xFFFF, 0xFFFF}},
+	}
+	for _, c := range cases {
+		actual := encodeFloat(c.v, c.typ, c.order)
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Errorf("%v %v %v: expected %04x, actual %04x", c.v, c.typ, c.order, c.expected, actual)
+		}
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	dir := t.TempDir()
+	config := `{
+  "interval": "10ms",
+  "units": [
+    {"id": 1, "coils": 8, "holding_registers": 10, "input_registers": 10,
+     "points": [
+      {"table": "input_registers", "address": 0, "type": "float32", "order": "cdab",
+       "generator": {"kind": "constant", "value": 1}},
+      {"table": "input_registers", "address": 2, "generator": {"kind": "counter"}},
+      {"table": "coils", "address": 3, "generator": {"value": 1}}
+     ]},
+    {"id": 2, "holding_registers": 4,
+     "points": [{"table": "holding_registers", "address": 1, "generator": {"kind": "csv", "file": "values.csv"}}]}
+  ]
+}`
+	path := filepath.Join(dir, "plant.json")
+	os.WriteFile(path, []byte(config), 0600)
+	os.WriteFile(filepath.Join(dir, "values.csv"), []byte("100\n200\n"), 0600)
+	c, err := LoadSimulatorConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim, err := NewSimulator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.Interval != 10*time.Millisecond {
+		t.Fatalf("unexpected interval %v", sim.Interval)
+	}
+	sim.Update(0)
+	sim.Update(10 * time.Millisecond)
+
+	client := NewClient(newLoopbackHandler(FramingRTU, sim.handler("")))
+	results, err := client.ReadInputRegisters(1, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 0, 0x3F, 0x80, 0, 1}; !reflect.DeepEqual(expected, results) {
+		t.Fatalf("input registers expected % x, actual % x", expected, results)
+	}
+	if results, err = client.ReadCoils(1, 0, 8); err != nil || results[0] != 0x08 {
+		t.Fatalf("coils % x, %v", results, err)
+	}
+	if results, err = client.ReadHoldingRegisters(2, 0, 2); err != nil || !reflect.DeepEqual([]byte{0, 0, 0, 200}, results) {
+		t.Fatalf("unit 2 holding registers % x, %v", results, err)
+	}
+	// Unknown units do not answer
+	if _, err = client.ReadHoldingRegisters(3, 0, 1); err == nil {
+		t.Fatal("unknown unit: error expected")
+	}
+	// Broadcast writes are applied to every unit
+	client.WriteSingleRegister(0, 3, 42)
+	for _, unit := range []byte{1, 2} {
+		if values, _ := sim.Unit(unit).ReadHoldingRegisters(unit, 3, 1); values[0] != 42 {
+			t.Errorf("unit %v: broadcast not applied, %v", unit, values)
+		}
+	}
+}
+
+func TestSimulatorRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plant.json")
+	os.WriteFile(path, []byte(`{"interval": "1ms", "units": [{"id": 1, "holding_registers": 1,
+  "points": [{"table": "holding_registers", "address": 0, "generator": {"kind": "counter"}}]}]}`), 0600)
+	c, err := LoadSimulatorConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim, err := NewSimulator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The updates of a stopped simulator may overlap the next start
+	for i := 0; i < 100; i++ {
+		sim.Start()
+		time.Sleep(time.Millisecond)
+		sim.Stop()
+	}
+}
+
+func TestSimulatorConfigErrors(t *testing.T) {
+	cases := []SimulatorConfig{
+		{Interval: "often"},
+		{Units: []SimulatorUnit{{ID: 1}, {ID: 1}}},
+		{Units: []SimulatorUnit{{ID: 1, Points: []SimulatorPoint{{Table: "registers"}}}}},
+		{Units: []SimulatorUnit{{ID: 1, HoldingRegisters: 1, Points: []SimulatorPoint{{Table: "holding_registers", Type: "float32"}}}}},
+	}
+	for i := range cases {
+		if _, err := NewSimulator(&cases[i]); err == nil {
+			t.Errorf("%+v: error expected", cases[i])
+		}
+	}
+}
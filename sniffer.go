@@ -0,0 +1,333 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+const (
+	snifferTimeout     = time.Second
+	snifferReadTimeout = 100 * time.Millisecond
+)
+
+var (
+	// ErrMissingResponse is reported for a request which is not followed
+	// by its response.
+	ErrMissingResponse = errors.New("modbus: missing response")
+	// ErrInvalidFrame is reported for bytes which are not a valid frame,
+	// e.g. a frame with a bad CRC or LRC.
+	ErrInvalidFrame = errors.New("modbus: invalid frame")
+
+	errSnifferTimeout = errors.New("modbus: read timeout")
+)
+
+// SniffedTransaction is a transaction seen on a serial line.
+type SniffedTransaction struct {
+	// Time the request, or the frame reported, was received
+	Time time.Time
+	// Application data units, one of them may be missing
+	Request  []byte
+	Response []byte
+	// Duration between the request and the response
+	Duration time.Duration
+
+	SlaveID      byte
+	FunctionCode byte
+	Address      uint16
+	Quantity     uint16
+	// Values are the registers or bits written by the request or read by
+	// the response, a bit is 0 or 1. For function 22 they are the AND and
+	// OR masks, for function 23 the registers read.
+	Values []uint16
+
+	// Err is a *ModbusError for an exception response, ErrMissingResponse
+	// or ErrInvalidFrame. Request is nil for an exception response which
+	// does not follow its request.
+	Err error
+}
+
+// String formats the transaction on one line.
+func (t *SniffedTransaction) String() string {
+	var b strings.Builder
+	b.WriteString(t.Time.Format("15:04:05.000"))
+	if errors.Is(t.Err, ErrInvalidFrame) {
+		fmt.Fprintf(&b, " invalid frame % x", t.Request)
+		return b.String()
+	}
+	fmt.Fprintf(&b, " slave %v %v", t.SlaveID, FunctionName(t.FunctionCode))
+	if t.Quantity > 0 {
+		fmt.Fprintf(&b, " address %v quantity %v", t.Address, t.Quantity)
+	} else if t.Request != nil {
+		fmt.Fprintf(&b, " address %v", t.Address)
+	}
+	if len(t.Values) > 0 {
+		fmt.Fprintf(&b, " values %v", t.Values)
+	}
+	if t.Response != nil && t.Request != nil {
+		fmt.Fprintf(&b, " in %v", t.Duration.Round(time.Millisecond))
+	}
+	if t.Err != nil {
+		fmt.Fprintf(&b, ": %v", t.Err)
+	}
+	return b.String()
+}
+
+// Sniffer decodes the traffic of a serial line in both directions without
+// ever writing to it, and pairs requests with their responses.
+type Sniffer struct {
+	// Timeout after which a request without response is reported
+	Timeout time.Duration
+	// Handler is called with every transaction
+	Handler func(t *SniffedTransaction)
+
+	framing  Framing
+	port     io.Reader
+	closer   io.Closer
+	baud     int
+	packager Packager
+	// request is waiting for its response, pdu is its decoded form
+	request *SniffedTransaction
+	pdu     *PDUwithSlaveid
+}
+
+// NewSniffer allocates a sniffer of the traffic read from port.
+func NewSniffer(port io.Reader, framing Framing, handler func(t *SniffedTransaction)) *Sniffer {
+	mb := &Sniffer{
+		Timeout:  snifferTimeout,
+		Handler:  handler,
+		framing:  framing,
+		port:     port,
+		packager: NewPackager(framing),
+	}
+	mb.closer, _ = port.(io.Closer)
+	return mb
+}
+
+// OpenSniffer opens the serial port of config and allocates a sniffer on
+// it. The port is only read from. A short read timeout is set if config has
+// none, so that missing responses are reported while the line is silent.
+func OpenSniffer(config *serial.Config, framing Framing, handler func(t *SniffedTransaction)) (*Sniffer, error) {
+	c := *config
+	if c.ReadTimeout <= 0 {
+		c.ReadTimeout = snifferReadTimeout
+	}
+	port, err := serial.OpenPort(&c)
+	if err != nil {
+		return nil, err
+	}
+	mb := NewSniffer(&timeoutReader{port}, framing, handler)
+	mb.closer = port
+	mb.baud = c.Baud
+	return mb, nil
+}
+
+// Close closes the port if it is an io.Closer, Run then returns.
+func (mb *Sniffer) Close() error {
+	if mb.closer == nil {
+		return nil
+	}
+	return mb.closer.Close()
+}
+
+// Run decodes frames until the port fails, ends or is closed. A request
+// waiting for its response is reported as missing before Run returns.
+func (mb *Sniffer) Run() error {
+	var readFrame func() ([]byte, error)
+	if mb.framing == FramingASCII {
+		frames := newASCIIFrameReader(mb.port)
+		frames.skipped = mb.invalid
+		readFrame = frames.ReadFrame
+	} else {
+		frames := NewRTUFrameReader(mb.port, mb.baud)
+		frames.Skipped = mb.invalid
+		readFrame = frames.ReadFrame
+	}
+	for {
+		adu, err := readFrame()
+		now := time.Now()
+		if err != nil {
+			if err == errSnifferTimeout || err == io.ErrNoProgress {
+				mb.expire(now)
+				continue
+			}
+			mb.flush()
+			if err == io.EOF || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		mb.frame(adu, now)
+	}
+}
+
+// frame pairs a frame with the pending request.
+func (mb *Sniffer) frame(adu []byte, now time.Time) {
+	mb.expire(now)
+	pdu, err := mb.packager.Decode(adu)
+	if err != nil {
+		mb.invalid(adu)
+		return
+	}
+	if mb.request != nil {
+		request := mb.pdu
+		if pdu.SlaveID == request.SlaveID && pdu.FunctionCode&^0x80 == request.FunctionCode {
+			t := mb.request
+			mb.request = nil
+			t.Response = adu
+			t.Duration = now.Sub(t.Time)
+			if pdu.FunctionCode != request.FunctionCode {
+				t.Err = responseError(pdu)
+			} else {
+				t.Values = responseValues(request, pdu, t.Values)
+			}
+			mb.emit(t)
+			return
+		}
+		mb.flush()
+	}
+	t := &SniffedTransaction{
+		Time:         now,
+		SlaveID:      pdu.SlaveID,
+		FunctionCode: pdu.FunctionCode,
+	}
+	if pdu.FunctionCode&0x80 != 0 {
+		t.Response = adu
+		t.FunctionCode &^= 0x80
+		t.Err = responseError(pdu)
+		mb.emit(t)
+		return
+	}
+	t.Request = adu
+	t.Address, t.Quantity = requestAddressQuantity(&pdu.ProtocolDataUnit)
+	t.Values = requestValues(pdu)
+	// No response to broadcast requests
+	if pdu.SlaveID == 0 {
+		mb.emit(t)
+		return
+	}
+	mb.request = t
+	mb.pdu = pdu
+}
+
+// expire reports the pending request if it waited longer than Timeout.
+func (mb *Sniffer) expire(now time.Time) {
+	if mb.request != nil && mb.Timeout > 0 && now.Sub(mb.request.Time) > mb.Timeout {
+		mb.flush()
+	}
+}
+
+// flush reports the pending request as missing its response.
+func (mb *Sniffer) flush() {
+	if mb.request == nil {
+		return
+	}
+	t := mb.request
+	mb.request = nil
+	t.Err = ErrMissingResponse
+	mb.emit(t)
+}
+
+func (mb *Sniffer) invalid(data []byte) {
+	mb.emit(&SniffedTransaction{
+		Time:    time.Now(),
+		Request: append([]byte(nil), data...),
+		Err:     ErrInvalidFrame,
+	})
+}
+
+func (mb *Sniffer) emit(t *SniffedTransaction) {
+	if mb.Handler != nil {
+		mb.Handler(t)
+	}
+}
+
+// requestValues returns the values written by a request.
+func requestValues(pdu *PDUwithSlaveid) []uint16 {
+	data := pdu.Data
+	switch pdu.FunctionCode {
+	case FuncCodeWriteSingleCoil:
+		if len(data) == 4 {
+			return []uint16{binary.BigEndian.Uint16(data[2:]) >> 15}
+		}
+	case FuncCodeWriteSingleRegister:
+		if len(data) == 4 {
+			return []uint16{binary.BigEndian.Uint16(data[2:])}
+		}
+	case FuncCodeMaskWriteRegister:
+		if len(data) == 6 {
+			return registerValues(data[2:], 2)
+		}
+	case FuncCodeWriteMultipleCoils:
+		if len(data) > 5 && len(data[5:]) == int(data[4]) {
+			quantity := int(binary.BigEndian.Uint16(data[2:]))
+			return bitValues(data[5:], quantity)
+		}
+	case FuncCodeWriteMultipleRegisters:
+		if len(data) > 5 && len(data[5:]) == int(data[4]) {
+			return registerValues(data[5:], len(data[5:])/2)
+		}
+	}
+	return nil
+}
+
+// responseValues returns the values read by a response, or values if the
+// response has none.
+func responseValues(request, response *PDUwithSlaveid, values []uint16) []uint16 {
+	data := response.Data
+	_, quantity := requestAddressQuantity(&request.ProtocolDataUnit)
+	switch response.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		if len(data) > 1 && len(data[1:]) == int(data[0]) {
+			return bitValues(data[1:], int(quantity))
+		}
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeReadWriteMultipleRegisters:
+		if len(data) > 1 && len(data[1:]) == int(data[0]) {
+			return registerValues(data[1:], len(data[1:])/2)
+		}
+	case FuncCodeReadFIFOQueue:
+		if len(data) >= 4 {
+			return registerValues(data[4:], len(data[4:])/2)
+		}
+	}
+	return values
+}
+
+// bitValues returns quantity bits of data as 0 or 1.
+func bitValues(data []byte, quantity int) []uint16 {
+	if quantity > len(data)*8 {
+		quantity = len(data) * 8
+	}
+	values := make([]uint16, quantity)
+	for i, v := range unpackBits(data, quantity) {
+		if v {
+			values[i] = 1
+		}
+	}
+	return values
+}
+
+// timeoutReader reports the read timeout of a serial port, which returns
+// no data, as errSnifferTimeout.
+type timeoutReader struct {
+	r io.Reader
+}
+
+func (r *timeoutReader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+	if n == 0 && (err == nil || err == io.EOF) {
+		err = errSnifferTimeout
+	}
+	return
+}
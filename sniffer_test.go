@@ -0,0 +1,91 @@
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func sniff(t *testing.T, framing Framing, pdus ...*PDUwithSlaveid) []*SniffedTransaction {
+	packager := NewPackager(framing)
+	var stream bytes.Buffer
+	for _, pdu := range pdus {
+		if pdu == nil {
+			// Noise
+			stream.Write([]byte{0x01, 0x03, 0x02, 0x00, 0x01, 0xFF, 0xFF})
+			continue
+		}
+		adu, err := packager.Encode(pdu)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Write(adu)
+	}
+	var transactions []*SniffedTransaction
+	sniffer := NewSniffer(&stream, framing, func(t *SniffedTransaction) {
+		transactions = append(transactions, t)
+	})
+	if err := sniffer.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return transactions
+}
+
+func sniffedPDU(slaveID, functionCode byte, data ...byte) *PDUwithSlaveid {
+	return &PDUwithSlaveid{slaveID, ProtocolDataUnit{functionCode, data}}
+}
+
+func TestSniffer(t *testing.T) {
+	for _, framing := range []Framing{FramingRTU, FramingASCII} {
+		transactions := sniff(t, framing,
+			sniffedPDU(1, FuncCodeReadHoldingRegisters, 0x00, 0x6B, 0x00, 0x02),
+			sniffedPDU(1, FuncCodeReadHoldingRegisters, 0x04, 0x12, 0x34, 0x56, 0x78),
+			sniffedPDU(2, FuncCodeWriteMultipleCoils, 0x00, 0x13, 0x00, 0x0A, 0x02, 0xCD, 0x01),
+			sniffedPDU(3, FuncCodeReadCoils, 0x00, 0x00, 0x00, 0x01),
+			sniffedPDU(3, FuncCodeReadCoils|0x80, ExceptionCodeIllegalDataAddress),
+			sniffedPDU(0, FuncCodeWriteSingleRegister, 0x00, 0x01, 0x00, 0x03),
+		)
+		if len(transactions) != 4 {
+			t.Fatalf("%v: transactions expected %v, actual %v", framing, 4, len(transactions))
+		}
+		read := transactions[0]
+		if read.SlaveID != 1 || read.Address != 0x6B || read.Quantity != 2 || read.Err != nil || read.Response == nil {
+			t.Fatalf("%v: unexpected read %+v", framing, read)
+		}
+		if !reflect.DeepEqual([]uint16{0x1234, 0x5678}, read.Values) {
+			t.Fatalf("%v: values expected %v, actual %v", framing, []uint16{0x1234, 0x5678}, read.Values)
+		}
+		write := transactions[1]
+		if write.Err != ErrMissingResponse || write.Response != nil {
+			t.Fatalf("%v: unexpected write %+v", framing, write)
+		}
+		if !reflect.DeepEqual([]uint16{1, 0, 1, 1, 0, 0, 1, 1, 1, 0}, write.Values) {
+			t.Fatalf("%v: values expected %v, actual %v", framing, []uint16{1, 0, 1, 1, 0, 0, 1, 1, 1, 0}, write.Values)
+		}
+		exception, ok := transactions[2].Err.(*ModbusError)
+		if !ok || exception.ExceptionCode != ExceptionCodeIllegalDataAddress {
+			t.Fatalf("%v: exception expected, actual %v", framing, transactions[2].Err)
+		}
+		broadcast := transactions[3]
+		if broadcast.SlaveID != 0 || broadcast.Err != nil || !reflect.DeepEqual([]uint16{3}, broadcast.Values) {
+			t.Fatalf("%v: unexpected broadcast %+v", framing, broadcast)
+		}
+	}
+}
+
+func TestSnifferInvalidFrame(t *testing.T) {
+	transactions := sniff(t, FramingRTU,
+		sniffedPDU(1, FuncCodeReadHoldingRegisters, 0x00, 0x00, 0x00, 0x01),
+		nil,
+		sniffedPDU(1, FuncCodeReadHoldingRegisters, 0x02, 0x00, 0x01),
+	)
+	if len(transactions) != 2 {
+		t.Fatalf("transactions expected %v, actual %v", 2, len(transactions))
+	}
+	if transactions[0].Err != ErrInvalidFrame {
+		t.Fatalf("error expected %v, actual %v", ErrInvalidFrame, transactions[0].Err)
+	}
+	if transactions[1].Err != nil || !reflect.DeepEqual([]uint16{1}, transactions[1].Values) {
+		t.Fatalf("unexpected transaction %+v", transactions[1])
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -136,12 +137,19 @@ type tcpTransporter struct {
 	IdleTimeout time.Duration
 	// Transmission logger
 	Logger *log.Logger
+	// Structured logger, frames are logged at debug level and
+	// connection events at info level
+	StructuredLogger *slog.Logger
 
 	// TCP connection
 	mu           sync.Mutex
 	conn         net.Conn
 	closeTimer   *time.Timer
 	lastActivity time.Time
+	// connected is set once the first connection is established
+	connected bool
+	// dial opens the connection instead of the network dialer if set
+	dial func() (net.Conn, error)
 }
 
 // Send sends data to server and ensures response length is greater than header length.
@@ -166,6 +174,7 @@ func (mb *tcpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error
 	}
 	// Send data
 	mb.logf("modbus: sending % x", aduRequest)
+	slogFrame(mb.StructuredLogger, "modbus: sending", transportTCP, mb.Address, aduRequest, 0)
 	if _, err = mb.conn.Write(aduRequest); err != nil {
 		return
 	}
@@ -193,6 +202,7 @@ func (mb *tcpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error
 	}
 	aduResponse = data[:length]
 	mb.logf("modbus: received % x\n", aduResponse)
+	slogFrame(mb.StructuredLogger, "modbus: received", transportTCP, mb.Address, aduResponse, time.Since(mb.lastActivity))
 	return
 }
 
@@ -207,12 +217,24 @@ func (mb *tcpTransporter) Connect() error {
 
 func (mb *tcpTransporter) connect() error {
 	if mb.conn == nil {
-		dialer := net.Dialer{Timeout: mb.Timeout}
-		conn, err := dialer.Dial("tcp", mb.Address)
+		var conn net.Conn
+		var err error
+		if mb.dial != nil {
+			conn, err = mb.dial()
+		} else {
+			dialer := net.Dialer{Timeout: mb.Timeout}
+			conn, err = dialer.Dial("tcp", mb.Address)
+		}
 		if err != nil {
 			return err
 		}
 		mb.conn = conn
+		if mb.connected {
+			slogEvent(mb.StructuredLogger, "modbus: reconnected", transportTCP, mb.Address)
+		} else {
+			slogEvent(mb.StructuredLogger, "modbus: connected", transportTCP, mb.Address)
+		}
+		mb.connected = true
 	}
 	return nil
 }
@@ -278,6 +300,7 @@ func (mb *tcpTransporter) closeIdle() {
 	idle := time.Now().Sub(mb.lastActivity)
 	if idle >= mb.IdleTimeout {
 		mb.logf("modbus: closing connection due to idle timeout: %v", idle)
+		slogEvent(mb.StructuredLogger, "modbus: closing idle connection", transportTCP, mb.Address, "idle", idle)
 		mb.close()
 	}
 }
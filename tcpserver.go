@@ -1,46 +1,122 @@
 package modbus
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net"
 	"strconv"
 	"sync"
 	"time"
 )
 
-// tcpTransporter implements Transporter interface.
+// TcpServer serves Modbus/TCP clients.
 type TcpServer struct {
-	// Connect string
-	port     int
 	packager tcpPackager
-	// Connect & Read timeout
+	// Read timeout of a request once its header is received, and write
+	// timeout of its response
 	Timeout time.Duration
-	// Idle timeout to close the connection
+	// Idle timeout to close connections waiting for a request
 	IdleTimeout time.Duration
+	// MaxConnections closes new connections beyond this number if not zero
+	MaxConnections int
+	// ConnState is called when a connection changes state if set
+	ConnState func(conn net.Conn, state ConnState)
 	// Transmission logger
 	Logger *log.Logger
+	// Structured logger, frames are logged at debug level and
+	// connection events at info level
+	StructuredLogger *slog.Logger
+	// Observer is notified of every handled request
+	Observer Observer
+	// Capture records every request and response if set
+	Capture *CaptureWriter
+	// Faults injects faults in responses if set
+	Faults *FaultInjector
+	// Access denies clients and requests if set
+	Access *AccessControl
 
 	// TCP connection
 	mu           sync.Mutex
 	conn         net.Listener
-	closeTimer   *time.Timer
-	lastActivity time.Time
+	conns        map[net.Conn]ConnState
+	shuttingDown bool
 }
 
+// ConnState is the state of a connection to a TcpServer.
+type ConnState int
+
+const (
+	// ConnStateNew is a connection just accepted
+	ConnStateNew ConnState = iota
+	// ConnStateIdle is a connection waiting for a request
+	ConnStateIdle
+	// ConnStateActive is a connection receiving or answering a request
+	ConnStateActive
+	// ConnStateClosed is a closed connection
+	ConnStateClosed
+	// ConnStateRejected is a connection closed because MaxConnections
+	// was reached or the server is shutting down
+	ConnStateRejected
+)
+
+var connStateNames = [...]string{
+	ConnStateNew:      "new",
+	ConnStateIdle:     "idle",
+	ConnStateActive:   "active",
+	ConnStateClosed:   "closed",
+	ConnStateRejected: "rejected",
+}
+
+// String returns the name of the state.
+func (s ConnState) String() string {
+	if s >= 0 && int(s) < len(connStateNames) {
+		return connStateNames[s]
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// ErrServerClosed is returned by TcpServer.Serve after Shutdown or Close.
+var ErrServerClosed = errors.New("modbus: server closed")
+
+// Delays between retries of failed accepts
+const (
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = time.Second
+	// Interval at which Shutdown checks that connections are closed
+	shutdownPollInterval = 10 * time.Millisecond
+)
+
+// NewTcpServer listens on port of all interfaces, or on a port chosen by
+// the system if zero.
 func NewTcpServer(port int) (*TcpServer, error) {
-	var err error
-	s := &TcpServer{port: port}
-	s.conn, err = net.Listen("tcp", ":"+strconv.Itoa(port))
+	return NewTcpServerAddress(":" + strconv.Itoa(port))
+}
+
+// NewTcpServerAddress listens on address, e.g. "127.0.0.1:502" or
+// "[::1]:0". The server does not accept connections until served.
+func NewTcpServerAddress(address string) (*TcpServer, error) {
+	ln, err := net.Listen("tcp", address)
 	if err != nil {
 		return nil, err
 	}
-	return s, nil
+	return NewTcpServerListener(ln), nil
+}
+
+// NewTcpServerListener allocates a server accepting connections from ln,
+// e.g. a TLS listener or one passed by the service manager. The listener is
+// closed with the server.
+func NewTcpServerListener(ln net.Listener) *TcpServer {
+	return &TcpServer{conn: ln}
 }
 
+// serverHandler returns the response of a request, or nil if no response
+// should be sent.
 type serverHandler func(pdu *PDUwithSlaveid) *PDUwithSlaveid
 
 type mbHandler interface {
@@ -70,92 +146,309 @@ func encodeMbError(uintid, fc, ex byte) *PDUwithSlaveid {
 		}}
 }
 
+// ServeModbus accepts connections and serves requests with handler.
 func (mb *TcpServer) ServeModbus(handler mbHandler) {
-	mb.serve(func(pdu *PDUwithSlaveid) *PDUwithSlaveid {
-		var respPdu *PDUwithSlaveid
-		switch pdu.FunctionCode {
-		case FuncCodeReadHoldingRegisters:
-			address := binary.BigEndian.Uint16(pdu.Data)
-			quantity := binary.BigEndian.Uint16(pdu.Data[2:])
-			if data, err := handler.ReadHoldingRegisters(pdu.SlaveID, address, quantity); err != nil {
-				respPdu = encodeMbError(pdu.SlaveID, pdu.FunctionCode, ExceptionCodeIllegalDataAddress)
-			} else {
-				lenbyte := []byte{byte(len(data) * 2)}
-
-				respPdu = &PDUwithSlaveid{pdu.SlaveID,
-					ProtocolDataUnit{
-						FunctionCode: pdu.FunctionCode,
-						Data:         append(lenbyte, dataBlock(data...)...),
-					}}
-			}
-		case FuncCodeWriteSingleRegister:
-			address := binary.BigEndian.Uint16(pdu.Data)
-			value := binary.BigEndian.Uint16(pdu.Data[2:])
-			if err := handler.WriteSingleRegister(pdu.SlaveID, address, value); err != nil {
-				respPdu = encodeMbError(pdu.SlaveID, pdu.FunctionCode, ExceptionCodeIllegalDataAddress)
-			} else {
-				respPdu = pdu
-			}
-		default:
-			respPdu = encodeMbError(pdu.SlaveID, pdu.FunctionCode, ExceptionCodeIllegalFunction)
-		}
-		return respPdu
+	mb.Serve(context.Background(), handler)
+}
+
+// Serve accepts connections and serves requests with handler until ctx is
+// done, Shutdown or Close is called. Once ctx is done, no connection is
+// accepted, idle connections are closed and active ones are closed after
+// their response. It returns ctx.Err() or ErrServerClosed.
+func (mb *TcpServer) Serve(ctx context.Context, handler mbHandler) error {
+	return mb.serveContext(ctx, func(c net.Conn) serverHandler {
+		return mbServerHandler(handler, c.RemoteAddr().String())
+	})
+}
+
+// ServeHandler is like Serve with a Handler.
+func (mb *TcpServer) ServeHandler(ctx context.Context, handler Handler) error {
+	return mb.serveContext(ctx, func(c net.Conn) serverHandler {
+		return handlerServer(handler, transportTCP, c.RemoteAddr().String())
 	})
 }
+
+// Addr returns the network address the server is listening on.
+func (mb *TcpServer) Addr() net.Addr {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.conn == nil {
+		return nil
+	}
+	return mb.conn.Addr()
+}
+
+// serve accepts connections until the listener is closed.
 func (mb *TcpServer) serve(handler serverHandler) {
+	mb.serveContext(context.Background(), func(net.Conn) serverHandler { return handler })
+}
+
+// serveContext accepts connections and serves each one with the handler
+// returned by connHandler.
+func (mb *TcpServer) serveContext(ctx context.Context, connHandler func(net.Conn) serverHandler) error {
+	mb.mu.Lock()
+	ln := mb.conn
+	mb.mu.Unlock()
+	if ln == nil {
+		return ErrServerClosed
+	}
+	stop := context.AfterFunc(ctx, func() {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		mb.stopAccepting()
+	})
+	defer stop()
+	delay := time.Duration(0)
 	for {
-		if conn, err := mb.conn.Accept(); err == nil {
-			go func(c net.Conn) {
-				defer c.Close()
-				var data [tcpMaxLength]byte
-				for {
-					// Read header first
-					if _, err = io.ReadFull(c, data[:tcpHeaderSize]); err != nil {
-						continue
-					}
-					transactionId := binary.BigEndian.Uint16(data[:])
-					// Read length, ignore transaction & protocol id (4 bytes)
-					length := int(binary.BigEndian.Uint16(data[4:]))
-					if length <= 0 {
-						flush(c)
-						return
-					}
-					if length > (tcpMaxLength - (tcpHeaderSize - 1)) {
-						flush(c)
-						err = fmt.Errorf("modbus: length in response header '%v' must not greater than '%v'", length, tcpMaxLength-tcpHeaderSize+1)
-						return
-					}
-					// Skip unit id
-					length += tcpHeaderSize - 1
-					if _, err = io.ReadFull(c, data[tcpHeaderSize:length]); err != nil {
-						return
-					}
-
-					aduRequest := data[:length]
-					pdu, err := mb.packager.Decode(aduRequest)
-					if err != nil {
-						continue
-					}
-					resp := handler(pdu)
-					adu := make([]byte, tcpHeaderSize+1+len(resp.Data))
-
-					// Transaction identifier
-					binary.BigEndian.PutUint16(adu, uint16(transactionId))
-					// Protocol identifier
-					binary.BigEndian.PutUint16(adu[2:], tcpProtocolIdentifier)
-					// Length = sizeof(SlaveId) + sizeof(FunctionCode) + Data
-					binary.BigEndian.PutUint16(adu[4:], uint16(1+1+len(resp.Data)))
-					// Unit identifier
-					adu[6] = resp.SlaveID
-					// PDU
-					adu[tcpHeaderSize] = resp.FunctionCode
-					copy(adu[tcpHeaderSize+1:], resp.Data)
-					c.Write(adu)
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if mb.closed() {
+				return ErrServerClosed
+			}
+			// Retry temporary failures, e.g. too many open files
+			if !errors.Is(err, net.ErrClosed) {
+				if delay = 2 * delay; delay == 0 {
+					delay = acceptMinDelay
+				} else if delay > acceptMaxDelay {
+					delay = acceptMaxDelay
 				}
-			}(conn)
+				mb.logf("modbus: accept error: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
 		}
+		delay = 0
+		if !mb.Access.allowConn(conn.RemoteAddr()) || !mb.trackConn(conn) {
+			slogEvent(mb.StructuredLogger, "modbus: rejected connection", transportTCP, conn.RemoteAddr().String())
+			conn.Close()
+			mb.connState(conn, ConnStateRejected)
+			continue
+		}
+		go mb.serveConn(conn, connHandler(conn))
+	}
+}
+
+// closed returns true once Shutdown or Close is called.
+func (mb *TcpServer) closed() bool {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.conn == nil || mb.shuttingDown
+}
+
+// trackConn adds a new connection unless the server is shutting down or
+// MaxConnections is reached.
+func (mb *TcpServer) trackConn(c net.Conn) bool {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.shuttingDown || (mb.MaxConnections > 0 && len(mb.conns) >= mb.MaxConnections) {
+		return false
+	}
+	if mb.conns == nil {
+		mb.conns = make(map[net.Conn]ConnState)
+	}
+	mb.conns[c] = ConnStateNew
+	return true
+}
+
+// setState records the state of a connection, it returns false if the
+// connection must be closed because the server is shutting down.
+func (mb *TcpServer) setState(c net.Conn, state ConnState) bool {
+	mb.mu.Lock()
+	if mb.conns == nil {
+		mb.conns = make(map[net.Conn]ConnState)
+	}
+	if state == ConnStateClosed {
+		delete(mb.conns, c)
+	} else {
+		mb.conns[c] = state
+	}
+	ok := !mb.shuttingDown || state != ConnStateIdle
+	mb.mu.Unlock()
+	mb.connState(c, state)
+	return ok
+}
+
+func (mb *TcpServer) connState(c net.Conn, state ConnState) {
+	if mb.ConnState != nil {
+		mb.ConnState(c, state)
+	}
+}
+
+// stopAccepting closes the listener and idle connections. Caller must
+// hold the mutex.
+func (mb *TcpServer) stopAccepting() {
+	mb.shuttingDown = true
+	if mb.conn != nil {
+		mb.conn.Close()
+		mb.conn = nil
+	}
+	for c, state := range mb.conns {
+		if state == ConnStateIdle || state == ConnStateNew {
+			c.Close()
+		}
+	}
+}
+
+// Shutdown stops accepting connections, closes idle connections and waits
+// for active connections to send their response and close. If ctx is done
+// before, the remaining connections are closed and ctx.Err() is returned.
+func (mb *TcpServer) Shutdown(ctx context.Context) error {
+	mb.mu.Lock()
+	mb.stopAccepting()
+	mb.mu.Unlock()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		mb.mu.Lock()
+		n := len(mb.conns)
+		mb.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			mb.mu.Lock()
+			for c := range mb.conns {
+				c.Close()
+			}
+			mb.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Connections returns the number of open connections.
+func (mb *TcpServer) Connections() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return len(mb.conns)
+}
+
+// serveConn reads requests from the connection and writes responses
+// until the connection is closed, idle for IdleTimeout, a frame is invalid
+// or the server is shutting down.
+func (mb *TcpServer) serveConn(c net.Conn, handler serverHandler) {
+	var err error
+	remote := c.RemoteAddr().String()
+	mb.setState(c, ConnStateNew)
+	slogEvent(mb.StructuredLogger, "modbus: accepted connection", transportTCP, remote)
+	defer func() {
+		c.Close()
+		if mb.Capture != nil {
+			mb.Capture.CloseTCP(c.RemoteAddr(), c.LocalAddr())
+		}
+		mb.setState(c, ConnStateClosed)
+		slogEvent(mb.StructuredLogger, "modbus: connection closed", transportTCP, remote, "error", err)
+	}()
+	var data [tcpMaxLength]byte
+	for {
+		if !mb.setState(c, ConnStateIdle) {
+			return
+		}
+		// Wait for the first byte of the header
+		c.SetReadDeadline(mb.deadline(mb.IdleTimeout))
+		if _, err = io.ReadFull(c, data[:1]); err != nil {
+			return
+		}
+		mb.setState(c, ConnStateActive)
+		c.SetReadDeadline(mb.deadline(mb.Timeout))
+		if _, err = io.ReadFull(c, data[1:tcpHeaderSize]); err != nil {
+			return
+		}
+		transactionId := binary.BigEndian.Uint16(data[:])
+		// Read length, ignore transaction & protocol id (4 bytes)
+		length := int(binary.BigEndian.Uint16(data[4:]))
+		if length <= 0 {
+			flush(c)
+			return
+		}
+		if length > (tcpMaxLength - (tcpHeaderSize - 1)) {
+			flush(c)
+			err = fmt.Errorf("modbus: length in response header '%v' must not greater than '%v'", length, tcpMaxLength-tcpHeaderSize+1)
+			return
+		}
+		// Skip unit id
+		length += tcpHeaderSize - 1
+		if _, err = io.ReadFull(c, data[tcpHeaderSize:length]); err != nil {
+			return
+		}
+
+		aduRequest := data[:length]
+		received := time.Now()
+		mb.logf("modbus: received % x", aduRequest)
+		slogFrame(mb.StructuredLogger, "modbus: received", transportTCP, remote, aduRequest, 0)
+		if mb.Capture != nil {
+			mb.Capture.WriteTCP(c.RemoteAddr(), c.LocalAddr(), true, aduRequest, received)
+		}
+		var pdu *PDUwithSlaveid
+		if pdu, err = mb.packager.Decode(aduRequest); err != nil {
+			continue
+		}
+		o := observeRequest(mb.Observer, pdu, aduRequest, remote)
+		resp, drop := mb.Access.deny(pdu, c.RemoteAddr(), remote)
+		if drop {
+			err = fmt.Errorf("modbus: request denied")
+			observeResponse(mb.Observer, o, pdu, nil, nil, err)
+			return
+		}
+		if resp == nil {
+			resp = handler(pdu)
+		}
+		fault := mb.Faults.fault(pdu)
+		if fault != nil {
+			mb.logf("modbus: injecting fault %+v", *fault)
+			resp = fault.response(pdu, resp)
+		}
+		if resp == nil {
+			observeResponse(mb.Observer, o, pdu, nil, nil, nil)
+			continue
+		}
+		adu := make([]byte, tcpHeaderSize+1+len(resp.Data))
+
+		// Transaction identifier
+		binary.BigEndian.PutUint16(adu, uint16(transactionId))
+		// Protocol identifier
+		binary.BigEndian.PutUint16(adu[2:], tcpProtocolIdentifier)
+		// Length = sizeof(SlaveId) + sizeof(FunctionCode) + Data
+		binary.BigEndian.PutUint16(adu[4:], uint16(1+1+len(resp.Data)))
+		// Unit identifier
+		adu[6] = resp.SlaveID
+		// PDU
+		adu[tcpHeaderSize] = resp.FunctionCode
+		copy(adu[tcpHeaderSize+1:], resp.Data)
+		if fault != nil {
+			adu = fault.frame(adu, FramingTCP)
+		}
+		mb.logf("modbus: sending % x", adu)
+		slogFrame(mb.StructuredLogger, "modbus: sending", transportTCP, remote, adu, time.Since(received))
+		closed := false
+		c.SetWriteDeadline(mb.deadline(mb.Timeout))
+		if fault != nil {
+			closed, err = fault.write(c, adu)
+		} else {
+			_, err = c.Write(adu)
+		}
+		if mb.Capture != nil {
+			mb.Capture.WriteTCP(c.RemoteAddr(), c.LocalAddr(), false, adu, time.Now())
+		}
+		observeResponse(mb.Observer, o, pdu, resp, adu, err)
+		if err != nil || closed {
+			return
+		}
+	}
+}
 
+// deadline returns the deadline of a timeout, none if it is zero.
+func (mb *TcpServer) deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
 	}
+	return time.Now().Add(timeout)
 }
 
 func (mb *TcpServer) logf(format string, v ...interface{}) {
@@ -164,8 +457,11 @@ func (mb *TcpServer) logf(format string, v ...interface{}) {
 	}
 }
 
-// closeLocked closes current connection. Caller must hold the mutex before calling this method.
+// Close closes the listener, connections being served are not closed.
+// Use Shutdown to close them.
 func (mb *TcpServer) Close() (err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
 	if mb.conn != nil {
 		err = mb.conn.Close()
 		mb.conn = nil
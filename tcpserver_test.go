@@ -1,7 +1,16 @@
 package modbus
 
-import "testing"
-import "fmt"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 type h struct {
 	data []uint16
@@ -23,23 +32,192 @@ func (s *h) WriteSingleRegister(slaveid byte, address, value uint16) error {
 	return fmt.Errorf("out of range")
 }
 func Test_tcpServer_Serve(t *testing.T) {
+	for _, framing := range []Framing{FramingTCP, FramingRTU, FramingASCII} {
+		t.Run(framing.String(), func(t *testing.T) {
+			handler := NewLoopbackHandler(framing, &h{[]uint16{10001, 2, 3, 4, 5, 6, 7, 8, 9, 10}})
+			client := NewClient(handler)
 
-	// t.Run("simple modbus server", func(t *testing.T) {
-	// 	p, _ := NewTcpServer(502)
-	// 	p.serve(func(pdu *PDUwithSlaveid) *PDUwithSlaveid {
-	// 		fmt.Println(pdu)
-	// 		pdu.Data[0] = 2
-	// 		pdu.Data[1] = 10
-	// 		pdu.Data[2] = 10
-	// 		pdu.Data = pdu.Data[:3]
-	// 		return pdu
-	// 	})
-	// })
+			results, err := client.ReadHoldingRegisters(1, 0, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal([]byte{0x27, 0x11, 0, 2}, results) {
+				t.Fatalf("unexpected results: %v", results)
+			}
+			if _, err = client.WriteSingleRegister(1, 1, 42); err != nil {
+				t.Fatal(err)
+			}
+			if results, err = client.ReadHoldingRegisters(1, 1, 1); err != nil || !bytes.Equal([]byte{0, 42}, results) {
+				t.Fatalf("unexpected results: %v, %v", results, err)
+			}
+			_, err = client.ReadHoldingRegisters(1, 8, 5)
+			if mbErr, ok := err.(*ModbusError); !ok || mbErr.ExceptionCode != ExceptionCodeIllegalDataAddress {
+				t.Fatalf("expected illegal data address, actual %v", err)
+			}
+			_, err = client.ReadCoils(1, 0, 1)
+			if mbErr, ok := err.(*ModbusError); !ok || mbErr.ExceptionCode != ExceptionCodeIllegalFunction {
+				t.Fatalf("expected illegal function, actual %v", err)
+			}
+		})
+	}
+}
+
+func TestTcpServerShutdown(t *testing.T) {
+	server, err := NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	states := make(map[ConnState]int)
+	server.ConnState = func(c net.Conn, state ConnState) {
+		mu.Lock()
+		states[state]++
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, &h{make([]uint16, 10)}) }()
+
+	client := clientOf(server.Addr().String())
+	if _, err = client.ReadHoldingRegisters(1, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.Connections(); n != 1 {
+		t.Fatalf("connections expected 1, actual %v", n)
+	}
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+	if err = server.Shutdown(ctx2); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != ErrServerClosed {
+		t.Fatalf("error expected %v, actual %v", ErrServerClosed, err)
+	}
+	if n := server.Connections(); n != 0 {
+		t.Fatalf("connections expected 0, actual %v", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if states[ConnStateNew] != 1 || states[ConnStateActive] != 1 || states[ConnStateClosed] != 1 {
+		t.Fatalf("unexpected states %v", states)
+	}
+}
+
+func TestTcpServerServeContext(t *testing.T) {
+	server, err := NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, &h{make([]uint16, 10)}) }()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Fatalf("error expected %v, actual %v", context.Canceled, err)
+	}
+	// Idle connections are closed
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("error expected %v, actual %v", io.EOF, err)
+	}
+}
+
+func TestTcpServerLimits(t *testing.T) {
+	server, err := NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+	server.MaxConnections = 1
+	server.IdleTimeout = 100 * time.Millisecond
+	rejected := make(chan net.Conn, 1)
+	server.ConnState = func(c net.Conn, state ConnState) {
+		if state == ConnStateRejected {
+			rejected <- c
+		}
+	}
+	go server.ServeModbus(&h{make([]uint16, 10)})
 
-	t.Run("memory modbus server", func(t *testing.T) {
+	first, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	select {
+	case <-rejected:
+	case <-time.After(time.Second):
+		t.Fatal("connection beyond MaxConnections not rejected")
+	}
+	// The first connection is closed once idle for IdleTimeout
+	first.SetReadDeadline(time.Now().Add(time.Second))
+	start := time.Now()
+	if _, err = first.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("error expected %v, actual %v", io.EOF, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("idle connection closed after %v", elapsed)
+	}
+}
+
+// countingListener counts accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
 
-		p, _ := NewTcpServer(502)
-		p.ServeModbus(&h{[]uint16{10001, 2, 3, 4, 5, 6, 7, 8, 9, 10}})
-	})
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return c, err
+}
 
+func TestNewTcpServerListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingListener{Listener: ln}
+	server := NewTcpServerListener(counting)
+	if server.Addr().String() != ln.Addr().String() {
+		t.Fatalf("address expected %v, actual %v", ln.Addr(), server.Addr())
+	}
+	go server.ServeModbus(&h{[]uint16{7}})
+	if results, err := clientOf(ln.Addr().String()).ReadHoldingRegisters(1, 0, 1); err != nil || !bytes.Equal([]byte{0, 7}, results) {
+		t.Fatalf("unexpected results: %v, %v", results, err)
+	}
+	if atomic.LoadInt32(&counting.accepted) != 1 {
+		t.Fatalf("connection not accepted from the listener")
+	}
+	server.Close()
+	if _, err = net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Fatal("listener not closed")
+	}
+}
+
+func TestNewTcpServerAddress(t *testing.T) {
+	server, err := NewTcpServerAddress("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	addr := server.Addr().(*net.TCPAddr)
+	if !addr.IP.IsLoopback() || addr.Port == 0 {
+		t.Fatalf("unexpected address %v", addr)
+	}
+	if _, err = NewTcpServerAddress(addr.String()); err == nil {
+		t.Fatal("address in use: error expected")
+	}
 }
@@ -14,18 +14,14 @@ import (
 	"github.com/mythay/modbus"
 )
 
-const (
-	asciiDevice = "/dev/pts/21"
-)
-
 func TestASCIIClient(t *testing.T) {
-	// Diagslave does not support broadcast id.
-	handler := modbus.NewASCIIClientHandler(asciiDevice)
+	handler := modbus.NewASCIIClientHandler(startSerialServer(t, modbus.FramingASCII))
+	defer handler.Close()
 	ClientTestAll(t, modbus.NewClient(handler))
 }
 
 func TestASCIIClientAdvancedUsage(t *testing.T) {
-	handler := modbus.NewASCIIClientHandler(asciiDevice)
+	handler := modbus.NewASCIIClientHandler(startSerialServer(t, modbus.FramingASCII))
 	handler.Baud = 19200
 	handler.Size = 8
 	handler.Parity = serial.ParityEven
@@ -38,11 +34,11 @@ func TestASCIIClientAdvancedUsage(t *testing.T) {
 	defer handler.Close()
 
 	client := modbus.NewClient(handler)
-	results, err := client.ReadDiscreteInputs(12, 15, 2)
+	results, err := client.ReadDiscreteInputs(SlaveID, 15, 2)
 	if err != nil || results == nil {
 		t.Fatal(err, results)
 	}
-	results, err = client.ReadWriteMultipleRegisters(12, 0, 2, 2, 2, []byte{1, 2, 3, 4})
+	results, err = client.ReadWriteMultipleRegisters(SlaveID, 0, 2, 2, 2, []byte{1, 2, 3, 4})
 	if err != nil || results == nil {
 		t.Fatal(err, results)
 	}
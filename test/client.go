@@ -10,94 +10,146 @@ import (
 	"github.com/mythay/modbus"
 )
 
+// The ClientTest functions check a Client against a device holding the
+// data set of NewDataModel. Each function uses its own addresses so they
+// can run in any order on the same device.
+
+// packBits packs coil states the way they are sent on the wire.
+func packBits(states ...bool) []byte {
+	data := make([]byte, (len(states)+7)/8)
+	for i, v := range states {
+		if v {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
+
+// registers encodes register values the way they are sent on the wire.
+func registers(values ...uint16) []byte {
+	data := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		data = append(data, byte(v>>8), byte(v))
+	}
+	return data
+}
+
 func ClientTestReadCoils(t *testing.T, client modbus.Client) {
 	// Read discrete outputs 20-38:
 	address := uint16(0x0013)
 	quantity := uint16(0x0013)
-	results, err := client.ReadCoils(1, address, quantity)
+	results, err := client.ReadCoils(SlaveID, address, quantity)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 3, len(results))
+	var expected []bool
+	for i := address; i < address+quantity; i++ {
+		expected = append(expected, Coil(i))
+	}
+	AssertBytes(t, packBits(expected...), results)
 }
 
 func ClientTestReadDiscreteInputs(t *testing.T, client modbus.Client) {
 	// Read discrete inputs 197-218
 	address := uint16(0x00C4)
 	quantity := uint16(0x0016)
-	results, err := client.ReadDiscreteInputs(1, address, quantity)
+	results, err := client.ReadDiscreteInputs(SlaveID, address, quantity)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 3, len(results))
+	var expected []bool
+	for i := address; i < address+quantity; i++ {
+		expected = append(expected, DiscreteInput(i))
+	}
+	AssertBytes(t, packBits(expected...), results)
 }
 
 func ClientTestReadHoldingRegisters(t *testing.T, client modbus.Client) {
 	// Read registers 108-110
 	address := uint16(0x006B)
 	quantity := uint16(0x0003)
-	results, err := client.ReadHoldingRegisters(1, address, quantity)
+	results, err := client.ReadHoldingRegisters(SlaveID, address, quantity)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 6, len(results))
+	AssertBytes(t, registers(HoldingRegister(0x6B), HoldingRegister(0x6C), HoldingRegister(0x6D)), results)
 }
 
 func ClientTestReadInputRegisters(t *testing.T, client modbus.Client) {
 	// Read input register 9
 	address := uint16(0x0008)
 	quantity := uint16(0x0001)
-	results, err := client.ReadInputRegisters(1, address, quantity)
+	results, err := client.ReadInputRegisters(SlaveID, address, quantity)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 2, len(results))
+	AssertBytes(t, registers(InputRegister(8)), results)
 }
 
 func ClientTestWriteSingleCoil(t *testing.T, client modbus.Client) {
 	// Write coil 173 ON
 	address := uint16(0x00AC)
 	value := uint16(0xFF00)
-	results, err := client.WriteSingleCoil(1, address, value)
+	results, err := client.WriteSingleCoil(SlaveID, address, value)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 2, len(results))
+	AssertBytes(t, []byte{0xFF, 0x00}, results)
+	results, err = client.ReadCoils(SlaveID, address, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertBytes(t, []byte{0x01}, results)
 }
 
 func ClientTestWriteSingleRegister(t *testing.T, client modbus.Client) {
 	// Write register 2 to 00 03 hex
 	address := uint16(0x0001)
 	value := uint16(0x0003)
-	results, err := client.WriteSingleRegister(1, address, value)
+	results, err := client.WriteSingleRegister(SlaveID, address, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertBytes(t, []byte{0x00, 0x03}, results)
+	results, err = client.ReadHoldingRegisters(SlaveID, address, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 2, len(results))
+	AssertBytes(t, []byte{0x00, 0x03}, results)
 }
 
 func ClientTestWriteMultipleCoils(t *testing.T, client modbus.Client) {
-	// Write a series of 10 coils starting at coil 20
-	address := uint16(0x0013)
+	// Write a series of 10 coils starting at coil 41
+	address := uint16(0x0028)
 	quantity := uint16(0x000A)
 	values := []byte{0xCD, 0x01}
-	results, err := client.WriteMultipleCoils(1, address, quantity, values)
+	results, err := client.WriteMultipleCoils(SlaveID, address, quantity, values)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 2, len(results))
+	AssertBytes(t, []byte{0x00, 0x0A}, results)
+	results, err = client.ReadCoils(SlaveID, address, quantity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertBytes(t, values, results)
 }
 
 func ClientTestWriteMultipleRegisters(t *testing.T, client modbus.Client) {
-	// Write two registers starting at 2 to 00 0A and 01 02 hex
-	address := uint16(0x0001)
+	// Write two registers starting at 17 to 00 0A and 01 02 hex
+	address := uint16(0x0010)
 	quantity := uint16(0x0002)
 	values := []byte{0x00, 0x0A, 0x01, 0x02}
-	results, err := client.WriteMultipleRegisters(1, address, quantity, values)
+	results, err := client.WriteMultipleRegisters(SlaveID, address, quantity, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertBytes(t, []byte{0x00, 0x02}, results)
+	results, err = client.ReadHoldingRegisters(SlaveID, address, quantity)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 2, len(results))
+	AssertBytes(t, values, results)
 }
 
 func ClientTestMaskWriteRegisters(t *testing.T, client modbus.Client) {
@@ -105,37 +157,78 @@ func ClientTestMaskWriteRegisters(t *testing.T, client modbus.Client) {
 	address := uint16(0x0004)
 	andMask := uint16(0x00F2)
 	orMask := uint16(0x0025)
-	results, err := client.MaskWriteRegister(1, address, andMask, orMask)
+	results, err := client.MaskWriteRegister(SlaveID, address, andMask, orMask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertBytes(t, []byte{0x00, 0xF2, 0x00, 0x25}, results)
+	results, err = client.ReadHoldingRegisters(SlaveID, address, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 4, len(results))
+	AssertBytes(t, registers(HoldingRegister(address)&andMask|orMask&^andMask), results)
 }
 
 func ClientTestReadWriteMultipleRegisters(t *testing.T, client modbus.Client) {
-	// read six registers starting at register 4, and to write three registers starting at register 15
-	address := uint16(0x0003)
+	// read six registers starting at register 49, and to write three registers starting at register 65
+	address := uint16(0x0030)
 	quantity := uint16(0x0006)
-	writeAddress := uint16(0x000E)
+	writeAddress := uint16(0x0040)
 	writeQuantity := uint16(0x0003)
 	values := []byte{0x00, 0xFF, 0x00, 0xFF, 0x00, 0xFF}
-	results, err := client.ReadWriteMultipleRegisters(1, address, quantity, writeAddress, writeQuantity, values)
+	results, err := client.ReadWriteMultipleRegisters(SlaveID, address, quantity, writeAddress, writeQuantity, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []uint16
+	for i := address; i < address+quantity; i++ {
+		expected = append(expected, HoldingRegister(i))
+	}
+	AssertBytes(t, registers(expected...), results)
+	// The write operation is performed before the read
+	results, err = client.ReadWriteMultipleRegisters(SlaveID, writeAddress, writeQuantity, writeAddress, writeQuantity, values)
 	if err != nil {
 		t.Fatal(err)
 	}
-	AssertEquals(t, 12, len(results))
+	AssertBytes(t, values, results)
 }
 
 func ClientTestReadFIFOQueue(t *testing.T, client modbus.Client) {
-	// Read queue starting at the pointer register 1246
-	address := uint16(0x04DE)
-	results, err := client.ReadFIFOQueue(1, address)
-	// Server not implemented
+	// Read queue starting at the pointer register 1247
+	results, err := client.ReadFIFOQueue(SlaveID, FIFOAddress)
 	if err != nil {
-		AssertEquals(t, "modbus: exception '1' (illegal function), function '152'", err.Error())
-	} else {
-		AssertEquals(t, 0, len(results))
+		t.Fatal(err)
 	}
+	AssertBytes(t, registers(FIFOValues...), results)
+}
+
+func ClientTestExceptions(t *testing.T, client modbus.Client) {
+	var err error
+	_, err = client.ReadCoils(SlaveID, TableSize-1, 2)
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.ReadDiscreteInputs(SlaveID, TableSize, 1)
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.ReadHoldingRegisters(SlaveID, TableSize-2, 3)
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.ReadInputRegisters(SlaveID, 0xFFFF, 1)
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.WriteSingleCoil(SlaveID, TableSize, 0xFF00)
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.WriteSingleRegister(SlaveID, TableSize, 1)
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.WriteMultipleCoils(SlaveID, TableSize-1, 2, []byte{0x03})
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.WriteMultipleRegisters(SlaveID, TableSize-1, 2, []byte{0, 1, 0, 2})
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.MaskWriteRegister(SlaveID, TableSize, 0xFFFF, 0)
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.ReadWriteMultipleRegisters(SlaveID, TableSize, 1, 0, 1, []byte{0, 1})
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	_, err = client.ReadFIFOQueue(SlaveID, 0)
+	AssertException(t, modbus.ExceptionCodeIllegalDataAddress, err)
+	// FIFO count is greater than 31
+	_, err = client.ReadFIFOQueue(SlaveID, FIFOOverflowAddress)
+	AssertException(t, modbus.ExceptionCodeIllegalDataValue, err)
 }
 
 func ClientTestAll(t *testing.T, client modbus.Client) {
@@ -150,4 +243,5 @@ func ClientTestAll(t *testing.T, client modbus.Client) {
 	ClientTestMaskWriteRegisters(t, client)
 	ClientTestReadWriteMultipleRegisters(t, client)
 	ClientTestReadFIFOQueue(t, client)
+	ClientTestExceptions(t, client)
 }
@@ -5,13 +5,44 @@
 package test
 
 import (
+	"bytes"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/mythay/modbus"
 )
 
 func AssertEquals(t *testing.T, expected, actual interface{}) {
-	_, file, line, ok := runtime.Caller(1)
+	if expected != actual {
+		t.Logf("%s: Expected: %+v (%T), actual: %+v (%T)", caller(),
+			expected, expected, actual, actual)
+		t.FailNow()
+	}
+}
+
+// AssertBytes fails the test if the byte slices are not equal.
+func AssertBytes(t *testing.T, expected, actual []byte) {
+	if !bytes.Equal(expected, actual) {
+		t.Logf("%s: Expected: % x, actual: % x", caller(), expected, actual)
+		t.FailNow()
+	}
+}
+
+// AssertException fails the test if err is not a modbus exception with
+// the given code.
+func AssertException(t *testing.T, code byte, err error) {
+	mbError, ok := err.(*modbus.ModbusError)
+	if !ok || mbError.ExceptionCode != code {
+		t.Logf("%s: Expected: exception '%v', actual: %v", caller(), code, err)
+		t.FailNow()
+	}
+}
+
+// caller returns file name and line of the assertion call.
+func caller() string {
+	_, file, line, ok := runtime.Caller(2)
 	if !ok {
 		file = "???"
 		line = 0
@@ -22,10 +53,5 @@ func AssertEquals(t *testing.T, expected, actual interface{}) {
 			file = file[idx+1:]
 		}
 	}
-
-	if expected != actual {
-		t.Logf("%s:%d: Expected: %+v (%T), actual: %+v (%T)", file, line,
-			expected, expected, actual, actual)
-		t.FailNow()
-	}
+	return file + ":" + strconv.Itoa(line)
 }
@@ -13,19 +13,14 @@ import (
 	"github.com/tarm/serial"
 )
 
-const (
-	rtuDevice = "COM1"
-)
-
-// func TestRTUClient(t *testing.T) {
-// 	// Diagslave does not support broadcast id.
-// 	handler := modbus.NewRTUClientHandler(rtuDevice)
-// 	defer handler.Close()
-// 	ClientTestAll(t, modbus.NewClient(handler))
-// }
+func TestRTUClient(t *testing.T) {
+	handler := modbus.NewRTUClientHandler(startSerialServer(t, modbus.FramingRTU))
+	defer handler.Close()
+	ClientTestAll(t, modbus.NewClient(handler))
+}
 
 func TestRTUClientAdvancedUsage(t *testing.T) {
-	handler := modbus.NewRTUClientHandler(rtuDevice)
+	handler := modbus.NewRTUClientHandler(startSerialServer(t, modbus.FramingRTU))
 	handler.Baud = 19200
 	handler.Size = 8
 	handler.Parity = serial.ParityEven
@@ -38,11 +33,11 @@ func TestRTUClientAdvancedUsage(t *testing.T) {
 	defer handler.Close()
 
 	client := modbus.NewClient(handler)
-	// results, err := client.ReadDiscreteInputs(11, 15, 2)
-	// if err != nil || results == nil {
-	// 	t.Fatal(err, results)
-	// }
-	results, err := client.ReadWriteMultipleRegisters(11, 0, 2, 2, 2, []byte{1, 2, 3, 4})
+	results, err := client.ReadDiscreteInputs(SlaveID, 15, 2)
+	if err != nil || results == nil {
+		t.Fatal(err, results)
+	}
+	results, err = client.ReadWriteMultipleRegisters(SlaveID, 0, 2, 2, 2, []byte{1, 2, 3, 4})
 	if err != nil || results == nil {
 		t.Fatal(err, results)
 	}
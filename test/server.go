@@ -0,0 +1,68 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license.  See the LICENSE file for details.
+
+package test
+
+import (
+	"github.com/mythay/modbus"
+)
+
+const (
+	// SlaveID is the slave id used by the conformance suite.
+	SlaveID = 1
+	// TableSize is the number of items of each table in the data set.
+	TableSize = 0x0500
+
+	// FIFOAddress is the pointer address of the FIFO queue in the data set.
+	FIFOAddress = 0x04DE
+	// FIFOOverflowAddress is the pointer address of a FIFO queue with more
+	// than 31 registers.
+	FIFOOverflowAddress = 0x04DF
+)
+
+// FIFOValues is the content of the FIFO queue at FIFOAddress.
+var FIFOValues = []uint16{0x01B8, 0x1284}
+
+// Coil returns the state of a coil in the data set.
+func Coil(address uint16) bool {
+	return address%3 == 0
+}
+
+// DiscreteInput returns the state of a discrete input in the data set.
+func DiscreteInput(address uint16) bool {
+	return address%2 == 1
+}
+
+// HoldingRegister returns the value of a holding register in the data set.
+func HoldingRegister(address uint16) uint16 {
+	return 0x1000 + address
+}
+
+// InputRegister returns the value of an input register in the data set.
+func InputRegister(address uint16) uint16 {
+	return 0x2000 + address
+}
+
+// NewDataModel returns a data model loaded with the data set expected by
+// the ClientTest functions. A device under test must hold the same data.
+func NewDataModel() *modbus.DataModel {
+	m := modbus.NewDataModel(TableSize, TableSize, TableSize, TableSize)
+	coils := make([]bool, TableSize)
+	discreteInputs := make([]bool, TableSize)
+	holdingRegisters := make([]uint16, TableSize)
+	inputRegisters := make([]uint16, TableSize)
+	for i := uint16(0); i < TableSize; i++ {
+		coils[i] = Coil(i)
+		discreteInputs[i] = DiscreteInput(i)
+		holdingRegisters[i] = HoldingRegister(i)
+		inputRegisters[i] = InputRegister(i)
+	}
+	m.WriteMultipleCoils(SlaveID, 0, coils)
+	m.WriteDiscreteInputs(0, discreteInputs)
+	m.WriteMultipleRegisters(SlaveID, 0, holdingRegisters)
+	m.WriteInputRegisters(0, inputRegisters)
+	m.SetFIFOQueue(FIFOAddress, FIFOValues)
+	m.SetFIFOQueue(FIFOOverflowAddress, make([]uint16, 32))
+	return m
+}
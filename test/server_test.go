@@ -0,0 +1,33 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/mythay/modbus"
+	"github.com/mythay/modbus/internal/pty"
+)
+
+// startTCPServer serves the data set on a free local port and returns
+// its address.
+func startTCPServer(t *testing.T) string {
+	server, err := modbus.NewTcpServer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeModbus(NewDataModel())
+	t.Cleanup(func() { server.Close() })
+	return server.Addr().String()
+}
+
+// startSerialServer serves the data set on a pseudo terminal and returns
+// the device name to be opened by clients.
+func startSerialServer(t *testing.T, framing modbus.Framing) string {
+	master, name, err := pty.Open()
+	if err != nil {
+		t.Skip(err)
+	}
+	server := modbus.NewSerialServer(master, framing)
+	go server.ServeModbus(NewDataModel())
+	t.Cleanup(func() { server.Close() })
+	return name
+}
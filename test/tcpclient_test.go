@@ -13,32 +13,28 @@ import (
 	"github.com/mythay/modbus"
 )
 
-const (
-	tcpDevice = "localhost:5020"
-)
-
 func TestTCPClient(t *testing.T) {
-	client := modbus.TCPClient(tcpDevice)
+	client := modbus.TCPClient(startTCPServer(t))
 	ClientTestAll(t, client)
 }
 
 func TestTCPClientAdvancedUsage(t *testing.T) {
-	handler := modbus.NewTCPClientHandler(tcpDevice)
+	handler := modbus.NewTCPClientHandler(startTCPServer(t))
 	handler.Timeout = 5 * time.Second
 	handler.Logger = log.New(os.Stdout, "tcp: ", log.LstdFlags)
 	handler.Connect()
 	defer handler.Close()
 
 	client := modbus.NewClient(handler)
-	results, err := client.ReadDiscreteInputs(17, 15, 2)
+	results, err := client.ReadDiscreteInputs(SlaveID, 15, 2)
 	if err != nil || results == nil {
 		t.Fatal(err, results)
 	}
-	results, err = client.WriteMultipleRegisters(17, 1, 2, []byte{0, 3, 0, 4})
+	results, err = client.WriteMultipleRegisters(SlaveID, 1, 2, []byte{0, 3, 0, 4})
 	if err != nil || results == nil {
 		t.Fatal(err, results)
 	}
-	results, err = client.WriteMultipleCoils(17, 5, 10, []byte{4, 3})
+	results, err = client.WriteMultipleCoils(SlaveID, 5, 10, []byte{4, 3})
 	if err != nil || results == nil {
 		t.Fatal(err, results)
 	}
@@ -0,0 +1,121 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+const tcpDefaultPort = "502"
+
+// URLHandler is a client handler which can be closed, as returned by
+// NewURLClientHandler.
+type URLHandler interface {
+	ClientHandler
+	Close() error
+}
+
+// NewURLClientHandler allocates a client handler of the device given by
+// rawurl, in one of the forms:
+//
+//	tcp://10.0.0.5:502?timeout=5s
+//	rtu:///dev/ttyUSB0?baud=9600&parity=N&databits=8&stopbits=1&timeout=1s
+//	ascii://COM1?baud=9600
+//
+// The TCP port defaults to 502, serial settings default to those of
+// NewRTUClientHandler and NewASCIIClientHandler.
+func NewURLClientHandler(rawurl string) (URLHandler, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	var timeout time.Duration
+	if v := query.Get("timeout"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("modbus: invalid timeout '%v'", v)
+		}
+	}
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("modbus: missing host in '%v'", rawurl)
+		}
+		address := u.Host
+		if u.Port() == "" {
+			address = net.JoinHostPort(u.Hostname(), tcpDefaultPort)
+		}
+		handler := NewTCPClientHandler(address)
+		if timeout > 0 {
+			handler.Timeout = timeout
+		}
+		return handler, nil
+	case "rtu", "ascii":
+		// rtu:///dev/ttyUSB0 has a path, rtu://COM1 has a host
+		name := u.Host + u.Path
+		if name == "" {
+			return nil, fmt.Errorf("modbus: missing serial port in '%v'", rawurl)
+		}
+		var handler URLHandler
+		var config *serial.Config
+		if u.Scheme == "rtu" {
+			h := NewRTUClientHandler(name)
+			handler, config = h, &h.Config
+		} else {
+			h := NewASCIIClientHandler(name)
+			handler, config = h, &h.Config
+		}
+		if err = parseSerialQuery(config, query, timeout); err != nil {
+			return nil, err
+		}
+		return handler, nil
+	}
+	return nil, fmt.Errorf("modbus: unsupported scheme '%v', expected tcp, rtu or ascii", u.Scheme)
+}
+
+// parseSerialQuery sets the serial configuration given in query.
+func parseSerialQuery(config *serial.Config, query url.Values, timeout time.Duration) (err error) {
+	if timeout > 0 {
+		config.ReadTimeout = timeout
+	}
+	for _, key := range []string{"baud", "databits", "stopbits"} {
+		v := query.Get(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("modbus: invalid %v '%v'", key, v)
+		}
+		switch key {
+		case "baud":
+			config.Baud = n
+		case "databits":
+			config.Size = byte(n)
+		case "stopbits":
+			config.StopBits = serial.StopBits(n)
+		}
+	}
+	if v := query.Get("parity"); v != "" {
+		switch strings.ToUpper(v) {
+		case "N", "NONE":
+			config.Parity = serial.ParityNone
+		case "E", "EVEN":
+			config.Parity = serial.ParityEven
+		case "O", "ODD":
+			config.Parity = serial.ParityOdd
+		default:
+			return fmt.Errorf("modbus: invalid parity '%v'", v)
+		}
+	}
+	return
+}
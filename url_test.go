@@ -0,0 +1,42 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+func TestNewURLClientHandler(t *testing.T) {
+	handler, err := NewURLClientHandler("tcp://10.0.0.5?timeout=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp := handler.(*TCPClientHandler)
+	if tcp.Address != "10.0.0.5:502" || tcp.Timeout != 2*time.Second {
+		t.Fatalf("unexpected tcp handler %v %v", tcp.Address, tcp.Timeout)
+	}
+
+	handler, err = NewURLClientHandler("rtu:///dev/ttyUSB0?baud=9600&parity=N&stopbits=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtu := handler.(*RTUClientHandler)
+	if rtu.Name != "/dev/ttyUSB0" || rtu.Baud != 9600 || rtu.Parity != serial.ParityNone || rtu.StopBits != 2 || rtu.Size != 8 {
+		t.Fatalf("unexpected rtu config %+v", rtu.Config)
+	}
+
+	handler, err = NewURLClientHandler("ascii://COM1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ascii := handler.(*ASCIIClientHandler); ascii.Name != "COM1" || ascii.Baud != 19200 || ascii.Size != 7 || ascii.Parity != serial.ParityEven {
+		t.Fatalf("unexpected ascii config %+v", ascii.Config)
+	}
+
+	for _, rawurl := range []string{"udp://host", "tcp://", "rtu://", "rtu:///dev/ttyS0?baud=x", "ascii://COM1?parity=X"} {
+		if _, err = NewURLClientHandler(rawurl); err == nil {
+			t.Fatalf("%v: error expected", rawurl)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+)
+
+// Largest quantities of the write requests
+const (
+	maxWriteBits = 1968
+	// Write quantity of function 23
+	maxReadWriteRegisters = 121
+)
+
+// ValidateRequest checks a request of a function defined in this package
+// as the specification requires: the length of the data, the quantity, the
+// byte count and the coil values are answered with an illegal data value
+// exception, ranges beyond the last address with an illegal data address
+// exception. Other functions are not checked. The returned error is a
+// *ModbusError.
+func ValidateRequest(pdu *ProtocolDataUnit) error {
+	data := pdu.Data
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		return validateRange(data, 4, 1, maxReadBits)
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		return validateRange(data, 4, 1, maxReadRegisters)
+	case FuncCodeWriteSingleCoil:
+		if len(data) != 4 {
+			return errIllegalDataValue
+		}
+		if value := binary.BigEndian.Uint16(data[2:]); value != 0xFF00 && value != 0x0000 {
+			return errIllegalDataValue
+		}
+	case FuncCodeWriteSingleRegister:
+		if len(data) != 4 {
+			return errIllegalDataValue
+		}
+	case FuncCodeWriteMultipleCoils:
+		if len(data) < 5 {
+			return errIllegalDataValue
+		}
+		quantity := int(binary.BigEndian.Uint16(data[2:]))
+		if int(data[4]) != (quantity+7)/8 {
+			return errIllegalDataValue
+		}
+		return validateRange(data, 5+int(data[4]), 1, maxWriteBits)
+	case FuncCodeWriteMultipleRegisters:
+		if len(data) < 5 {
+			return errIllegalDataValue
+		}
+		quantity := int(binary.BigEndian.Uint16(data[2:]))
+		if int(data[4]) != 2*quantity {
+			return errIllegalDataValue
+		}
+		return validateRange(data, 5+int(data[4]), 1, maxWriteRegisters)
+	case FuncCodeMaskWriteRegister:
+		if len(data) != 6 {
+			return errIllegalDataValue
+		}
+	case FuncCodeReadWriteMultipleRegisters:
+		if len(data) < 9 {
+			return errIllegalDataValue
+		}
+		writeQuantity := int(binary.BigEndian.Uint16(data[6:]))
+		if int(data[8]) != 2*writeQuantity {
+			return errIllegalDataValue
+		}
+		if err := validateRange(data, 9+int(data[8]), 1, maxReadRegisters); err != nil {
+			return err
+		}
+		return validateRange(data[4:], 5+int(data[8]), 1, maxReadWriteRegisters)
+	case FuncCodeReadFIFOQueue:
+		if len(data) != 2 {
+			return errIllegalDataValue
+		}
+	}
+	return nil
+}
+
+// validateRange checks the length of data, and the quantity and range of
+// the address and quantity it starts with.
+func validateRange(data []byte, length, min, max int) error {
+	if len(data) != length {
+		return errIllegalDataValue
+	}
+	address := int(binary.BigEndian.Uint16(data))
+	quantity := int(binary.BigEndian.Uint16(data[2:]))
+	if quantity < min || quantity > max {
+		return errIllegalDataValue
+	}
+	if address+quantity > 0x10000 {
+		return errIllegalDataAddress
+	}
+	return nil
+}
+
+// ValidateRequests is a middleware answering the requests failing
+// ValidateRequest with their exception.
+func ValidateRequests(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		if err := ValidateRequest(&ProtocolDataUnit{FunctionCode: r.FunctionCode, Data: r.Data}); err != nil {
+			w.WriteException(exceptionCode(err))
+			return
+		}
+		next.ServeModbus(w, r)
+	})
+}
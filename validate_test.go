@@ -0,0 +1,80 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestValidateRequest(t *testing.T) {
+	for i, c := range []struct {
+		fc        byte
+		data      []byte
+		exception byte
+	}{
+		{FuncCodeReadCoils, []byte{0, 0, 0x07, 0xD0}, 0},
+		{FuncCodeReadCoils, []byte{0, 0, 0x07, 0xD1}, ExceptionCodeIllegalDataValue},
+		{FuncCodeReadDiscreteInputs, []byte{0, 0, 0, 0}, ExceptionCodeIllegalDataValue},
+		{FuncCodeReadHoldingRegisters, []byte{0, 0}, ExceptionCodeIllegalDataValue},
+		{FuncCodeReadHoldingRegisters, []byte{0, 0, 0, 125}, 0},
+		{FuncCodeReadHoldingRegisters, []byte{0, 0, 0, 126}, ExceptionCodeIllegalDataValue},
+		{FuncCodeReadHoldingRegisters, []byte{0, 0, 0, 1, 0}, ExceptionCodeIllegalDataValue},
+		{FuncCodeReadInputRegisters, []byte{0xFF, 0xFF, 0, 1}, 0},
+		{FuncCodeReadInputRegisters, []byte{0xFF, 0xFF, 0, 2}, ExceptionCodeIllegalDataAddress},
+		{FuncCodeWriteSingleCoil, []byte{0, 1, 0xFF, 0}, 0},
+		{FuncCodeWriteSingleCoil, []byte{0, 1, 0, 1}, ExceptionCodeIllegalDataValue},
+		{FuncCodeWriteSingleRegister, []byte{0, 1, 0}, ExceptionCodeIllegalDataValue},
+		{FuncCodeWriteMultipleCoils, []byte{0, 0, 0, 9, 2, 0xFF, 1}, 0},
+		{FuncCodeWriteMultipleCoils, []byte{0, 0, 0, 9, 1, 0xFF}, ExceptionCodeIllegalDataValue},
+		{FuncCodeWriteMultipleCoils, []byte{0, 0, 0, 9, 2, 0xFF}, ExceptionCodeIllegalDataValue},
+		{FuncCodeWriteMultipleCoils, []byte{0xFF, 0xFF, 0, 9, 2, 0xFF, 1}, ExceptionCodeIllegalDataAddress},
+		{FuncCodeWriteMultipleRegisters, []byte{0, 0, 0, 1, 2, 0, 1}, 0},
+		{FuncCodeWriteMultipleRegisters, []byte{0, 0, 0, 0, 0}, ExceptionCodeIllegalDataValue},
+		{FuncCodeWriteMultipleRegisters, []byte{0, 0, 0, 2, 2, 0, 1}, ExceptionCodeIllegalDataValue},
+		{FuncCodeWriteMultipleRegisters, append([]byte{0, 0, 0, 124, 248}, make([]byte, 248)...), ExceptionCodeIllegalDataValue},
+		{FuncCodeMaskWriteRegister, []byte{0, 0, 0, 0, 0}, ExceptionCodeIllegalDataValue},
+		{FuncCodeReadWriteMultipleRegisters, []byte{0, 0, 0, 1, 0, 0, 0, 1, 2, 0, 0}, 0},
+		{FuncCodeReadWriteMultipleRegisters, []byte{0, 0, 0, 1, 0xFF, 0xFF, 0, 2, 4, 0, 0, 0, 0}, ExceptionCodeIllegalDataAddress},
+		{FuncCodeReadWriteMultipleRegisters, []byte{0, 0, 0, 126, 0, 0, 0, 1, 2, 0, 0}, ExceptionCodeIllegalDataValue},
+		{FuncCodeReadWriteMultipleRegisters, []byte{0, 0, 0, 1, 0, 0, 0, 1, 4, 0, 0}, ExceptionCodeIllegalDataValue},
+		{FuncCodeReadFIFOQueue, []byte{0, 0}, 0},
+		{FuncCodeReadFIFOQueue, []byte{0}, ExceptionCodeIllegalDataValue},
+	} {
+		err := ValidateRequest(&ProtocolDataUnit{FunctionCode: c.fc, Data: c.data})
+		if c.exception == 0 {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", i, err)
+			}
+		} else if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != c.exception {
+			t.Errorf("%v: exception %v expected, actual %v", i, c.exception, err)
+		}
+	}
+}
+
+func TestTcpServerShortRequest(t *testing.T) {
+	server, err := NewTcpServerAddress("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.ServeModbus(NewDataModel(0, 0, 10, 0))
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	// Read holding registers without quantity
+	if _, err = conn.Write([]byte{0, 1, 0, 0, 0, 4, 1, 3, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, 9)
+	if _, err = io.ReadFull(conn, response); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 1, 0, 0, 0, 3, 1, 0x83, ExceptionCodeIllegalDataValue}; !bytes.Equal(expected, response) {
+		t.Fatalf("response expected % x, actual % x", expected, response)
+	}
+}
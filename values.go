@@ -0,0 +1,202 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ValueType is the type of a value stored in one or more registers.
+type ValueType int
+
+const (
+	TypeUint16 ValueType = iota
+	TypeInt16
+	TypeUint32
+	TypeInt32
+	TypeFloat32
+	TypeUint64
+	TypeInt64
+	TypeFloat64
+)
+
+var valueTypeNames = [...]string{
+	TypeUint16:  "uint16",
+	TypeInt16:   "int16",
+	TypeUint32:  "uint32",
+	TypeInt32:   "int32",
+	TypeFloat32: "float32",
+	TypeUint64:  "uint64",
+	TypeInt64:   "int64",
+	TypeFloat64: "float64",
+}
+
+// ParseValueType returns the value type of the given name, e.g. "float32".
+func ParseValueType(name string) (ValueType, error) {
+	for t, n := range valueTypeNames {
+		if n == strings.ToLower(name) {
+			return ValueType(t), nil
+		}
+	}
+	return 0, fmt.Errorf("modbus: unknown value type '%v'", name)
+}
+
+// String returns the name of the value type.
+func (t ValueType) String() string {
+	if t >= 0 && int(t) < len(valueTypeNames) {
+		return valueTypeNames[t]
+	}
+	return fmt.Sprintf("type(%d)", int(t))
+}
+
+// Registers returns the number of registers holding a value.
+func (t ValueType) Registers() int {
+	switch t {
+	case TypeUint32, TypeInt32, TypeFloat32:
+		return 2
+	case TypeUint64, TypeInt64, TypeFloat64:
+		return 4
+	}
+	return 1
+}
+
+// ValueOrder tells how the bytes of a value are laid out in registers,
+// the default is big endian with the most significant register first.
+type ValueOrder struct {
+	// SwapBytes swaps the two bytes of each register
+	SwapBytes bool
+	// SwapWords puts the least significant register first
+	SwapWords bool
+}
+
+// ParseValueOrder returns the order of the bytes of a 32-bit value a, b, c,
+// d as stored in registers: "abcd" (big endian), "cdab" (word swap), "badc"
+// (byte swap) or "dcba" (little endian). Longer values follow the same order.
+func ParseValueOrder(order string) (ValueOrder, error) {
+	switch strings.ToLower(order) {
+	case "abcd", "":
+		return ValueOrder{}, nil
+	case "cdab":
+		return ValueOrder{SwapWords: true}, nil
+	case "badc":
+		return ValueOrder{SwapBytes: true}, nil
+	case "dcba":
+		return ValueOrder{SwapBytes: true, SwapWords: true}, nil
+	}
+	return ValueOrder{}, fmt.Errorf("modbus: unknown value order '%v'", order)
+}
+
+// String returns the order of the bytes of a 32-bit value.
+func (o ValueOrder) String() string {
+	switch {
+	case o.SwapBytes && o.SwapWords:
+		return "dcba"
+	case o.SwapWords:
+		return "cdab"
+	case o.SwapBytes:
+		return "badc"
+	}
+	return "abcd"
+}
+
+// reorder converts a value between register and big endian layout.
+func (o ValueOrder) reorder(data []byte) []byte {
+	b := make([]byte, len(data))
+	copy(b, data)
+	if o.SwapBytes {
+		for i := 0; i+1 < len(b); i += 2 {
+			b[i], b[i+1] = b[i+1], b[i]
+		}
+	}
+	if o.SwapWords {
+		for i, j := 0, len(b)-2; i < j; i, j = i+2, j-2 {
+			b[i], b[i+1], b[j], b[j+1] = b[j], b[j+1], b[i], b[i+1]
+		}
+	}
+	return b
+}
+
+// DecodeValues decodes register data, as returned by Client, in values of
+// type t: uint16, int16, uint32, int32, float32, uint64, int64 or float64.
+func DecodeValues(data []byte, t ValueType, order ValueOrder) ([]interface{}, error) {
+	size := 2 * t.Registers()
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("modbus: data length '%v' is not a multiple of '%v' %v size", len(data), t, size)
+	}
+	values := make([]interface{}, 0, len(data)/size)
+	for i := 0; i < len(data); i += size {
+		b := order.reorder(data[i : i+size])
+		var v interface{}
+		switch t {
+		case TypeUint16:
+			v = binary.BigEndian.Uint16(b)
+		case TypeInt16:
+			v = int16(binary.BigEndian.Uint16(b))
+		case TypeUint32:
+			v = binary.BigEndian.Uint32(b)
+		case TypeInt32:
+			v = int32(binary.BigEndian.Uint32(b))
+		case TypeFloat32:
+			v = math.Float32frombits(binary.BigEndian.Uint32(b))
+		case TypeUint64:
+			v = binary.BigEndian.Uint64(b)
+		case TypeInt64:
+			v = int64(binary.BigEndian.Uint64(b))
+		case TypeFloat64:
+			v = math.Float64frombits(binary.BigEndian.Uint64(b))
+		default:
+			return nil, fmt.Errorf("modbus: unknown value type '%v'", t)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// EncodeValue parses s as a value of type t and returns its registers.
+// Integers may be given in decimal, hexadecimal (0x) or binary (0b).
+func EncodeValue(s string, t ValueType, order ValueOrder) ([]byte, error) {
+	b := make([]byte, 2*t.Registers())
+	var err error
+	switch t {
+	case TypeUint16, TypeUint32, TypeUint64:
+		var v uint64
+		if v, err = strconv.ParseUint(s, 0, 16*t.Registers()); err == nil {
+			putUint(b, v)
+		}
+	case TypeInt16, TypeInt32, TypeInt64:
+		var v int64
+		if v, err = strconv.ParseInt(s, 0, 16*t.Registers()); err == nil {
+			putUint(b, uint64(v))
+		}
+	case TypeFloat32:
+		var v float64
+		if v, err = strconv.ParseFloat(s, 32); err == nil {
+			binary.BigEndian.PutUint32(b, math.Float32bits(float32(v)))
+		}
+	case TypeFloat64:
+		var v float64
+		if v, err = strconv.ParseFloat(s, 64); err == nil {
+			binary.BigEndian.PutUint64(b, math.Float64bits(v))
+		}
+	default:
+		return nil, fmt.Errorf("modbus: unknown value type '%v'", t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("modbus: invalid %v value '%v'", t, s)
+	}
+	return order.reorder(b), nil
+}
+
+// putUint puts the low bits of v in b in big endian order.
+func putUint(b []byte, v uint64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
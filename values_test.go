@@ -0,0 +1,84 @@
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestValueOrder(t *testing.T) {
+	tests := []struct {
+		order    string
+		expected []byte
+	}{
+		{"abcd", []byte{0x3F, 0xC0, 0x00, 0x00}},
+		{"cdab", []byte{0x00, 0x00, 0x3F, 0xC0}},
+		{"badc", []byte{0xC0, 0x3F, 0x00, 0x00}},
+		{"dcba", []byte{0x00, 0x00, 0xC0, 0x3F}},
+	}
+	for _, test := range tests {
+		order, err := ParseValueOrder(test.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.order != order.String() {
+			t.Fatalf("order expected %v, actual %v", test.order, order)
+		}
+		data, err := EncodeValue("1.5", TypeFloat32, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(test.expected, data) {
+			t.Fatalf("%v: data expected % x, actual % x", test.order, test.expected, data)
+		}
+		values, err := DecodeValues(data, TypeFloat32, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]interface{}{float32(1.5)}, values) {
+			t.Fatalf("%v: values expected %v, actual %v", test.order, 1.5, values)
+		}
+	}
+}
+
+func TestValueTypes(t *testing.T) {
+	tests := []struct {
+		typ      string
+		value    string
+		expected interface{}
+	}{
+		{"uint16", "0xFFFE", uint16(0xFFFE)},
+		{"int16", "-2", int16(-2)},
+		{"uint32", "70000", uint32(70000)},
+		{"int32", "-70000", int32(-70000)},
+		{"uint64", "18446744073709551615", uint64(18446744073709551615)},
+		{"int64", "-1", int64(-1)},
+		{"float64", "-0.25", float64(-0.25)},
+	}
+	for _, test := range tests {
+		typ, err := ParseValueType(test.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := EncodeValue(test.value, typ, ValueOrder{SwapWords: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 2*typ.Registers() {
+			t.Fatalf("%v: length expected %v, actual %v", typ, 2*typ.Registers(), len(data))
+		}
+		values, err := DecodeValues(data, typ, ValueOrder{SwapWords: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]interface{}{test.expected}, values) {
+			t.Fatalf("%v: values expected %v, actual %v", typ, test.expected, values)
+		}
+	}
+	if _, err := EncodeValue("70000", TypeUint16, ValueOrder{}); err == nil {
+		t.Fatal("error expected for out of range value")
+	}
+	if _, err := DecodeValues([]byte{0, 1, 0}, TypeUint16, ValueOrder{}); err == nil {
+		t.Fatal("error expected for odd data length")
+	}
+}
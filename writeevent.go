@@ -0,0 +1,172 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+// WriteEvent is a write of coils or holding registers of a DataModel by a
+// client request, when the DataModel is the handler of a server. Writes by
+// the methods of DataModel are not notified.
+type WriteEvent struct {
+	UnitID       byte
+	FunctionCode byte
+	Table        Table
+	Address      uint16
+	// Values of the written range before and after the write, coils are
+	// 0 or 1
+	OldValues []uint16
+	NewValues []uint16
+	// RemoteAddr is the address of the client, or the name of the serial
+	// port, empty if unknown
+	RemoteAddr string
+}
+
+// BeforeWrite adds a hook called before every write of a client, which is
+// denied if the hook returns an error. A *ModbusError is answered with its
+// exception code, other errors with an illegal data address exception.
+// Hooks are called with the data model locked and must not use it.
+func (m *DataModel) BeforeWrite(hook func(e *WriteEvent) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.beforeWrite = append(m.beforeWrite, hook)
+}
+
+// OnWrite adds a hook called after every write of a client, in the
+// goroutine serving the request before the response is sent.
+func (m *DataModel) OnWrite(hook func(e *WriteEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onWrite = append(m.onWrite, hook)
+}
+
+// dataModelClient writes to a DataModel on behalf of a client and notifies
+// the writes.
+type dataModelClient struct {
+	*DataModel
+	remote string
+}
+
+func (c *dataModelClient) event(slaveid, functionCode byte, table Table, address uint16) *WriteEvent {
+	return &WriteEvent{
+		UnitID:       slaveid,
+		FunctionCode: functionCode,
+		Table:        table,
+		Address:      address,
+		RemoteAddr:   c.remote,
+	}
+}
+
+func (c *dataModelClient) WriteSingleCoil(slaveid byte, address uint16, value bool) error {
+	e := c.event(slaveid, FuncCodeWriteSingleCoil, TableCoils, address)
+	return c.write(e, 1, func([]uint16) []uint16 { return coilValues([]bool{value}) })
+}
+
+func (c *dataModelClient) WriteMultipleCoils(slaveid byte, address uint16, values []bool) error {
+	e := c.event(slaveid, FuncCodeWriteMultipleCoils, TableCoils, address)
+	return c.write(e, len(values), func([]uint16) []uint16 { return coilValues(values) })
+}
+
+func (c *dataModelClient) WriteSingleRegister(slaveid byte, address, value uint16) error {
+	e := c.event(slaveid, FuncCodeWriteSingleRegister, TableHoldingRegisters, address)
+	return c.write(e, 1, func([]uint16) []uint16 { return []uint16{value} })
+}
+
+func (c *dataModelClient) WriteMultipleRegisters(slaveid byte, address uint16, values []uint16) error {
+	e := c.event(slaveid, FuncCodeWriteMultipleRegisters, TableHoldingRegisters, address)
+	return c.write(e, len(values), func([]uint16) []uint16 { return append([]uint16(nil), values...) })
+}
+
+func (c *dataModelClient) MaskWriteRegister(slaveid byte, address, andMask, orMask uint16) error {
+	e := c.event(slaveid, FuncCodeMaskWriteRegister, TableHoldingRegisters, address)
+	return c.write(e, 1, func(old []uint16) []uint16 {
+		return []uint16{(old[0] & andMask) | (orMask &^ andMask)}
+	})
+}
+
+func (c *dataModelClient) ReadWriteMultipleRegisters(slaveid byte, readAddress, readQuantity, writeAddress uint16, values []uint16) (results []uint16, err error) {
+	e := c.event(slaveid, FuncCodeReadWriteMultipleRegisters, TableHoldingRegisters, writeAddress)
+	m := c.DataModel
+	m.mu.Lock()
+	if !inRange(len(m.holdingRegisters), readAddress, readQuantity) {
+		m.mu.Unlock()
+		return nil, errIllegalDataAddress
+	}
+	err = m.writeLocked(e, len(values), func([]uint16) []uint16 { return append([]uint16(nil), values...) })
+	if err == nil {
+		results, err = readRegisters(m.holdingRegisters, readAddress, readQuantity)
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.notify(e)
+	return
+}
+
+// write writes the values returned by update from the current ones.
+func (c *dataModelClient) write(e *WriteEvent, quantity int, update func(old []uint16) []uint16) error {
+	m := c.DataModel
+	m.mu.Lock()
+	err := m.writeLocked(e, quantity, update)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	m.notify(e)
+	return nil
+}
+
+// writeLocked sets the old and new values of e, calls the BeforeWrite
+// hooks and writes the new values. Caller must hold the mutex.
+func (m *DataModel) writeLocked(e *WriteEvent, quantity int, update func(old []uint16) []uint16) error {
+	var length int
+	if e.Table == TableCoils {
+		length = len(m.coils)
+	} else {
+		length = len(m.holdingRegisters)
+	}
+	if int(e.Address)+quantity > length {
+		return errIllegalDataAddress
+	}
+	end := int(e.Address) + quantity
+	if e.Table == TableCoils {
+		e.OldValues = coilValues(m.coils[e.Address:end])
+	} else {
+		e.OldValues = append([]uint16(nil), m.holdingRegisters[e.Address:end]...)
+	}
+	e.NewValues = update(e.OldValues)
+	for _, hook := range m.beforeWrite {
+		if err := hook(e); err != nil {
+			return err
+		}
+	}
+	if e.Table == TableCoils {
+		for i, v := range e.NewValues {
+			m.coils[int(e.Address)+i] = v != 0
+		}
+	} else {
+		copy(m.holdingRegisters[e.Address:], e.NewValues)
+	}
+	return nil
+}
+
+// notify calls the OnWrite hooks. Caller must not hold the mutex.
+func (m *DataModel) notify(e *WriteEvent) {
+	m.mu.RLock()
+	hooks := m.onWrite
+	m.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(e)
+	}
+}
+
+// coilValues returns 0 or 1 for each coil.
+func coilValues(bits []bool) []uint16 {
+	values := make([]uint16, len(bits))
+	for i, b := range bits {
+		if b {
+			values[i] = 1
+		}
+	}
+	return values
+}
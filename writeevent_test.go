@@ -0,0 +1,69 @@
+package modbus
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDataModelWriteEvents(t *testing.T) {
+	model := NewDataModel(10, 0, 10, 0)
+	model.WriteSingleRegister(1, 2, 0x00F0)
+	var mu sync.Mutex
+	var events []WriteEvent
+	model.OnWrite(func(e *WriteEvent) {
+		mu.Lock()
+		events = append(events, *e)
+		mu.Unlock()
+	})
+	model.BeforeWrite(func(e *WriteEvent) error {
+		if e.Table == TableHoldingRegisters && e.Address == 9 {
+			return &ModbusError{ExceptionCode: ExceptionCodeIllegalDataValue}
+		}
+		return nil
+	})
+	server, err := NewTcpServerAddress("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.ServeModbus(model)
+	handler := NewTCPClientHandler(server.Addr().String())
+	defer handler.Close()
+	client := NewClient(handler)
+
+	if _, err = client.WriteSingleRegister(1, 0, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.MaskWriteRegister(2, 2, 0x0F0F, 0x1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteMultipleCoils(1, 3, 3, []byte{0x05}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.ReadWriteMultipleRegisters(1, 0, 1, 4, 2, []byte{0, 1, 0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.WriteSingleRegister(1, 9, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataValue {
+		t.Fatalf("vetoed write: illegal data value expected, actual %v", err)
+	}
+	if values, _ := model.ReadHoldingRegisters(1, 9, 1); values[0] != 0 {
+		t.Fatalf("vetoed write applied: %v", values)
+	}
+	// Writes of the application are not notified
+	model.WriteSingleRegister(1, 0, 8)
+
+	remote := handler.conn.LocalAddr().String()
+	expected := []WriteEvent{
+		{1, FuncCodeWriteSingleRegister, TableHoldingRegisters, 0, []uint16{0}, []uint16{7}, remote},
+		{2, FuncCodeMaskWriteRegister, TableHoldingRegisters, 2, []uint16{0x00F0}, []uint16{0x1000}, remote},
+		{1, FuncCodeWriteMultipleCoils, TableCoils, 3, []uint16{0, 0, 0}, []uint16{1, 0, 1}, remote},
+		{1, FuncCodeReadWriteMultipleRegisters, TableHoldingRegisters, 4, []uint16{0, 0}, []uint16{1, 2}, remote},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(expected, events) {
+		t.Fatalf("events expected %+v, actual %+v", expected, events)
+	}
+}